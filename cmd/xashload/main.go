@@ -0,0 +1,213 @@
+// Command xashload launches simulated players against a server and
+// reports connection success, packet rates, round trip times and, when the
+// server exports metrics, engine frame time and packet queue drops.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/yohimik/goxash3d-fwgs/pkg/client"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func main() {
+	scenarioPath := flag.String("scenario", "", "scenario file (YAML)")
+	target := flag.String("target", "", "udp://host:port or ws://host:27016/websocket, overrides the scenario")
+	players := flag.Int("players", 0, "number of players, overrides the scenario")
+	duration := flag.Duration("duration", 0, "test duration, overrides the scenario")
+	metricsURL := flag.String("metrics", "", "server /metrics URL, overrides the scenario")
+	token := flag.String("token", "", "bearer token for the signalling endpoint, overrides the scenario")
+	interval := flag.Duration("interval", 5*time.Second, "report interval")
+	flag.Parse()
+
+	s := &Scenario{}
+	if *scenarioPath != "" {
+		var err error
+		if s, err = loadScenario(*scenarioPath); err != nil {
+			fail(err)
+		}
+	}
+	if *target != "" {
+		s.Target = *target
+	}
+	if *players > 0 {
+		s.Players = *players
+		if *scenarioPath == "" {
+			s.Ramp = nil
+		}
+	}
+	if *duration > 0 {
+		s.Duration = Duration(*duration)
+	}
+	if *metricsURL != "" {
+		s.Metrics = *metricsURL
+	}
+	if *token != "" {
+		s.Token = *token
+	}
+	if err := s.validate(); err != nil {
+		fail(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.Duration))
+	defer cancel()
+
+	r := newRunner(s)
+	r.run(ctx, *interval)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// runner ramps players up and down and collects their statistics.
+type runner struct {
+	scenario *Scenario
+
+	attempts    atomic.Uint64
+	successes   atomic.Uint64
+	failures    atomic.Uint64
+	disconnects atomic.Uint64
+
+	mu       sync.Mutex
+	clients  map[*client.Client]struct{}
+	closed   client.Stats
+	connects []time.Duration
+	reasons  map[string]int
+}
+
+func newRunner(s *Scenario) *runner {
+	return &runner{
+		scenario: s,
+		clients:  make(map[*client.Client]struct{}),
+		reasons:  make(map[string]int),
+	}
+}
+
+// run follows the ramp profile until ctx ends, reporting every interval.
+func (r *runner) run(ctx context.Context, interval time.Duration) {
+	start := time.Now()
+	var (
+		wg      sync.WaitGroup
+		cancels []context.CancelFunc
+		spawned int
+	)
+	rep := newReporter(r, start)
+
+	control := time.NewTicker(100 * time.Millisecond)
+	defer control.Stop()
+	report := time.NewTicker(interval)
+	defer report.Stop()
+
+loop:
+	for {
+		want := r.scenario.playersAt(time.Since(start))
+		for len(cancels) < want {
+			pctx, cancel := context.WithCancel(ctx)
+			cancels = append(cancels, cancel)
+			spawned++
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				r.runPlayer(pctx, id, r.scenario.behavior(id))
+			}(spawned)
+		}
+		for len(cancels) > want {
+			cancels[len(cancels)-1]()
+			cancels = cancels[:len(cancels)-1]
+		}
+
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-report.C:
+			rep.report(len(cancels))
+		case <-control.C:
+		}
+	}
+	for _, cancel := range cancels {
+		cancel()
+	}
+	wg.Wait()
+	rep.report(0)
+	rep.summary()
+}
+
+func (r *runner) connected(c *client.Client, took time.Duration) {
+	r.successes.Add(1)
+	r.mu.Lock()
+	r.clients[c] = struct{}{}
+	r.connects = append(r.connects, took)
+	r.mu.Unlock()
+}
+
+func (r *runner) finished(c *client.Client) {
+	stats := c.Stats()
+	r.mu.Lock()
+	delete(r.clients, c)
+	r.closed.PacketsIn += stats.PacketsIn
+	r.closed.PacketsOut += stats.PacketsOut
+	r.closed.BytesIn += stats.BytesIn
+	r.closed.BytesOut += stats.BytesOut
+	r.mu.Unlock()
+}
+
+func (r *runner) fail(err error) {
+	r.failures.Add(1)
+	r.reason(err)
+}
+
+func (r *runner) reason(err error) {
+	reason := err.Error()
+	var rejected *client.RejectedError
+	if errors.As(err, &rejected) {
+		reason = "rejected: " + rejected.Reason
+	}
+	r.mu.Lock()
+	r.reasons[reason]++
+	r.mu.Unlock()
+}
+
+// snapshot sums the traffic of all players and returns the RTTs of the
+// connected ones and the connect times since the last snapshot.
+func (r *runner) snapshot() (total client.Stats, rtts, connects []time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	total = r.closed
+	for c := range r.clients {
+		stats := c.Stats()
+		total.PacketsIn += stats.PacketsIn
+		total.PacketsOut += stats.PacketsOut
+		total.BytesIn += stats.BytesIn
+		total.BytesOut += stats.BytesOut
+		if stats.RTT > 0 {
+			rtts = append(rtts, stats.RTT)
+		}
+	}
+	connects = r.connects
+	r.connects = nil
+	return total, rtts, connects
+}
+
+// percentile returns the p-th percentile of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p)
+	return sorted[i]
+}
+
+func sortDurations(d []time.Duration) {
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/yohimik/goxash3d-fwgs/pkg/client"
+	"math/rand/v2"
+	"time"
+)
+
+// dialTimeout bounds transport setup and the engine handshake.
+const dialTimeout = 15 * time.Second
+
+// retryDelay is the pause before a failed player tries again.
+const retryDelay = 2 * time.Second
+
+// runPlayer keeps one simulated player connected until ctx ends.
+func (r *runner) runPlayer(ctx context.Context, id int, b *Behavior) {
+	name := fmt.Sprintf("xashload%d", id)
+	for ctx.Err() == nil {
+		r.attempts.Add(1)
+		start := time.Now()
+		c, err := r.connect(ctx, name)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			r.fail(err)
+			sleep(ctx, retryDelay)
+			continue
+		}
+		r.connected(c, time.Since(start))
+
+		session := ctx
+		cancel := context.CancelFunc(func() {})
+		if b.Churn != nil {
+			length := time.Duration(b.Churn.Min)
+			if spread := int64(b.Churn.Max - b.Churn.Min); spread > 0 {
+				length += time.Duration(rand.Int64N(spread))
+			}
+			session, cancel = context.WithTimeout(ctx, length)
+		}
+		go r.behave(session, c, b)
+		err = c.Run(session)
+		cancel()
+		c.Close()
+		r.finished(c)
+
+		if ctx.Err() != nil {
+			return
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			r.disconnects.Add(1)
+			r.reason(err)
+			sleep(ctx, retryDelay)
+		}
+	}
+}
+
+func (r *runner) connect(ctx context.Context, name string) (*client.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	t, err := dial(ctx, r.scenario)
+	if err != nil {
+		return nil, err
+	}
+	c, err := client.Connect(ctx, t, client.Options{
+		Name:    name,
+		CmdRate: r.scenario.CmdRate,
+	})
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// behave drives the usercmds and chat of a connected player.
+func (r *runner) behave(ctx context.Context, c *client.Client, b *Behavior) {
+	const step = 100 * time.Millisecond
+	ticker := time.NewTicker(step)
+	defer ticker.Stop()
+
+	var yaw float32
+	nextChat := time.Time{}
+	if b.Chat != nil && b.Chat.Interval > 0 {
+		nextChat = time.Now().Add(jitter(time.Duration(b.Chat.Interval)))
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if m := b.Move; m != nil {
+				yaw += m.Turn * float32(step.Seconds())
+				cmd := client.UserCmd{
+					Msec:        1000 / r.scenario.CmdRate,
+					ForwardMove: m.Forward,
+					SideMove:    m.Side,
+					ViewAngles:  [3]float32{0, yaw, 0},
+				}
+				if rand.Float64() < m.Jump*step.Seconds() {
+					cmd.Buttons |= client.InJump
+				}
+				c.SetUserCmd(cmd)
+			}
+			if !nextChat.IsZero() && now.After(nextChat) {
+				if len(b.Chat.Messages) > 0 {
+					c.StringCmd("say " + b.Chat.Messages[rand.IntN(len(b.Chat.Messages))])
+				}
+				nextChat = now.Add(jitter(time.Duration(b.Chat.Interval)))
+			}
+		}
+	}
+}
+
+// jitter spreads d by up to half of it in both directions.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int64N(int64(d)+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
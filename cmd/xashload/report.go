@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"github.com/yohimik/goxash3d-fwgs/pkg/client"
+	"os"
+	"sort"
+	"time"
+)
+
+// reporter prints interval lines and the final summary.
+type reporter struct {
+	r     *runner
+	start time.Time
+	last  time.Time
+	prev  client.Stats
+
+	server     *serverSample
+	firstDrop  int
+	maxDepth   float64
+	drops      float64
+	maxPlayers int
+	connects   []time.Duration
+	rtts       []time.Duration
+}
+
+func newReporter(r *runner, start time.Time) *reporter {
+	rep := &reporter{r: r, start: start, last: start, firstDrop: -1}
+	if r.scenario.Metrics != "" {
+		if s, err := scrape(r.scenario.Metrics); err == nil {
+			rep.server = s
+		} else {
+			fmt.Fprintf(os.Stderr, "metrics: %v\n", err)
+		}
+	}
+	return rep
+}
+
+func (rep *reporter) report(players int) {
+	now := time.Now()
+	elapsed := now.Sub(rep.last).Seconds()
+	rep.last = now
+	total, rtts, connects := rep.r.snapshot()
+	rep.maxPlayers = max(rep.maxPlayers, players)
+	rep.connects = append(rep.connects, connects...)
+	rep.rtts = append(rep.rtts, rtts...)
+	sortDurations(rtts)
+
+	line := fmt.Sprintf("%6s players=%d ok=%d failed=%d dropped=%d in=%.0fpps/%.0fkbps out=%.0fpps/%.0fkbps rtt p50=%s p90=%s p99=%s",
+		time.Since(rep.start).Truncate(time.Second),
+		players,
+		rep.r.successes.Load(),
+		rep.r.failures.Load(),
+		rep.r.disconnects.Load(),
+		float64(total.PacketsIn-rep.prev.PacketsIn)/elapsed,
+		float64(total.BytesIn-rep.prev.BytesIn)*8/1000/elapsed,
+		float64(total.PacketsOut-rep.prev.PacketsOut)/elapsed,
+		float64(total.BytesOut-rep.prev.BytesOut)*8/1000/elapsed,
+		round(percentile(rtts, 0.5)), round(percentile(rtts, 0.9)), round(percentile(rtts, 0.99)),
+	)
+	rep.prev = total
+
+	if rep.server != nil {
+		if cur, err := scrape(rep.r.scenario.Metrics); err == nil {
+			mean, p99 := frameStats(rep.server, cur)
+			drops := cur.queueDropped - rep.server.queueDropped
+			if drops > 0 && rep.firstDrop < 0 && players > 0 {
+				rep.firstDrop = players
+			}
+			rep.drops += drops
+			rep.maxDepth = max(rep.maxDepth, cur.queueDepth)
+			line += fmt.Sprintf(" frame mean=%s p99<=%s queue=%.0f drops=%.0f", round(mean), round(p99), cur.queueDepth, drops)
+			rep.server = cur
+		} else {
+			line += " metrics: " + err.Error()
+		}
+	}
+	fmt.Println(line)
+}
+
+func (rep *reporter) summary() {
+	r := rep.r
+	attempts := r.attempts.Load()
+	successes := r.successes.Load()
+	rate := 0.0
+	if attempts > 0 {
+		rate = float64(successes) * 100 / float64(attempts)
+	}
+	sortDurations(rep.connects)
+	sortDurations(rep.rtts)
+
+	fmt.Println()
+	fmt.Printf("duration:     %s\n", time.Since(rep.start).Truncate(time.Second))
+	fmt.Printf("players:      %d peak\n", rep.maxPlayers)
+	fmt.Printf("connections:  %d/%d succeeded (%.1f%%), %d dropped by the server\n", successes, attempts, rate, r.disconnects.Load())
+	fmt.Printf("connect time: p50=%s p90=%s p99=%s\n",
+		round(percentile(rep.connects, 0.5)), round(percentile(rep.connects, 0.9)), round(percentile(rep.connects, 0.99)))
+	fmt.Printf("rtt:          p50=%s p90=%s p99=%s\n",
+		round(percentile(rep.rtts, 0.5)), round(percentile(rep.rtts, 0.9)), round(percentile(rep.rtts, 0.99)))
+	if rep.server != nil {
+		fmt.Printf("queue:        max depth %.0f, %.0f packets dropped\n", rep.maxDepth, rep.drops)
+		if rep.firstDrop >= 0 {
+			fmt.Printf("              first drops with %d players\n", rep.firstDrop)
+		}
+	}
+	if len(r.reasons) > 0 {
+		fmt.Println("errors:")
+		reasons := make([]string, 0, len(r.reasons))
+		for reason := range r.reasons {
+			reasons = append(reasons, reason)
+		}
+		sort.Slice(reasons, func(i, j int) bool { return r.reasons[reasons[i]] > r.reasons[reasons[j]] })
+		for _, reason := range reasons {
+			fmt.Printf("  %6d %s\n", r.reasons[reason], reason)
+		}
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	switch {
+	case d > time.Second:
+		return d.Round(10 * time.Millisecond)
+	case d > time.Millisecond:
+		return d.Round(100 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
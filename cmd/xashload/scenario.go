@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Duration is a time.Duration written as a string such as "30s" in
+// scenarios, or as a number of seconds.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: duration must be a scalar", value.Line)
+	}
+	if seconds, err := strconv.ParseFloat(value.Value, 64); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+	v, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Scenario describes a load test.
+type Scenario struct {
+	// Target is "udp://host:port" or the SFU signalling URL "ws://host:27016/websocket".
+	Target string `yaml:"target"`
+	// Token is sent as bearer token to the signalling endpoint.
+	Token string `yaml:"token"`
+	// Metrics is the server /metrics URL scraped for frame time and queue stats.
+	Metrics  string   `yaml:"metrics"`
+	Players  int      `yaml:"players"`
+	Duration Duration `yaml:"duration"`
+	// CmdRate is the number of usercmd packets per second and player.
+	CmdRate int `yaml:"cmdrate"`
+	// Ramp changes the number of players over time; without stages all
+	// players connect at once.
+	Ramp      []Stage    `yaml:"ramp"`
+	Behaviors []Behavior `yaml:"behaviors"`
+}
+
+// Stage is one step of the ramp profile: either a linear change to To
+// players over Over, or a Hold at the current count.
+type Stage struct {
+	To   int      `yaml:"to"`
+	Over Duration `yaml:"over"`
+	Hold Duration `yaml:"hold"`
+}
+
+// Behavior is what a share of the players does once connected.
+type Behavior struct {
+	Name string `yaml:"name"`
+	// Weight is the relative share of players running the behavior.
+	Weight int    `yaml:"weight"`
+	Move   *Move  `yaml:"move"`
+	Chat   *Chat  `yaml:"chat"`
+	Churn  *Churn `yaml:"churn"`
+}
+
+// Move drives the usercmds of a player.
+type Move struct {
+	Forward float32 `yaml:"forward"`
+	Side    float32 `yaml:"side"`
+	// Turn is the yaw speed in degrees per second.
+	Turn float32 `yaml:"turn"`
+	// Jump is the probability of jumping per second.
+	Jump float64 `yaml:"jump"`
+}
+
+// Chat makes a player talk every Interval, give or take half of it.
+type Chat struct {
+	Interval Duration `yaml:"interval"`
+	Messages []string `yaml:"messages"`
+}
+
+// Churn disconnects a player after a random session length between Min
+// and Max and reconnects it.
+type Churn struct {
+	Min Duration `yaml:"min"`
+	Max Duration `yaml:"max"`
+}
+
+var errNoTarget = errors.New("xashload: scenario has no target")
+
+// loadScenario reads a scenario file.
+func loadScenario(path string) (*Scenario, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseScenario(src)
+}
+
+// parseScenario decodes a YAML scenario, unknown keys are errors.
+func parseScenario(src []byte) (*Scenario, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(src))
+	dec.KnownFields(true)
+	s := &Scenario{}
+	if err := dec.Decode(s); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("xashload: invalid scenario: %w", err)
+	}
+	return s, nil
+}
+
+// validate fills in defaults and checks the scenario.
+func (s *Scenario) validate() error {
+	if s.Target == "" {
+		return errNoTarget
+	}
+	if s.CmdRate <= 0 {
+		s.CmdRate = 30
+	}
+	if len(s.Behaviors) == 0 {
+		s.Behaviors = []Behavior{{Name: "idle"}}
+	}
+	for i := range s.Behaviors {
+		if s.Behaviors[i].Weight <= 0 {
+			s.Behaviors[i].Weight = 1
+		}
+		if c := s.Behaviors[i].Churn; c != nil && c.Max < c.Min {
+			return fmt.Errorf("xashload: behavior %q: churn max below min", s.Behaviors[i].Name)
+		}
+	}
+	if len(s.Ramp) == 0 {
+		s.Ramp = []Stage{{To: s.Players}}
+	}
+	var ramp time.Duration
+	for _, st := range s.Ramp {
+		ramp += time.Duration(st.Over) + time.Duration(st.Hold)
+		s.Players = max(s.Players, st.To)
+	}
+	if s.Duration <= 0 {
+		s.Duration = Duration(ramp + time.Minute)
+	}
+	return nil
+}
+
+// playersAt returns the number of players the ramp profile wants after elapsed.
+func (s *Scenario) playersAt(elapsed time.Duration) int {
+	current := 0
+	for _, st := range s.Ramp {
+		if st.Hold > 0 {
+			if elapsed < time.Duration(st.Hold) {
+				return current
+			}
+			elapsed -= time.Duration(st.Hold)
+			continue
+		}
+		over := time.Duration(st.Over)
+		if elapsed < over {
+			return current + int(float64(st.To-current)*float64(elapsed)/float64(over))
+		}
+		elapsed -= over
+		current = st.To
+	}
+	return current
+}
+
+// behavior returns the behavior of the n-th player, spread by weight.
+func (s *Scenario) behavior(n int) *Behavior {
+	total := 0
+	for _, b := range s.Behaviors {
+		total += b.Weight
+	}
+	n %= total
+	for i := range s.Behaviors {
+		if n < s.Behaviors[i].Weight {
+			return &s.Behaviors[i]
+		}
+		n -= s.Behaviors[i].Weight
+	}
+	return &s.Behaviors[0]
+}
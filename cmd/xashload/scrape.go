@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serverSample is the part of the server metrics the report uses.
+type serverSample struct {
+	frameSum   float64
+	frameCount float64
+	// frameBuckets maps the le bound to the cumulative count.
+	frameBuckets map[float64]float64
+	queueDepth   float64
+	queueDropped float64
+}
+
+var scrapeClient = &http.Client{Timeout: 5 * time.Second}
+
+// scrape reads the server metrics exported by the metrics package.
+func scrape(url string) (*serverSample, error) {
+	resp, err := scrapeClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	s := &serverSample{frameBuckets: map[float64]float64{}}
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			continue
+		}
+		name, labels := line[:i], ""
+		if j := strings.IndexByte(name, '{'); j >= 0 {
+			name, labels = name[:j], name[j:]
+		}
+		switch name {
+		case "xash_engine_frame_seconds_sum":
+			s.frameSum += value
+		case "xash_engine_frame_seconds_count":
+			s.frameCount += value
+		case "xash_engine_frame_seconds_bucket":
+			le := labelValue(labels, "le")
+			bound, err := strconv.ParseFloat(le, 64)
+			if le == "+Inf" {
+				bound, err = math.Inf(1), nil
+			}
+			if err == nil {
+				s.frameBuckets[bound] += value
+			}
+		case "xash_packet_queue_depth":
+			s.queueDepth += value
+		case "xash_packet_queue_dropped_total":
+			s.queueDropped += value
+		}
+	}
+	return s, sc.Err()
+}
+
+func labelValue(labels, key string) string {
+	i := strings.Index(labels, key+`="`)
+	if i < 0 {
+		return ""
+	}
+	rest := labels[i+len(key)+2:]
+	if j := strings.IndexByte(rest, '"'); j >= 0 {
+		return rest[:j]
+	}
+	return ""
+}
+
+// frameStats returns the mean and the estimated 99th percentile frame
+// time between two samples.
+func frameStats(prev, cur *serverSample) (mean, p99 time.Duration) {
+	count := cur.frameCount - prev.frameCount
+	if count <= 0 {
+		return 0, 0
+	}
+	mean = time.Duration((cur.frameSum - prev.frameSum) / count * float64(time.Second))
+
+	bounds := make([]float64, 0, len(cur.frameBuckets))
+	for b := range cur.frameBuckets {
+		bounds = append(bounds, b)
+	}
+	sort.Float64s(bounds)
+	for _, b := range bounds {
+		if cur.frameBuckets[b]-prev.frameBuckets[b] >= count*0.99 {
+			if math.IsInf(b, 1) {
+				break
+			}
+			return mean, time.Duration(b * float64(time.Second))
+		}
+	}
+	return mean, 0
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+	"github.com/yohimik/goxash3d-fwgs/pkg/client"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+var errUnsupportedTarget = errors.New("xashload: unsupported target scheme")
+
+// dial opens a transport to the scenario target.
+func dial(ctx context.Context, s *Scenario) (client.Transport, error) {
+	u, err := url.Parse(s.Target)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "udp":
+		return client.DialUDP(u.Host)
+	case "ws", "wss":
+		return dialWebRTC(ctx, s.Target, s.Token)
+	}
+	return nil, fmt.Errorf("%w: %q", errUnsupportedTarget, u.Scheme)
+}
+
+// webrtcAPI detaches data channels so they can be used as plain readers and writers.
+var webrtcAPI = func() *webrtc.API {
+	settingEngine := webrtc.SettingEngine{}
+	settingEngine.DetachDataChannels()
+	return webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine))
+}()
+
+type signalMessage struct {
+	Event string `json:"event"`
+	Data  string `json:"data"`
+}
+
+// webrtcTransport talks to an SFU example the way the browser client does:
+// the server opens a "write" channel carrying engine packets and a "read"
+// channel taking client packets.
+type webrtcTransport struct {
+	pc *webrtc.PeerConnection
+	ws *websocket.Conn
+
+	wsLock sync.Mutex
+	mu     sync.Mutex
+	in     io.ReadWriteCloser
+	out    io.ReadWriteCloser
+	ready  chan struct{}
+	failed chan error
+}
+
+func dialWebRTC(ctx context.Context, target, token string) (client.Transport, error) {
+	header := http.Header{}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, target, header)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := webrtcAPI.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+	t := &webrtcTransport{
+		pc:     pc,
+		ws:     ws,
+		ready:  make(chan struct{}),
+		failed: make(chan error, 1),
+	}
+
+	pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c == nil {
+			return
+		}
+		data, err := json.Marshal(c.ToJSON())
+		if err != nil {
+			return
+		}
+		t.send("candidate", string(data))
+	})
+	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		dc.OnOpen(func() {
+			d, err := dc.Detach()
+			if err != nil {
+				t.fail(err)
+				return
+			}
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			switch dc.Label() {
+			case "write":
+				t.in = d
+			case "read":
+				t.out = d
+			}
+			if t.in != nil && t.out != nil {
+				close(t.ready)
+			}
+		})
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateFailed {
+			t.fail(errors.New("xashload: peer connection failed"))
+		}
+	})
+	go t.signal()
+
+	select {
+	case <-t.ready:
+		return t, nil
+	case err := <-t.failed:
+		t.Close()
+		return nil, err
+	case <-ctx.Done():
+		t.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// signal answers the offers and candidates of the signalling server.
+func (t *webrtcTransport) signal() {
+	for {
+		var msg signalMessage
+		if err := t.ws.ReadJSON(&msg); err != nil {
+			t.fail(err)
+			return
+		}
+		switch msg.Event {
+		case "offer":
+			offer := webrtc.SessionDescription{}
+			if err := json.Unmarshal([]byte(msg.Data), &offer); err != nil {
+				t.fail(err)
+				return
+			}
+			if err := t.pc.SetRemoteDescription(offer); err != nil {
+				t.fail(err)
+				return
+			}
+			answer, err := t.pc.CreateAnswer(nil)
+			if err != nil {
+				t.fail(err)
+				return
+			}
+			if err := t.pc.SetLocalDescription(answer); err != nil {
+				t.fail(err)
+				return
+			}
+			data, err := json.Marshal(answer)
+			if err != nil {
+				t.fail(err)
+				return
+			}
+			t.send("answer", string(data))
+		case "candidate":
+			candidate := webrtc.ICECandidateInit{}
+			if err := json.Unmarshal([]byte(msg.Data), &candidate); err != nil {
+				t.fail(err)
+				return
+			}
+			if err := t.pc.AddICECandidate(candidate); err != nil {
+				t.fail(err)
+				return
+			}
+		}
+	}
+}
+
+func (t *webrtcTransport) send(event, data string) {
+	t.wsLock.Lock()
+	defer t.wsLock.Unlock()
+	_ = t.ws.WriteJSON(&signalMessage{Event: event, Data: data})
+}
+
+func (t *webrtcTransport) fail(err error) {
+	select {
+	case t.failed <- err:
+	default:
+	}
+}
+
+func (t *webrtcTransport) Read(b []byte) (int, error) {
+	return t.in.Read(b)
+}
+
+func (t *webrtcTransport) Write(b []byte) (int, error) {
+	return t.out.Write(b)
+}
+
+func (t *webrtcTransport) Close() error {
+	t.ws.Close()
+	return t.pc.Close()
+}
@@ -0,0 +1,5 @@
+module github.com/yohimik/goxash3d-fwgs/examples/replay
+
+go 1.25.1
+
+require github.com/yohimik/goxash3d-fwgs v0.0.0-20251011183407-69ea467b4ed0
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	goxash3d_fwgs "github.com/yohimik/goxash3d-fwgs/pkg"
+	"github.com/yohimik/goxash3d-fwgs/pkg/capture"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// activationTimeout bounds the wait for the engine to activate the map
+// before the replay starts anyway.
+const activationTimeout = 30 * time.Second
+
+// settleFrames is how many engine frames run after the last packet before
+// the output is closed, so the answers to it are recorded too.
+const settleFrames = 2
+
+// settleTimeout bounds the wait for those frames.
+const settleTimeout = time.Second
+
+// ReplayNet is an offline network: inbound packets come from a capture
+// and outbound packets are only recorded, never sent anywhere.
+type ReplayNet struct {
+	*goxash3d_fwgs.BaseNet
+}
+
+func (n *ReplayNet) SendTo(fd int, packet goxash3d_fwgs.Packet, flags int) int {
+	return len(packet.Data)
+}
+
+func (n *ReplayNet) SendToBatch(fd int, packets []goxash3d_fwgs.Packet, flags int) int {
+	sum := 0
+	for _, packet := range packets {
+		sum += n.SendTo(fd, packet, flags)
+	}
+	return sum
+}
+
+func main() {
+	in, err := os.Open(os.Getenv("REPLAY_FILE"))
+	if err != nil {
+		panic(err)
+	}
+	reader, err := capture.NewReader(in)
+	if err != nil {
+		panic(err)
+	}
+
+	speed := 1.0
+	if s, ok := os.LookupEnv("REPLAY_SPEED"); ok {
+		if speed, err = strconv.ParseFloat(s, 64); err != nil {
+			panic(err)
+		}
+	}
+
+	net := &ReplayNet{
+		BaseNet: goxash3d_fwgs.NewBaseNet(goxash3d_fwgs.BaseNetOptions{
+			HostName: "replay",
+			HostID:   3000,
+		}),
+	}
+	tap := capture.NewTap(net)
+	goxash3d_fwgs.DefaultXash3D.Net = tap
+
+	// record what the engine answers, to diff against the original capture
+	if path, ok := os.LookupEnv("REPLAY_OUTPUT"); ok {
+		out, err := os.Create(path)
+		if err != nil {
+			panic(err)
+		}
+		w, err := capture.NewWriter(out)
+		if err != nil {
+			panic(err)
+		}
+		tap.Start(w)
+		defer tap.Stop()
+	}
+
+	// packets sent before the map is loaded would be handled differently
+	// on every run, start once the engine activated the server
+	x := goxash3d_fwgs.DefaultXash3D
+	activated := make(chan struct{})
+	var once sync.Once
+	removeActivated := x.On(goxash3d_fwgs.EventServerActivated, func(goxash3d_fwgs.Event) {
+		once.Do(func() { close(activated) })
+	})
+	frames := make(chan struct{}, 1)
+	x.On(goxash3d_fwgs.EventFrameEnd, func(goxash3d_fwgs.Event) {
+		select {
+		case frames <- struct{}{}:
+		default:
+		}
+	})
+
+	go func() {
+		select {
+		case <-activated:
+		case <-time.After(activationTimeout):
+			fmt.Println("The engine reported no map activation, replaying anyway (are patches/xash3d-fwgs applied?)")
+		}
+		removeActivated()
+
+		n, err := capture.Replay(context.Background(), reader, net, capture.ReplayOptions{Speed: speed})
+		fmt.Printf("Replayed %d packets: %v\n", n, err)
+
+		// let the engine answer the last packets before the output is closed
+		select {
+		case <-frames:
+		default:
+		}
+		settle := time.After(settleTimeout)
+		for i := 0; i < settleFrames; {
+			select {
+			case <-frames:
+				i++
+			case <-settle:
+				i = settleFrames
+			}
+		}
+		tap.Stop()
+	}()
+
+	goxash3d_fwgs.DefaultXash3D.SysStart()
+}
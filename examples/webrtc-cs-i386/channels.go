@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/pion/webrtc/v4"
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"github.com/yohimik/goxash3d-fwgs/pkg/netchan"
+	"strings"
+	"time"
+)
+
+// ChannelProfile is the delivery mode of a server to client data channel.
+type ChannelProfile uint8
+
+const (
+	// ProfileUnreliable is unordered without retransmits, used for game state.
+	ProfileUnreliable ChannelProfile = iota
+	// ProfileReliable is ordered and retransmitted until delivered,
+	// used for connectionless packets.
+	ProfileReliable
+	// ProfilePartial is unordered and retransmitted for PartialLifetime.
+	ProfilePartial
+
+	profileCount
+)
+
+// channelLabels are the data channel labels of the profiles.
+var channelLabels = [profileCount]string{"write", "write-reliable", "write-partial"}
+
+// singleChannelLabel replaces the "write" and "read" pair for clients
+// connecting with ?channels=single.
+const singleChannelLabel = "game"
+
+// readChannelTimeout bounds the opening of the "read" channel of the pair.
+const readChannelTimeout = 10 * time.Second
+
+// defaultPartialLifetime bounds the retransmits of ProfilePartial when
+// CHANNELS does not set a lifetime.
+const defaultPartialLifetime = 200 * time.Millisecond
+
+// ChannelConfig is the CHANNELS setting of an SFU.
+type ChannelConfig struct {
+	// Profiles are opened next to the unreliable channel.
+	Profiles []ChannelProfile
+	// PartialLifetime bounds the retransmits of ProfilePartial.
+	PartialLifetime time.Duration
+}
+
+// Classifier picks the profile of every outbound packet. Packets of a
+// profile the peer has no channel for go through ProfileUnreliable.
+// The client netchan drops packets older than the last one it accepted,
+// so a classifier must keep all sequenced packets of a client on one profile.
+var Classifier = ClassifyNetchan
+
+// ClassifyNetchan sends connectionless packets, whose order does not matter,
+// reliably. Netchan packets, including split ones, stay on the unreliable
+// channel as one stream; the netchan retransmits reliable data itself.
+func ClassifyNetchan(packet goxash3d_fwgs.Packet) ChannelProfile {
+	if len(packet.Data) >= 4 && binary.LittleEndian.Uint32(packet.Data) == netchan.ConnectionlessHeader {
+		return ProfileReliable
+	}
+	return ProfileUnreliable
+}
+
+// String returns the name of the profile as used by CHANNELS.
+func (p ChannelProfile) String() string {
+	switch p {
+	case ProfileUnreliable:
+		return "unreliable"
+	case ProfileReliable:
+		return "reliable"
+	case ProfilePartial:
+		return "partial"
+	default:
+		return "unknown"
+	}
+}
+
+// init returns the data channel options of the profile.
+func (c ChannelConfig) init(p ChannelProfile) *webrtc.DataChannelInit {
+	ordered := p == ProfileReliable
+	init := &webrtc.DataChannelInit{Ordered: &ordered}
+	switch p {
+	case ProfileUnreliable:
+		var retransmits uint16
+		init.MaxRetransmits = &retransmits
+	case ProfilePartial:
+		lifetime := uint16(c.PartialLifetime.Milliseconds())
+		init.MaxPacketLifeTime = &lifetime
+	}
+	return init
+}
+
+// parseChannelConfig parses CHANNELS, e.g. "reliable,partial:300ms".
+func parseChannelConfig(s string) (ChannelConfig, error) {
+	config := ChannelConfig{PartialLifetime: defaultPartialLifetime}
+	for _, field := range strings.Split(s, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(field), ":")
+		switch name {
+		case "", "unreliable":
+			continue
+		case "reliable":
+			config.Profiles = append(config.Profiles, ProfileReliable)
+		case "partial":
+			config.Profiles = append(config.Profiles, ProfilePartial)
+			if arg != "" {
+				d, err := time.ParseDuration(arg)
+				if err != nil {
+					return ChannelConfig{}, err
+				}
+				config.PartialLifetime = d
+			}
+		default:
+			return ChannelConfig{}, fmt.Errorf("unknown channel profile %q", name)
+		}
+	}
+	return config, nil
+}
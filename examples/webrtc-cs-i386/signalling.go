@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// renegotiationDebounce coalesces track changes into a single offer.
+const renegotiationDebounce = 100 * time.Millisecond
+
+// answerTimeout is how long an offer waits for its answer before it is
+// rolled back and made again.
+const answerTimeout = 10 * time.Second
+
+var (
+	errOfferIgnored  = errors.New("offer collides with a pending local offer")
+	errAnswerIgnored = errors.New("answer without a pending local offer")
+)
+
+// signaller coordinates the renegotiation of one peer connection.
+//
+// Requests are debounced and coalesced: a burst of track changes results in
+// one offer, built outside of listLock from a snapshot of the tracks. While
+// an offer waits for its answer no new one is made; the answer triggers
+// another round if changes arrived meanwhile. Offers from the client follow
+// perfect negotiation with the server as the polite peer: a colliding local
+// offer is rolled back and renegotiated after answering, the late answer to
+// it is ignored. An offer left unanswered for answerTimeout is made again.
+type signaller struct {
+	pc *webrtc.PeerConnection
+	ws *threadSafeWriter
+
+	kick   chan struct{}
+	closed chan struct{}
+	once   sync.Once
+
+	// mu serializes local and remote descriptions
+	mu          sync.Mutex
+	makingOffer bool
+	pending     bool
+	// offer counts the local offers, offerTimer expires the current one
+	offer      uint64
+	offerTimer *time.Timer
+}
+
+func newSignaller(pc *webrtc.PeerConnection, ws *threadSafeWriter) *signaller {
+	s := &signaller{
+		pc:     pc,
+		ws:     ws,
+		kick:   make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// request schedules a renegotiation without blocking.
+func (s *signaller) request() {
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+// close stops the signaller.
+func (s *signaller) close() {
+	s.once.Do(func() {
+		close(s.closed)
+		s.mu.Lock()
+		s.clearOffer()
+		s.mu.Unlock()
+	})
+}
+
+// clearOffer ends the wait for an answer. Caller holds mu.
+func (s *signaller) clearOffer() {
+	s.makingOffer = false
+	if s.offerTimer != nil {
+		s.offerTimer.Stop()
+		s.offerTimer = nil
+	}
+}
+
+// expireOffer rolls back the offer when it is still unanswered and
+// schedules a new one.
+func (s *signaller) expireOffer(offer uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.makingOffer || s.offer != offer {
+		return
+	}
+	s.clearOffer()
+	select {
+	case <-s.closed:
+		return
+	default:
+	}
+	if s.pc.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		if err := s.pc.SetLocalDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeRollback}); err != nil {
+			log.Errorf("Failed to roll back unanswered offer: %v", err)
+		}
+	}
+	log.Warnf("Offer was not answered within %s, offering again", answerTimeout)
+	s.request()
+}
+
+func (s *signaller) run() {
+	timer := time.NewTimer(renegotiationDebounce)
+	timer.Stop()
+	for {
+		select {
+		case <-s.closed:
+			timer.Stop()
+			return
+		case <-s.kick:
+			timer.Reset(renegotiationDebounce)
+		case <-timer.C:
+			if err := s.negotiate(); err != nil {
+				log.Errorf("Failed to renegotiate: %v", err)
+			}
+		}
+	}
+}
+
+// negotiate syncs the senders with the current tracks and sends an offer.
+func (s *signaller) negotiate() error {
+	if s.pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+		s.close()
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.makingOffer || s.pc.SignalingState() != webrtc.SignalingStateStable {
+		s.pending = true
+		return nil
+	}
+	s.pending = false
+
+	listLock.RLock()
+	tracks := make(map[string]*forwardedTrack, len(trackLocals))
+	for id, track := range trackLocals {
+		tracks[id] = track
+	}
+	listLock.RUnlock()
+
+	// map of sender we already are seanding, so we don't double send
+	existingSenders := map[string]bool{}
+	for _, sender := range s.pc.GetSenders() {
+		if sender.Track() == nil {
+			continue
+		}
+		existingSenders[sender.Track().ID()] = true
+
+		// If we have a RTPSender that doesn't map to a existing track remove it
+		if _, ok := tracks[sender.Track().ID()]; !ok {
+			if err := s.pc.RemoveTrack(sender); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Don't receive tracks we are sending, make sure we don't have loopback
+	for _, receiver := range s.pc.GetReceivers() {
+		if receiver.Track() != nil {
+			existingSenders[receiver.Track().ID()] = true
+		}
+	}
+
+	// Add all track we aren't sending yet to the PeerConnection
+	for id, track := range tracks {
+		if existingSenders[id] {
+			continue
+		}
+		local, err := track.localFor(s.pc)
+		if err != nil {
+			continue
+		}
+		if _, err := s.pc.AddTrack(local); err != nil {
+			return err
+		}
+	}
+
+	offer, err := s.pc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	if err = s.pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	offerString, err := json.Marshal(offer)
+	if err != nil {
+		return err
+	}
+	s.makingOffer = true
+	s.offer++
+	current := s.offer
+	s.offerTimer = time.AfterFunc(answerTimeout, func() { s.expireOffer(current) })
+
+	log.Infof("Send offer to client: %v", offer)
+
+	return s.ws.WriteJSON(&websocketMessage{
+		Event: "offer",
+		Data:  string(offerString),
+	})
+}
+
+// handleAnswer applies the answer to our offer and continues with changes
+// that arrived while it was outstanding. Answers to offers rolled back after
+// a collision or a timeout return errAnswerIgnored.
+func (s *signaller) handleAnswer(answer webrtc.SessionDescription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clearOffer()
+	if s.pc.SignalingState() != webrtc.SignalingStateHaveLocalOffer {
+		if s.pending {
+			s.request()
+		}
+		return errAnswerIgnored
+	}
+	if err := s.pc.SetRemoteDescription(answer); err != nil {
+		// drop the offer and start over instead of staying stuck on it
+		if rollback := s.pc.SetLocalDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeRollback}); rollback != nil {
+			err = errors.Join(err, rollback)
+		}
+		s.request()
+		return err
+	}
+	if s.pending {
+		s.request()
+	}
+	return nil
+}
+
+// handleOffer answers an offer of the client, rolling back a colliding
+// local offer first.
+func (s *signaller) handleOffer(offer webrtc.SessionDescription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pc.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		if err := s.pc.SetLocalDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeRollback}); err != nil {
+			return errors.Join(errOfferIgnored, err)
+		}
+		s.clearOffer()
+		s.pending = true
+	}
+	if err := s.pc.SetRemoteDescription(offer); err != nil {
+		return err
+	}
+	answer, err := s.pc.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	if err = s.pc.SetLocalDescription(answer); err != nil {
+		return err
+	}
+	answerString, err := json.Marshal(answer)
+	if err != nil {
+		return err
+	}
+	if err = s.ws.WriteJSON(&websocketMessage{
+		Event: "answer",
+		Data:  string(answerString),
+	}); err != nil {
+		return err
+	}
+	if s.pending {
+		s.request()
+	}
+	return nil
+}
@@ -3,7 +3,8 @@ package main
 import goxash3d_fwgs "github.com/yohimik/goxash3d-fwgs/pkg"
 
 func main() {
-	goxash3d_fwgs.DefaultXash3D.Net = net
+	goxash3d_fwgs.DefaultXash3D.Net = newNetwork()
+	registerCvars()
 
 	go runSFU()
 
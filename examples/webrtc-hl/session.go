@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"github.com/yohimik/goxash3d-fwgs/pkg/auth"
+	"github.com/yohimik/goxash3d-fwgs/pkg/players"
+	"github.com/yohimik/goxash3d-fwgs/pkg/voice"
+	"io"
+	"sync"
+	"time"
+)
+
+// resumeBacklog caps the outbound bytes buffered for a detached session.
+const resumeBacklog = 256 * 1024
+
+var (
+	errServerFull = errors.New("no free peer address")
+
+	// resumeGrace keeps the address of a disconnected peer reserved, set by RESUME_GRACE
+	resumeGrace = 30 * time.Second
+
+	// lock for sessions and the attachment state of every session
+	sessionsLock sync.Mutex
+	sessions     = map[string]*session{}
+)
+
+// session is the engine side of a peer. It outlives the PeerConnection for
+// resumeGrace, so a peer reconnecting with the token gets the same virtual
+// address and the engine never sees it leave. Outbound packets are buffered
+// while no data channel is attached.
+type session struct {
+	token    string
+	index    uint8
+	addr     goxash3d_fwgs.Addr
+	identity *auth.Identity
+
+	// guarded by sessionsLock
+	generation int
+	attached   bool
+	expiry     *time.Timer
+
+	mu      sync.Mutex
+	conns   [profileCount]io.Writer
+	backlog []queuedPacket
+	pending int
+}
+
+// queuedPacket is an outbound packet buffered while the peer is away.
+type queuedPacket struct {
+	profile ChannelProfile
+	data    []byte
+}
+
+// sameIdentity reports whether both sessions belong to the same account,
+// or both are anonymous.
+func sameIdentity(a, b *auth.Identity) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Subject == b.Subject
+}
+
+// openSession resumes the session of token, or starts a new one when the
+// token is unknown, expired or belongs to another identity. The returned
+// generation is passed to detach.
+func openSession(token string, identity *auth.Identity) (*session, int, error) {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+
+	if s, ok := sessions[token]; ok && sameIdentity(s.identity, identity) {
+		if s.expiry != nil {
+			s.expiry.Stop()
+			s.expiry = nil
+		}
+		// a second connection takes the session over
+		s.generation++
+		s.attached = true
+		s.identity = identity
+		return s, s.generation, nil
+	}
+
+	index, err := pool.TryGet()
+	if err != nil {
+		return nil, 0, errServerFull
+	}
+	ip := [4]byte{}
+	if _, err := rand.Read(ip[:]); err != nil {
+		pool.TryPut(index)
+		return nil, 0, err
+	}
+	ip[0] = index
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		pool.TryPut(index)
+		return nil, 0, err
+	}
+	s := &session{
+		token:    hex.EncodeToString(raw),
+		index:    index,
+		addr:     goxash3d_fwgs.Addr{IP: ip, Port: 1000},
+		identity: identity,
+		attached: true,
+	}
+	sessions[s.token] = s
+	connections[index].Store(s)
+	players.DefaultDirectory.Bind(s.addr, identity)
+	return s, s.generation, nil
+}
+
+// attach sends the packets of the profile through conn. Attaching the
+// unreliable channel flushes the buffered packets.
+func (s *session) attach(profile ChannelProfile, conn io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[profile] = conn
+	if profile != ProfileUnreliable {
+		return
+	}
+	backlog := s.backlog
+	s.backlog = nil
+	s.pending = 0
+	for _, q := range backlog {
+		s.send(q.profile, q.data)
+	}
+}
+
+// detach releases the data channel of the given generation and reserves the
+// address for resumeGrace. Stale generations are ignored.
+func (s *session) detach(generation int) {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+	if s.generation != generation || !s.attached {
+		return
+	}
+	s.attached = false
+
+	s.mu.Lock()
+	s.conns = [profileCount]io.Writer{}
+	s.mu.Unlock()
+
+	if resumeGrace <= 0 {
+		s.expire()
+		return
+	}
+	s.expiry = time.AfterFunc(resumeGrace, func() {
+		sessionsLock.Lock()
+		defer sessionsLock.Unlock()
+		if !s.attached && s.generation == generation {
+			s.expire()
+		}
+	})
+}
+
+// expire frees the address, sessionsLock must be held.
+func (s *session) expire() {
+	delete(sessions, s.token)
+	connections[s.index].Store(nil)
+	players.DefaultDirectory.Unbind(s.addr)
+	players.Default.DetachPeer(s.addr)
+	voice.DefaultRouter.Forget(s.addr)
+	voice.DefaultTable.Delete(s.addr)
+	if instrumented != nil {
+		instrumented.ForgetPeer(s.addr)
+	}
+	pool.TryPut(s.index)
+}
+
+// Write sends a packet to the peer, or buffers it while the peer is away.
+func (s *session) Write(profile ChannelProfile, data []byte) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.send(profile, data)
+}
+
+func (s *session) send(profile ChannelProfile, data []byte) int {
+	conn := s.conns[profile]
+	if conn == nil {
+		conn = s.conns[ProfileUnreliable]
+	}
+	if conn != nil {
+		n, err := conn.Write(data)
+		if err == nil {
+			return n
+		}
+		// the data channels are gone, keep the packets for a resume
+		s.conns = [profileCount]io.Writer{}
+	}
+	if s.pending+len(data) > resumeBacklog {
+		return len(data)
+	}
+	s.backlog = append(s.backlog, queuedPacket{profile, append([]byte(nil), data...)})
+	s.pending += len(data)
+	return len(data)
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/pion/ice/v4"
@@ -11,17 +13,41 @@ import (
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v4"
 	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"github.com/yohimik/goxash3d-fwgs/pkg/admin"
+	"github.com/yohimik/goxash3d-fwgs/pkg/auth"
+	"github.com/yohimik/goxash3d-fwgs/pkg/capture"
+	"github.com/yohimik/goxash3d-fwgs/pkg/chat"
+	"github.com/yohimik/goxash3d-fwgs/pkg/client"
+	"github.com/yohimik/goxash3d-fwgs/pkg/config"
+	"github.com/yohimik/goxash3d-fwgs/pkg/demo"
+	"github.com/yohimik/goxash3d-fwgs/pkg/fastdl"
+	"github.com/yohimik/goxash3d-fwgs/pkg/mapcycle"
+	"github.com/yohimik/goxash3d-fwgs/pkg/metrics"
+	"github.com/yohimik/goxash3d-fwgs/pkg/motd"
+	"github.com/yohimik/goxash3d-fwgs/pkg/netchan"
+	"github.com/yohimik/goxash3d-fwgs/pkg/netsim"
+	"github.com/yohimik/goxash3d-fwgs/pkg/players"
+	"github.com/yohimik/goxash3d-fwgs/pkg/rcon"
+	"github.com/yohimik/goxash3d-fwgs/pkg/relay"
+	"github.com/yohimik/goxash3d-fwgs/pkg/voice"
 	"io"
+	"maps"
 	"math/rand"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type SFUNet struct {
 	*goxash3d_fwgs.BaseNet
+
+	// Channels are the data channels opened for every peer.
+	Channels ChannelConfig
 }
 
 func NewSFUNet() *SFUNet {
@@ -30,22 +56,537 @@ func NewSFUNet() *SFUNet {
 			HostName: "webxash",
 			HostID:   3000,
 		}),
+		Channels: ChannelConfig{PartialLifetime: defaultPartialLifetime},
 	}
 }
 
 var net = NewSFUNet()
 var pool = goxash3d_fwgs.NewBytesPool(256)
 
-func (n *SFUNet) SendTo(fd int, packet goxash3d_fwgs.Packet, flags int) int {
-	conn := connections[packet.Addr.IP[0]]
-	if conn == nil {
-		return -1
+var (
+	// metricsEnabled serves /metrics next to the signalling endpoint
+	metricsEnabled = os.Getenv("METRICS") != ""
+	instrumented   *metrics.Network
+
+	peerStates = metrics.DefaultRegistry.GaugeVec("xash_webrtc_peer_connections", "WebRTC peer connections by state.", "state")
+
+	// simulator impairs the traffic of peers when NETSIM is set
+	simulator *netsim.Network
+
+	// inbound receives the packets read from the data channels
+	inbound goxash3d_fwgs.PacketPusher = net
+
+	// local carries the in-process bots and the spectator relay
+	local *goxash3d_fwgs.LocalNet
+
+	// spectators streams the game to /spectate viewers when SPECTATE_DELAY is set
+	spectators *relay.Relay
+
+	// recorder writes the stream of an in-process spectator into DEMO_DIR
+	recorder *demo.Recorder
+
+	// mapManager runs the rotation of MAPCYCLE and the map votes
+	mapManager *mapcycle.Manager
+
+	// rconFilter drops classic rcon packets when RCON_CLASSIC is off
+	rconFilter *rcon.Filter
+
+	// chatMutes silences players in text chat, managed with go_mute and go_unmute
+	chatMutes = chat.NewMutes()
+)
+
+// newNetwork wraps the SFU network with the Go-side observers enabled by the environment.
+func newNetwork() goxash3d_fwgs.Xash3DNetwork {
+	var n goxash3d_fwgs.Xash3DNetwork = net
+	if delay, ok := os.LookupEnv("SPECTATE_DELAY"); ok {
+		d, err := time.ParseDuration(delay)
+		if err != nil {
+			panic(err)
+		}
+		spectators = relay.New(relay.Options{Delay: d})
+	}
+	_, bots := os.LookupEnv("BOTS")
+	demoDir, demos := os.LookupEnv("DEMO_DIR")
+	_, admins := os.LookupEnv("ADMIN_KEYS")
+	if bots || demos || admins || spectators != nil {
+		local = goxash3d_fwgs.NewLocalNet(n)
+		n = local
+	}
+	if demos {
+		recorder = demo.NewRecorder(n, demo.Options{Dir: demoDir})
+		n = recorder
+	}
+	if _, ok := os.LookupEnv("NETSIM"); ok {
+		var err error
+		if simulator, err = netsim.New(n); err != nil {
+			panic(err)
+		}
+		if defaults := os.Getenv("NETSIM_DEFAULT"); defaults != "" {
+			var c netsim.Conditions
+			if err := json.Unmarshal([]byte(defaults), &c); err != nil {
+				panic(err)
+			}
+			simulator.SetDefault(c)
+		}
+		inbound = simulator
+		n = simulator
+	}
+	if path, ok := os.LookupEnv("CAPTURE"); ok {
+		file, err := os.Create(path)
+		if err != nil {
+			panic(err)
+		}
+		w, err := capture.NewWriter(file)
+		if err != nil {
+			panic(err)
+		}
+		tap := capture.NewTap(n)
+		tap.Start(w)
+		go func() {
+			for range time.NewTicker(time.Second).C {
+				_ = w.Flush()
+			}
+		}()
+		n = tap
+	}
+	if path, ok := os.LookupEnv("MAPCYCLE"); ok {
+		rotation, err := loadRotation(path)
+		if err != nil {
+			panic(err)
+		}
+		opts := mapcycle.Options{Start: commandArg("+map")}
+		if os.Getenv("MAP_ORDER") == "random" {
+			opts.Order = mapcycle.Random
+		}
+		if limit, ok := os.LookupEnv("MAP_TIMELIMIT"); ok {
+			if opts.TimeLimit, err = time.ParseDuration(limit); err != nil {
+				panic(err)
+			}
+		}
+		mapManager = mapcycle.New(n, rotation, opts)
+		mapManager.Follow(goxash3d_fwgs.DefaultXash3D)
+		n = mapManager
+	}
+	if classic, ok := os.LookupEnv("RCON_CLASSIC"); ok || admins {
+		rconFilter = rcon.NewFilter(n)
+		rconFilter.Disable(classic == "off")
+		n = rconFilter
+	}
+	n = players.Tap(n, players.Default)
+	players.Default.Follow(goxash3d_fwgs.DefaultXash3D)
+	if metricsEnabled {
+		instrumented = metrics.Instrument(n, metrics.NetworkOptions{
+			Transport: "webrtc",
+			PerPeer:   os.Getenv("METRICS_PER_PEER") != "",
+			Queue:     net,
+		})
+		metrics.RegisterBytesPool(nil, pool)
+		metrics.RegisterFrames(nil, goxash3d_fwgs.DefaultXash3D)
+		n = instrumented
+	}
+	return n
+}
+
+// newAdmin creates the admin API when ADMIN_KEYS is set.
+func newAdmin() *admin.Server {
+	spec, ok := os.LookupEnv("ADMIN_KEYS")
+	if !ok {
+		return nil
+	}
+	keys, err := admin.ParseKeys(spec)
+	if err != nil {
+		panic(err)
+	}
+	opts := admin.Options{
+		Keys: keys,
+		Maps: mapManager,
+		Ping: peerPing,
+		Cvar: goxash3d_fwgs.DefaultXash3D.LookupCvar,
+	}
+	if path, ok := os.LookupEnv("ADMIN_BANS"); ok {
+		if opts.Bans, err = admin.LoadBans(path); err != nil {
+			panic(err)
+		}
+	}
+	if path, ok := os.LookupEnv("ADMIN_AUDIT"); ok {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			panic(err)
+		}
+		opts.Audit = file
+	}
+	return admin.New(opts)
+}
+
+// peerPing returns the ICE round trip time of the WebRTC peer at addr.
+func peerPing(addr goxash3d_fwgs.Addr) (time.Duration, bool) {
+	peer, ok := players.Default.PeerOf(addr)
+	if !ok {
+		return 0, false
+	}
+	pc, ok := peer.(*webrtc.PeerConnection)
+	if !ok {
+		return 0, false
+	}
+	for _, stats := range pc.GetStats() {
+		pair, ok := stats.(webrtc.ICECandidatePairStats)
+		if ok && pair.Nominated && pair.CurrentRoundTripTime > 0 {
+			return time.Duration(pair.CurrentRoundTripTime * float64(time.Second)), true
+		}
+	}
+	return 0, false
+}
+
+// loadRotation reads a mapcycle.txt or maps.ini file.
+func loadRotation(path string) ([]mapcycle.Map, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return mapcycle.ParseRotation(file)
+}
+
+// registerCvars exposes the SFU settings as engine cvars, so server.cfg and
+// the console can change them. It runs before the engine starts.
+func registerCvars() {
+	if grace, ok := os.LookupEnv("RESUME_GRACE"); ok {
+		d, err := time.ParseDuration(grace)
+		if err != nil {
+			panic(err)
+		}
+		resumeGrace = d
+	}
+	grace, err := goxash3d_fwgs.DefaultXash3D.RegisterCvar("go_resume_grace", resumeGrace.String(), 0,
+		"how long a disconnected WebRTC peer keeps its engine client")
+	if err != nil {
+		panic(err)
+	}
+	grace.OnChange(func(string, string) {
+		sessionsLock.Lock()
+		resumeGrace = grace.Duration()
+		sessionsLock.Unlock()
+	})
+}
+
+// registerCommands adds the Go console commands of the SFU.
+func registerCommands() {
+	x := goxash3d_fwgs.DefaultXash3D
+	_ = x.RegisterCommand(goxash3d_fwgs.Command{
+		Name:        "go_stats",
+		Description: "print the WebRTC peers and Go side counters",
+		Clients:     true,
+		Handler: func(ctx *goxash3d_fwgs.CommandContext) error {
+			sessionsLock.Lock()
+			active := 0
+			for _, s := range sessions {
+				if s.attached {
+					active++
+				}
+			}
+			detached := len(sessions) - active
+			sessionsLock.Unlock()
+
+			ctx.Printf("peers: %d attached, %d awaiting resume\n", active, detached)
+			ctx.Printf("players: %d\n", len(players.Default.Players()))
+			ctx.Printf("dropped events: %d\n", x.DroppedEvents())
+			if mapManager != nil {
+				ctx.Printf("map: %s, next %s\n", mapManager.Current(), mapManager.Next())
+			}
+			return nil
+		},
+	})
+	_ = x.RegisterCommand(goxash3d_fwgs.Command{
+		Name:        "go_reload",
+		Description: "reload the MAPCYCLE rotation of the Go map manager",
+		Handler: func(ctx *goxash3d_fwgs.CommandContext) error {
+			if mapManager == nil {
+				return errors.New("MAPCYCLE is not set")
+			}
+			rotation, err := loadRotation(os.Getenv("MAPCYCLE"))
+			if err != nil {
+				return err
+			}
+			if err := mapManager.SetRotation(rotation); err != nil {
+				return err
+			}
+			ctx.Printf("%d maps in rotation\n", len(rotation))
+			return nil
+		},
+	})
+	_ = x.RegisterCommand(goxash3d_fwgs.Command{
+		Name:        "go_mute",
+		Description: "go_mute <userid> [duration] [reason]: mute a player in text chat",
+		Handler: func(ctx *goxash3d_fwgs.CommandContext) error {
+			player, err := commandPlayer(ctx)
+			if err != nil {
+				return err
+			}
+			var d time.Duration
+			if arg := ctx.Arg(1); arg != "" {
+				if d, err = time.ParseDuration(arg); err != nil {
+					return err
+				}
+			}
+			chatMutes.Mute(player.Addr, d, strings.Join(ctx.Args[min(2, len(ctx.Args)):], " "))
+			ctx.Printf("%s muted\n", player.Name)
+			return nil
+		},
+	})
+	_ = x.RegisterCommand(goxash3d_fwgs.Command{
+		Name:        "go_unmute",
+		Description: "go_unmute <userid>: lift a text chat mute",
+		Handler: func(ctx *goxash3d_fwgs.CommandContext) error {
+			player, err := commandPlayer(ctx)
+			if err != nil {
+				return err
+			}
+			if !chatMutes.Unmute(player.Addr) {
+				return fmt.Errorf("%s is not muted", player.Name)
+			}
+			ctx.Printf("%s unmuted\n", player.Name)
+			return nil
+		},
+	})
+}
+
+// commandPlayer finds the player of the userid in the first command argument.
+// Userids are known once the engine reported the client, see Registry.Follow.
+func commandPlayer(ctx *goxash3d_fwgs.CommandContext) (players.Player, error) {
+	userID, err := ctx.Int(0)
+	if err != nil {
+		return players.Player{}, err
+	}
+	player, ok := players.Default.ByUserID(userID)
+	if !ok {
+		return players.Player{}, fmt.Errorf("no player with userid %d", userID)
+	}
+	return player, nil
+}
+
+// newChat builds the chat moderation pipeline: mutes, the CHAT_FLOOD
+// limit, the map votes, "!" commands and the CHAT_FILTER word list.
+func newChat() *chat.Pipeline {
+	p := chat.New(chatMutes.Handler())
+	if flood, ok := os.LookupEnv("CHAT_FLOOD"); ok {
+		burst, interval, _ := strings.Cut(flood, ":")
+		n, err := strconv.Atoi(burst)
+		if err != nil {
+			panic(err)
+		}
+		d := time.Second
+		if interval != "" {
+			if d, err = time.ParseDuration(interval); err != nil {
+				panic(err)
+			}
+		}
+		p.Use(chat.Flood(n, d))
+	}
+	if mapManager != nil {
+		// rtv and nominate only count from players allowed to chat
+		p.Use(mapManager.ChatHandler())
+	}
+
+	commands := chat.NewCommands("!")
+	commands.Handle("help", func(m *chat.Message, _ []string) {
+		m.Reply("Commands: !%s", strings.Join(commands.Names(), ", !"))
+	})
+	commands.Handle("players", func(m *chat.Message, _ []string) {
+		var names []string
+		for _, player := range players.Default.Players() {
+			if player.Connected {
+				names = append(names, player.Name)
+			}
+		}
+		m.Reply("%d players: %s", len(names), strings.Join(names, ", "))
+	})
+	p.Use(commands.Handler())
+
+	if words, ok := os.LookupEnv("CHAT_FILTER"); ok {
+		p.Use(chat.Profanity(strings.Split(words, ",")...))
+	}
+	return p
+}
+
+// commandArg returns the value following name on the engine command line,
+// e.g. the map of "+map".
+func commandArg(name string) string {
+	args := strings.Fields(strings.Join(os.Args[1:], " "))
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == name {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// gameDirs returns the directory of the -game mod followed by valve.
+func gameDirs() []string {
+	game := commandArg("-game")
+	if game == "" {
+		game = "valve"
+	}
+	base := os.Getenv("XASH3D_BASEDIR")
+	dirs := []string{filepath.Join(base, game)}
+	if game != "valve" {
+		dirs = append(dirs, filepath.Join(base, "valve"))
+	}
+	return dirs
+}
+
+// newFastDL serves the game directory for sv_downloadurl when FASTDL is set
+// to the public url of /fastdl/.
+func newFastDL() *fastdl.Server {
+	url, ok := os.LookupEnv("FASTDL")
+	if !ok {
+		return nil
+	}
+	opts := fastdl.Options{Dirs: gameDirs(), URL: url}
+	if exts, ok := os.LookupEnv("FASTDL_TYPES"); ok {
+		opts.Extensions = strings.Split(exts, ",")
 	}
-	nn, err := conn.Write(packet.Data)
+	server, err := fastdl.New(opts)
 	if err != nil {
+		panic(err)
+	}
+	return server
+}
+
+// newMOTD renders the MOTD page when MOTD is set to the public url of /motd/.
+func newMOTD() *motd.Page {
+	url, ok := os.LookupEnv("MOTD")
+	if !ok {
+		return nil
+	}
+	dir := gameDirs()[0]
+	page, err := motd.New(motd.Options{
+		Template: os.Getenv("MOTD_TEMPLATE"),
+		Maps:     mapManager,
+		Map:      liveMap(),
+		Rules:    func() map[string]string { return liveRules(filepath.Join(dir, "server.cfg")) },
+	})
+	if err != nil {
+		panic(err)
+	}
+	if err := motd.SetMOTDURL(goxash3d_fwgs.DefaultXash3D.ExecCommand, dir, "motd_url.txt", url); err != nil {
+		panic(err)
+	}
+	return page
+}
+
+// liveMap returns the level the engine activated last, the +map argument
+// until the first activation.
+func liveMap() func() string {
+	var current atomic.Pointer[string]
+	start := commandArg("+map")
+	current.Store(&start)
+	goxash3d_fwgs.DefaultXash3D.On(goxash3d_fwgs.EventServerActivated, func(e goxash3d_fwgs.Event) {
+		current.Store(&e.Map)
+	})
+	return func() string { return *current.Load() }
+}
+
+// liveRules returns the current engine values of the single value settings
+// of a .cfg file, without passwords. The file only names the rules, changes
+// made over the console or rcon show up once the page refreshes its cache.
+func liveRules(path string) map[string]string {
+	rules := map[string]string{}
+	file, err := os.Open(path)
+	if err != nil {
+		return rules
+	}
+	defer file.Close()
+	commands, err := config.ParseCommands(file)
+	if err != nil {
+		return rules
+	}
+
+	// lookups wait for the engine frame, run them together
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, c := range commands {
+		if len(c.Args) != 1 || admin.SecretCvar(c.Name) {
+			continue
+		}
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if value, ok := goxash3d_fwgs.DefaultXash3D.LookupCvar(name); ok {
+				mu.Lock()
+				rules[name] = value
+				mu.Unlock()
+			}
+		}(c.Name)
+	}
+	wg.Wait()
+	return rules
+}
+
+// runBot keeps a headless player connected through the in-process transport.
+func runBot(name string) {
+	for {
+		c, err := client.Connect(context.Background(), local.Dial(), client.Options{
+			Name:             name,
+			HandshakeTimeout: time.Minute,
+		})
+		if err != nil {
+			log.Errorf("Bot %s failed to connect: %v", name, err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		done := make(chan struct{})
+		go func() {
+			// walk forward and turn around now and then
+			for yaw := float32(0); ; yaw += 45 {
+				c.SetUserCmd(client.UserCmd{Msec: 33, ForwardMove: 200, ViewAngles: [3]float32{0, yaw, 0}})
+				select {
+				case <-done:
+					return
+				case <-time.After(time.Duration(1+rand.Intn(3)) * time.Second):
+				}
+			}
+		}()
+		err = c.Run(context.Background())
+		close(done)
+		log.Errorf("Bot %s disconnected: %v", name, err)
+		c.Close()
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// runRecorder keeps an in-process spectator connected and records what it receives.
+func runRecorder() {
+	if err := recorder.Start(); err != nil {
+		log.Errorf("Failed to start demo recording: %v", err)
+	}
+	for {
+		peer := local.Dial()
+		recorder.Watch(peer.Addr())
+		c, err := client.Connect(context.Background(), peer, client.Options{
+			Name:             "demo",
+			UserInfo:         netchan.Info{"*hltv": "1"},
+			HandshakeTimeout: time.Minute,
+		})
+		if err != nil {
+			log.Errorf("Demo spectator failed to connect: %v", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		err = c.Run(context.Background())
+		log.Errorf("Demo spectator disconnected: %v", err)
+		c.Close()
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (n *SFUNet) SendTo(fd int, packet goxash3d_fwgs.Packet, flags int) int {
+	sess := connections[packet.Addr.IP[0]].Load()
+	if sess == nil {
 		return -1
 	}
-	return nn
+	return sess.Write(Classifier(packet), packet.Data)
 }
 
 func (n *SFUNet) SendToBatch(fd int, packets []goxash3d_fwgs.Packet, flags int) int {
@@ -60,7 +601,9 @@ func (n *SFUNet) SendToBatch(fd int, packets []goxash3d_fwgs.Packet, flags int)
 	return sum
 }
 
-var connections = make([]io.Writer, 256)
+// connections maps the first address byte to the session, read by the engine
+// thread on every packet and written under sessionsLock
+var connections [256]atomic.Pointer[session]
 
 var (
 	addr     = ":27016"
@@ -70,10 +613,13 @@ var (
 
 	api *webrtc.API
 
+	// authenticator admits signalling sessions, nil accepts everyone
+	authenticator auth.Authenticator
+
 	// lock for peerConnections and trackLocals
 	listLock        sync.RWMutex
 	peerConnections []peerConnectionState
-	trackLocals     map[string]*webrtc.TrackLocalStaticRTP
+	trackLocals     map[string]*forwardedTrack
 
 	log = logging.NewDefaultLoggerFactory().NewLogger("sfu-ws")
 )
@@ -86,20 +632,171 @@ type websocketMessage struct {
 type peerConnectionState struct {
 	peerConnection *webrtc.PeerConnection
 	websocket      *threadSafeWriter
+	signaller      *signaller
+}
+
+// removePeerConnection drops a closed PeerConnection from the global list.
+func removePeerConnection(pc *webrtc.PeerConnection) {
+	listLock.Lock()
+	defer listLock.Unlock()
+
+	for i := range peerConnections {
+		if peerConnections[i].peerConnection == pc {
+			peerConnections[i].signaller.close()
+			peerConnections = append(peerConnections[:i], peerConnections[i+1:]...)
+
+			return
+		}
+	}
+}
+
+// voiceDecisionTTL is how long a voice routing decision is reused for RTP packets.
+const voiceDecisionTTL = 200 * time.Millisecond
+
+// forwardedTrack fans an incoming voice track out through one local track
+// per listener, forwarding only to the listeners voice.DefaultRouter allows.
+type forwardedTrack struct {
+	id       string
+	streamID string
+	codec    webrtc.RTPCodecCapability
+	speaker  goxash3d_fwgs.Addr
+
+	mu     sync.Mutex
+	locals map[*webrtc.PeerConnection]*listenerTrack
+}
+
+type listenerTrack struct {
+	track    *webrtc.TrackLocalStaticRTP
+	listener goxash3d_fwgs.Addr
+	decision voice.Decision
+	decided  time.Time
+}
+
+// ID returns the track ID shared by all local tracks.
+func (f *forwardedTrack) ID() string {
+	return f.id
+}
+
+// localFor returns the local track sending the voice to the listener.
+func (f *forwardedTrack) localFor(pc *webrtc.PeerConnection) (*webrtc.TrackLocalStaticRTP, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if l, ok := f.locals[pc]; ok {
+		return l.track, nil
+	}
+	player, ok := players.Default.ByPeer(pc)
+	if !ok {
+		return nil, errors.New("listener is not registered")
+	}
+	track, err := webrtc.NewTrackLocalStaticRTP(f.codec, f.id, f.streamID)
+	if err != nil {
+		return nil, err
+	}
+	f.locals[pc] = &listenerTrack{track: track, listener: player.Addr}
+	return track, nil
+}
+
+// forget drops the local track of a closed listener.
+func (f *forwardedTrack) forget(pc *webrtc.PeerConnection) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.locals, pc)
+}
+
+// WriteRTP forwards a packet to every listener allowed to hear the speaker.
+func (f *forwardedTrack) WriteRTP(pkt *rtp.Packet) {
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, l := range f.locals {
+		if now.Sub(l.decided) > voiceDecisionTTL {
+			l.decision = voice.DefaultRouter.Route(f.speaker, l.listener)
+			l.decided = now
+		}
+		if l.decision.Forward {
+			_ = l.track.WriteRTP(pkt)
+		}
+	}
+}
+
+// forgetListener drops the voice tracks of a closed peer.
+func forgetListener(pc *webrtc.PeerConnection) {
+	listLock.RLock()
+	defer listLock.RUnlock()
+	for _, track := range trackLocals {
+		track.forget(pc)
+	}
+}
+
+// sendVoiceGains tells the client the volume of every voice track it
+// receives, as a "voice" event mapping track IDs to gains, whenever the
+// routing changes. Media is forwarded unchanged, the client applies them.
+func sendVoiceGains(pc *webrtc.PeerConnection, c *threadSafeWriter, addr goxash3d_fwgs.Addr) {
+	var last map[string]float32
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for range ticker.C {
+		if pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+			return
+		}
+		gains := map[string]float32{}
+		listLock.RLock()
+		for id, track := range trackLocals {
+			if track.speaker != addr {
+				gains[id] = voice.DefaultRouter.Route(track.speaker, addr).Gain
+			}
+		}
+		listLock.RUnlock()
+		if maps.Equal(gains, last) {
+			continue
+		}
+		last = gains
+		data, err := json.Marshal(gains)
+		if err != nil {
+			continue
+		}
+		if err := c.WriteJSON(&websocketMessage{Event: "voice", Data: string(data)}); err != nil {
+			return
+		}
+	}
+}
+
+// newVoiceRules reads the voice routing rules from the environment.
+func newVoiceRules() voice.Rules {
+	rules := voice.Rules{
+		TeamOnly: os.Getenv("VOICE_TEAM_ONLY") != "",
+		DeadTalk: os.Getenv("VOICE_DEAD_TALK") != "",
+	}
+	if proximity := os.Getenv("VOICE_PROXIMITY"); proximity != "" {
+		falloff, cutoff, ok := strings.Cut(proximity, ":")
+		if !ok {
+			falloff, cutoff = "", proximity
+		}
+		if v, err := strconv.ParseFloat(cutoff, 32); err == nil {
+			rules.Proximity = float32(v)
+		}
+		if v, err := strconv.ParseFloat(falloff, 32); err == nil {
+			rules.Falloff = float32(v)
+		}
+	}
+	return rules
 }
 
 // Add to list of tracks and fire renegotation for all PeerConnections.
-func addTrack(t *webrtc.TrackRemote) *webrtc.TrackLocalStaticRTP { // nolint
+func addTrack(t *webrtc.TrackRemote, speaker goxash3d_fwgs.Addr) *forwardedTrack { // nolint
 	listLock.Lock()
 	defer func() {
 		listLock.Unlock()
 		signalPeerConnections()
 	}()
 
-	// Create a new TrackLocal with the same codec as our incoming
-	trackLocal, err := webrtc.NewTrackLocalStaticRTP(t.Codec().RTPCodecCapability, t.ID(), t.StreamID())
-	if err != nil {
-		panic(err)
+	// Forward with the same codec as our incoming
+	trackLocal := &forwardedTrack{
+		id:       t.ID(),
+		streamID: t.StreamID(),
+		codec:    t.Codec().RTPCodecCapability,
+		speaker:  speaker,
+		locals:   make(map[*webrtc.PeerConnection]*listenerTrack),
 	}
 
 	trackLocals[t.ID()] = trackLocal
@@ -108,7 +805,7 @@ func addTrack(t *webrtc.TrackRemote) *webrtc.TrackLocalStaticRTP { // nolint
 }
 
 // Remove from list of tracks and fire renegotation for all PeerConnections.
-func removeTrack(t *webrtc.TrackLocalStaticRTP) {
+func removeTrack(t *forwardedTrack) {
 	listLock.Lock()
 	defer func() {
 		listLock.Unlock()
@@ -118,116 +815,34 @@ func removeTrack(t *webrtc.TrackLocalStaticRTP) {
 	delete(trackLocals, t.ID())
 }
 
-// signalPeerConnections updates each PeerConnection so that it is getting all the expected media tracks.
-func signalPeerConnections() { // nolint
-	listLock.Lock()
-	defer func() {
-		listLock.Unlock()
-		dispatchKeyFrame()
-	}()
-
-	attemptSync := func() (tryAgain bool) {
-		for i := range peerConnections {
-			if peerConnections[i].peerConnection.ConnectionState() == webrtc.PeerConnectionStateClosed {
-				peerConnections = append(peerConnections[:i], peerConnections[i+1:]...)
-
-				return true // We modified the slice, start from the beginning
-			}
-
-			// map of sender we already are seanding, so we don't double send
-			existingSenders := map[string]bool{}
-
-			for _, sender := range peerConnections[i].peerConnection.GetSenders() {
-				if sender.Track() == nil {
-					continue
-				}
-
-				existingSenders[sender.Track().ID()] = true
-
-				// If we have a RTPSender that doesn't map to a existing track remove and signal
-				if _, ok := trackLocals[sender.Track().ID()]; !ok {
-					if err := peerConnections[i].peerConnection.RemoveTrack(sender); err != nil {
-						return true
-					}
-				}
-			}
-
-			// Don't receive videos we are sending, make sure we don't have loopback
-			for _, receiver := range peerConnections[i].peerConnection.GetReceivers() {
-				if receiver.Track() == nil {
-					continue
-				}
-
-				existingSenders[receiver.Track().ID()] = true
-			}
-
-			// Add all track we aren't sending yet to the PeerConnection
-			for trackID := range trackLocals {
-				if _, ok := existingSenders[trackID]; !ok {
-					if _, err := peerConnections[i].peerConnection.AddTrack(trackLocals[trackID]); err != nil {
-						return true
-					}
-				}
-			}
-
-			offer, err := peerConnections[i].peerConnection.CreateOffer(nil)
-			if err != nil {
-				return true
-			}
-
-			if err = peerConnections[i].peerConnection.SetLocalDescription(offer); err != nil {
-				return true
-			}
+// signalPeerConnections asks every PeerConnection to renegotiate its tracks.
+// The signallers coalesce the requests and build their offers without listLock.
+func signalPeerConnections() {
+	listLock.RLock()
+	defer listLock.RUnlock()
 
-			offerString, err := json.Marshal(offer)
-			if err != nil {
-				log.Errorf("Failed to marshal offer to json: %v", err)
-
-				return true
-			}
-
-			log.Infof("Send offer to client: %v", offer)
-
-			if err = peerConnections[i].websocket.WriteJSON(&websocketMessage{
-				Event: "offer",
-				Data:  string(offerString),
-			}); err != nil {
-				return true
-			}
-		}
-
-		return tryAgain
-	}
-
-	for syncAttempt := 0; ; syncAttempt++ {
-		if syncAttempt == 25 {
-			// Release the lock and attempt a sync in 3 seconds. We might be blocking a RemoveTrack or AddTrack
-			go func() {
-				time.Sleep(time.Second * 3)
-				signalPeerConnections()
-			}()
-
-			return
-		}
-
-		if !attemptSync() {
-			break
-		}
+	for i := range peerConnections {
+		peerConnections[i].signaller.request()
 	}
 }
 
-// dispatchKeyFrame sends a keyframe to all PeerConnections, used everytime a new user joins the call.
+// dispatchKeyFrame requests a keyframe for every incoming video track, used
+// everytime a new user joins the call. Audio tracks have no keyframes.
 func dispatchKeyFrame() {
-	listLock.Lock()
-	defer listLock.Unlock()
-
+	listLock.RLock()
+	pcs := make([]*webrtc.PeerConnection, 0, len(peerConnections))
 	for i := range peerConnections {
-		for _, receiver := range peerConnections[i].peerConnection.GetReceivers() {
-			if receiver.Track() == nil {
+		pcs = append(pcs, peerConnections[i].peerConnection)
+	}
+	listLock.RUnlock()
+
+	for _, pc := range pcs {
+		for _, receiver := range pc.GetReceivers() {
+			if receiver.Track() == nil || receiver.Track().Kind() != webrtc.RTPCodecTypeVideo {
 				continue
 			}
 
-			_ = peerConnections[i].peerConnection.WriteRTCP([]rtcp.Packet{
+			_ = pc.WriteRTCP([]rtcp.Packet{
 				&rtcp.PictureLossIndication{
 					MediaSSRC: uint32(receiver.Track().SSRC()),
 				},
@@ -238,7 +853,7 @@ func dispatchKeyFrame() {
 
 const messageSize = 1024 * 8
 
-func ReadLoop(d io.Reader, ip [4]byte) {
+func ReadLoop(d io.Reader, addr goxash3d_fwgs.Addr) {
 	for {
 		buffer := make([]byte, messageSize)
 		n, err := d.Read(buffer)
@@ -247,11 +862,8 @@ func ReadLoop(d io.Reader, ip [4]byte) {
 
 			return
 		}
-		net.PushPacket(goxash3d_fwgs.Packet{
-			Addr: goxash3d_fwgs.Addr{
-				IP:   ip,
-				Port: 1000,
-			},
+		inbound.PushPacket(goxash3d_fwgs.Packet{
+			Addr: addr,
 			Data: buffer[:n],
 		})
 	}
@@ -259,6 +871,18 @@ func ReadLoop(d io.Reader, ip [4]byte) {
 
 // Handle incoming websockets.
 func websocketHandler(w http.ResponseWriter, r *http.Request) { // nolint
+	identity := auth.Anonymous
+	if authenticator != nil {
+		id, err := authenticator.Authenticate(r)
+		if err != nil {
+			log.Warnf("Rejected signalling session from %s: %v", r.RemoteAddr, err)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+			return
+		}
+		identity = id
+	}
+
 	// Upgrade HTTP request to Websocket
 	unsafeConn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -295,24 +919,49 @@ func websocketHandler(w http.ResponseWriter, r *http.Request) { // nolint
 	}
 
 	// Add our new PeerConnection to global list
+	sig := newSignaller(peerConnection, c)
 	listLock.Lock()
-	peerConnections = append(peerConnections, peerConnectionState{peerConnection, c})
+	peerConnections = append(peerConnections, peerConnectionState{
+		peerConnection: peerConnection,
+		websocket:      c,
+		signaller:      sig,
+	})
 	listLock.Unlock()
+	defer removePeerConnection(peerConnection)
 
 	f := false
 	var z uint16 = 0
-	ip := [4]byte{}
-	for i := range ip {
-		ip[i] = byte(rand.Intn(256))
+
+	// Resume the engine client of a previous connection or start a new one
+	sess, generation, err := openSession(r.URL.Query().Get("resume"), identity)
+	if err != nil {
+		log.Errorf("Failed to open session: %v", err)
+
+		return
 	}
-	index, _ := pool.TryGet()
-	ip[0] = index
-	defer pool.TryPut(index)
+	defer sess.detach(generation)
 
-	writeChannel, err := peerConnection.CreateDataChannel("write", &webrtc.DataChannelInit{
-		Ordered:        &f,
-		MaxRetransmits: &z,
-	})
+	addr := sess.addr
+	players.Default.AttachPeer(addr, peerConnection)
+	defer forgetListener(peerConnection)
+
+	if err := c.WriteJSON(&websocketMessage{
+		Event: "session",
+		Data:  sess.token,
+	}); err != nil {
+		log.Errorf("Failed to write JSON: %v", err)
+
+		return
+	}
+
+	// Clients connecting with ?channels=single send and receive over one
+	// bidirectional channel, older clients get the "write" and "read" pair
+	single := r.URL.Query().Get("channels") == "single"
+	label := channelLabels[ProfileUnreliable]
+	if single {
+		label = singleChannelLabel
+	}
+	writeChannel, err := peerConnection.CreateDataChannel(label, net.Channels.init(ProfileUnreliable))
 	if err != nil {
 		log.Errorf("Failed to creates a data channel: %v", err)
 
@@ -329,7 +978,31 @@ func websocketHandler(w http.ResponseWriter, r *http.Request) { // nolint
 		if err != nil {
 			panic(err)
 		}
-		connections[index] = d
+		sess.attach(ProfileUnreliable, d)
+
+		// Open the channels of the other profiles, packets use the unreliable
+		// channel until they are ready
+		for _, profile := range net.Channels.Profiles {
+			dc, err := peerConnection.CreateDataChannel(channelLabels[profile], net.Channels.init(profile))
+			if err != nil {
+				log.Errorf("Failed to creates a data channel: %v", err)
+
+				continue
+			}
+			dc.OnOpen(func() {
+				d, err := dc.Detach()
+				if err != nil {
+					panic(err)
+				}
+				sess.attach(profile, d)
+			})
+		}
+
+		if single {
+			go ReadLoop(d, addr)
+
+			return
+		}
 
 		rc, err := peerConnection.CreateDataChannel("read", &webrtc.DataChannelInit{
 			Ordered:        &f,
@@ -337,16 +1010,24 @@ func websocketHandler(w http.ResponseWriter, r *http.Request) { // nolint
 		})
 		if err != nil {
 			log.Errorf("Failed to creates a data channel: %v", err)
+			peerConnection.Close()
 
 			return
 		}
+		// a peer whose read channel never opens is dropped instead of
+		// staying half connected
+		opening := time.AfterFunc(readChannelTimeout, func() {
+			log.Errorf("Read channel did not open in %v, closing the peer", readChannelTimeout)
+			peerConnection.Close()
+		})
 		readChannel = rc
 		readChannel.OnOpen(func() {
+			opening.Stop()
 			d, err := readChannel.Detach()
 			if err != nil {
 				panic(err)
 			}
-			go ReadLoop(d, ip)
+			go ReadLoop(d, addr)
 		})
 	})
 	defer writeChannel.Close()
@@ -375,17 +1056,27 @@ func websocketHandler(w http.ResponseWriter, r *http.Request) { // nolint
 		}
 	})
 
+	var stateLock sync.Mutex
+	lastState := webrtc.PeerConnectionStateNew
+	peerStates.With(lastState.String()).Inc()
+
 	// If PeerConnection is closed remove it from global list
 	peerConnection.OnConnectionStateChange(func(p webrtc.PeerConnectionState) {
 		log.Infof("Connection state change: %s", p)
 
+		stateLock.Lock()
+		peerStates.With(lastState.String()).Dec()
+		if p != webrtc.PeerConnectionStateClosed {
+			peerStates.With(p.String()).Inc()
+		}
+		lastState = p
+		stateLock.Unlock()
+
 		switch p {
 		case webrtc.PeerConnectionStateFailed:
 			if err := peerConnection.Close(); err != nil {
 				log.Errorf("Failed to close PeerConnection: %v", err)
 			}
-		case webrtc.PeerConnectionStateClosed:
-			signalPeerConnections()
 		default:
 		}
 	})
@@ -393,8 +1084,8 @@ func websocketHandler(w http.ResponseWriter, r *http.Request) { // nolint
 	peerConnection.OnTrack(func(t *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
 		log.Infof("Got remote track: Kind=%s, ID=%s, PayloadType=%d", t.Kind(), t.ID(), t.PayloadType())
 
-		// Create a track to fan out our incoming video to all peers
-		trackLocal := addTrack(t)
+		// Create a track to fan out our incoming voice to the listeners
+		trackLocal := addTrack(t, addr)
 		defer removeTrack(trackLocal)
 
 		buf := make([]byte, 1500)
@@ -415,20 +1106,17 @@ func websocketHandler(w http.ResponseWriter, r *http.Request) { // nolint
 			rtpPkt.Extension = false
 			rtpPkt.Extensions = nil
 
-			if err = trackLocal.WriteRTP(rtpPkt); err != nil {
-				return
-			}
+			trackLocal.WriteRTP(rtpPkt)
 		}
 	})
+	go sendVoiceGains(peerConnection, c, addr)
 
 	peerConnection.OnICEConnectionStateChange(func(is webrtc.ICEConnectionState) {
 		log.Infof("ICE connection state changed: %s", is)
 	})
 
 	// Signal for the new PeerConnection
-	signalPeerConnections()
-
-	wasAnswer := false
+	sig.request()
 
 	message := &websocketMessage{}
 	for {
@@ -463,6 +1151,18 @@ func websocketHandler(w http.ResponseWriter, r *http.Request) { // nolint
 
 				return
 			}
+		case "mute", "unmute":
+			listLock.RLock()
+			track, ok := trackLocals[message.Data]
+			listLock.RUnlock()
+			if !ok {
+				continue
+			}
+			if message.Event == "mute" {
+				voice.DefaultRouter.Mute(addr, track.speaker)
+			} else {
+				voice.DefaultRouter.Unmute(addr, track.speaker)
+			}
 		case "answer":
 			answer := webrtc.SessionDescription{}
 			if err := json.Unmarshal([]byte(message.Data), &answer); err != nil {
@@ -473,15 +1173,165 @@ func websocketHandler(w http.ResponseWriter, r *http.Request) { // nolint
 
 			log.Infof("Got answer: %v", answer)
 
-			if err := peerConnection.SetRemoteDescription(answer); err != nil {
+			// a failed answer is renegotiated, the session goes on
+			if err := sig.handleAnswer(answer); errors.Is(err, errAnswerIgnored) {
+				log.Infof("Ignored answer: %v", err)
+
+				continue
+			} else if err != nil {
 				log.Errorf("Failed to set remote description: %v", err)
 
+				continue
+			}
+			dispatchKeyFrame()
+		case "offer":
+			offer := webrtc.SessionDescription{}
+			if err := json.Unmarshal([]byte(message.Data), &offer); err != nil {
+				log.Errorf("Failed to unmarshal json to offer: %v", err)
+
+				return
+			}
+
+			log.Infof("Got offer: %v", offer)
+
+			if err := sig.handleOffer(offer); err != nil {
+				log.Errorf("Failed to answer offer: %v", err)
+
+				continue
+			}
+		default:
+			log.Errorf("unknown message: %+v", message)
+		}
+	}
+}
+
+// spectateHandler streams the relayed game to a viewer over a reliable
+// "spectate" data channel. Viewers never reach the engine.
+func spectateHandler(w http.ResponseWriter, r *http.Request) {
+	if authenticator != nil {
+		if _, err := authenticator.Authenticate(r); err != nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+			return
+		}
+	}
+
+	unsafeConn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Errorf("Failed to upgrade HTTP to Websocket: ", err)
+
+		return
+	}
+	c := &threadSafeWriter{unsafeConn, sync.Mutex{}} // nolint
+
+	defer c.Close() //nolint
+
+	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		log.Errorf("Failed to creates a PeerConnection: %v", err)
+
+		return
+	}
+	defer peerConnection.Close() //nolint
+
+	channel, err := peerConnection.CreateDataChannel("spectate", nil)
+	if err != nil {
+		log.Errorf("Failed to creates a data channel: %v", err)
+
+		return
+	}
+	channel.OnOpen(func() {
+		d, err := channel.Detach()
+		if err != nil {
+			log.Errorf("Failed to detach data channel: %v", err)
+
+			return
+		}
+		viewer := spectators.AddViewer(d)
+		go func() {
+			<-viewer.Done()
+			d.Close()
+		}()
+		peerConnection.OnConnectionStateChange(func(p webrtc.PeerConnectionState) {
+			if p == webrtc.PeerConnectionStateFailed || p == webrtc.PeerConnectionStateClosed {
+				viewer.Close()
+			}
+		})
+	})
+
+	peerConnection.OnICECandidate(func(i *webrtc.ICECandidate) {
+		if i == nil {
+			return
+		}
+		candidateString, err := json.Marshal(i.ToJSON())
+		if err != nil {
+			return
+		}
+		if writeErr := c.WriteJSON(&websocketMessage{
+			Event: "candidate",
+			Data:  string(candidateString),
+		}); writeErr != nil {
+			log.Errorf("Failed to write JSON: %v", writeErr)
+		}
+	})
+
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		log.Errorf("Failed to create offer: %v", err)
+
+		return
+	}
+	if err = peerConnection.SetLocalDescription(offer); err != nil {
+		log.Errorf("Failed to set local description: %v", err)
+
+		return
+	}
+	offerString, err := json.Marshal(offer)
+	if err != nil {
+		return
+	}
+	if err = c.WriteJSON(&websocketMessage{
+		Event: "offer",
+		Data:  string(offerString),
+	}); err != nil {
+		log.Errorf("Failed to write JSON: %v", err)
+
+		return
+	}
+
+	message := &websocketMessage{}
+	for {
+		_, raw, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := json.Unmarshal(raw, &message); err != nil {
+			log.Errorf("Failed to unmarshal json to message: %v", err)
+
+			return
+		}
+
+		switch message.Event {
+		case "candidate":
+			candidate := webrtc.ICECandidateInit{}
+			if err := json.Unmarshal([]byte(message.Data), &candidate); err != nil {
+				return
+			}
+			if err := peerConnection.AddICECandidate(candidate); err != nil {
+				log.Errorf("Failed to add ICE candidate: %v", err)
+
 				return
 			}
-			if !wasAnswer {
-				signalPeerConnections()
+		case "answer":
+			answer := webrtc.SessionDescription{}
+			if err := json.Unmarshal([]byte(message.Data), &answer); err != nil {
+				return
+			}
+			if err := peerConnection.SetRemoteDescription(answer); err != nil {
+				log.Errorf("Failed to set remote description: %v", err)
+
+				return
 			}
-			wasAnswer = true
 		default:
 			log.Errorf("unknown message: %+v", message)
 		}
@@ -501,6 +1351,33 @@ func (t *threadSafeWriter) WriteJSON(v interface{}) error {
 	return t.Conn.WriteJSON(v)
 }
 
+// newAuthenticator builds the signalling authenticator from the environment.
+//
+// AUTH_ORIGINS is a comma separated origin allowlist and AUTH_SECRET
+// enables HMAC-signed JWT bearer tokens. Without either every peer is accepted.
+func newAuthenticator() auth.Authenticator {
+	var authenticators []auth.Authenticator
+
+	if origins, ok := os.LookupEnv("AUTH_ORIGINS"); ok {
+		allowlist := auth.ParseOrigins(origins)
+		upgrader.CheckOrigin = allowlist.CheckOrigin
+		authenticators = append(authenticators, allowlist)
+	}
+
+	if secret, ok := os.LookupEnv("AUTH_SECRET"); ok {
+		hmacAuth := auth.NewHMACAuthenticator([]byte(secret))
+		hmacAuth.Issuer = os.Getenv("AUTH_ISSUER")
+		hmacAuth.Audience = os.Getenv("AUTH_AUDIENCE")
+		hmacAuth.Leeway = 30 * time.Second
+		authenticators = append(authenticators, hmacAuth)
+	}
+
+	if len(authenticators) == 0 {
+		return nil
+	}
+	return auth.All(authenticators...)
+}
+
 func runSFU() {
 	settingEngine := webrtc.SettingEngine{}
 	settingEngine.DetachDataChannels()
@@ -535,13 +1412,120 @@ func runSFU() {
 	}
 	api = webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine), webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i))
 
+	authenticator = newAuthenticator()
+	registerCommands()
+	newChat().Install(goxash3d_fwgs.DefaultXash3D)
+	adminServer := newAdmin()
+	if adminServer != nil && authenticator != nil {
+		authenticator = adminServer.Bans().Authenticator(authenticator)
+	}
+	voice.DefaultRouter.SetRules(newVoiceRules())
+	voice.DefaultTable.Follow(goxash3d_fwgs.DefaultXash3D)
+	if channels, ok := os.LookupEnv("CHANNELS"); ok {
+		config, err := parseChannelConfig(channels)
+		if err != nil {
+			panic(err)
+		}
+		net.Channels = config
+	}
+	if bots, ok := os.LookupEnv("BOTS"); ok {
+		count, err := strconv.Atoi(bots)
+		if err != nil {
+			panic(err)
+		}
+		for i := 1; i <= count; i++ {
+			go runBot(fmt.Sprintf("bot%d", i))
+		}
+	}
+	if recorder != nil {
+		go runRecorder()
+	}
+	if spectators != nil {
+		go spectators.Run(context.Background(), func(context.Context) (client.Transport, error) {
+			return local.Dial(), nil
+		})
+		http.HandleFunc("/spectate", spectateHandler)
+		if metricsEnabled {
+			metrics.DefaultRegistry.GaugeFunc("xash_relay_viewers", "Spectators attached to the relay.", func() float64 {
+				return float64(spectators.Viewers())
+			})
+		}
+	}
+
 	// Init other state
-	trackLocals = map[string]*webrtc.TrackLocalStaticRTP{}
+	trackLocals = map[string]*forwardedTrack{}
 
 	// websocket handler
 	http.HandleFunc("/websocket", websocketHandler)
+	if metricsEnabled {
+		http.Handle("/metrics", metrics.Handler())
+	}
+	if simulator != nil && adminServer != nil {
+		// impairing the server is an operator action
+		http.Handle("/netsim", simulator.Handler(netsim.HandlerOptions{
+			Authorize: func(r *http.Request) bool {
+				_, ok := adminServer.Authorize(r, admin.RoleOperator)
+				return ok
+			},
+		}))
+	} else if simulator != nil {
+		log.Warnf("NETSIM is set without ADMIN_KEYS, /netsim is not served")
+	}
+	if downloads := newFastDL(); downloads != nil {
+		http.Handle("/fastdl/", http.StripPrefix("/fastdl", downloads))
+	}
+	if page := newMOTD(); page != nil {
+		http.Handle("/motd/", http.StripPrefix("/motd", page.Handler()))
+	}
+	if mapManager != nil {
+		// read-only unless operator keys may change the rotation
+		var mapOpts mapcycle.HandlerOptions
+		if adminServer != nil {
+			mapOpts.Authorize = func(r *http.Request) (string, bool) {
+				key, ok := adminServer.Authorize(r, admin.RoleOperator)
+				return key.Name, ok
+			}
+			mapOpts.Audit = func(r *http.Request, by, change string) {
+				adminServer.Record(admin.AuditEntry{
+					Key:    by,
+					Role:   admin.RoleOperator.String(),
+					Remote: r.RemoteAddr,
+					Action: "maps",
+					Detail: change,
+					Status: http.StatusOK,
+				})
+			}
+		}
+		http.Handle("/maps", mapManager.Handler(mapOpts))
+		go mapManager.Run(context.Background()) //nolint
+	}
+	if adminServer != nil {
+		http.Handle("/admin/", http.StripPrefix("/admin", adminServer.Handler()))
+
+		// rcon through the engine from an in-process peer, for admin keys only
+		console := rcon.NewConsole(local.Dial(), rcon.ConsoleOptions{})
+		console.Follow(goxash3d_fwgs.DefaultXash3D)
+		rconFilter.Allow(console.Addr())
+		go console.Run(context.Background()) //nolint
+		http.Handle("/admin/rcon/", http.StripPrefix("/admin/rcon", console.Handler(rcon.HandlerOptions{
+			Authorize: func(r *http.Request) (string, bool) {
+				key, ok := adminServer.Authorize(r, admin.RoleAdmin)
+				return key.Name, ok
+			},
+			Audit: func(r *http.Request, by, command string) {
+				adminServer.Record(admin.AuditEntry{
+					Key:    by,
+					Role:   admin.RoleAdmin.String(),
+					Remote: r.RemoteAddr,
+					Action: "rcon",
+					Detail: command,
+					Status: http.StatusOK,
+				})
+			},
+		})))
+	}
 
-	// request a keyframe every 3 seconds
+	// request a keyframe of video tracks every 3 seconds
 	go func() {
 		for range time.NewTicker(time.Second * 3).C {
 			dispatchKeyFrame()
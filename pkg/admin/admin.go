@@ -0,0 +1,212 @@
+// Package admin serves a REST/JSON API to manage a running server: list
+// and kick players, ban accounts, change the map, run console commands,
+// read and set cvars and view the status.
+//
+// Requests authenticate with an API key as a bearer token. Every key has a
+// Role; read endpoints need RoleViewer, player and map management
+// RoleOperator, console access and bans RoleAdmin. Every admin action is
+// written to the audit log.
+package admin
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"github.com/yohimik/goxash3d-fwgs/pkg/auth"
+	"github.com/yohimik/goxash3d-fwgs/pkg/mapcycle"
+	"github.com/yohimik/goxash3d-fwgs/pkg/players"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownRole = errors.New("admin: unknown role")
+	ErrBadKey      = errors.New("admin: malformed key")
+)
+
+// Role is the permission level of an API key. Higher roles include the lower ones.
+type Role uint8
+
+const (
+	RoleViewer Role = iota + 1
+	RoleOperator
+	RoleAdmin
+)
+
+// String returns the name of the role.
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// ParseRole parses "viewer", "operator" or "admin".
+func ParseRole(s string) (Role, error) {
+	for _, r := range []Role{RoleViewer, RoleOperator, RoleAdmin} {
+		if strings.EqualFold(s, r.String()) {
+			return r, nil
+		}
+	}
+	return 0, ErrUnknownRole
+}
+
+// Key is an API key.
+type Key struct {
+	// Name identifies the key holder in the audit log.
+	Name  string
+	Role  Role
+	Token string
+}
+
+// ParseKeys parses a comma separated list of name:role:token keys,
+// e.g. "ci:operator:s3cr3t,alice:admin:t0k3n".
+func ParseKeys(s string) ([]Key, error) {
+	var keys []Key
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		parts := strings.SplitN(field, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("%w: %q", ErrBadKey, parts[0])
+		}
+		role, err := ParseRole(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, Key{Name: parts[0], Role: role, Token: parts[2]})
+	}
+	return keys, nil
+}
+
+// Options configures a Server.
+type Options struct {
+	Keys []Key
+	// Registry lists the players, players.Default by default.
+	Registry *players.Registry
+	// Bans stores the account bans, an in-memory list by default.
+	Bans *Bans
+	// Maps changes levels through the map manager when set,
+	// otherwise with a changelevel command.
+	Maps *mapcycle.Manager
+	// Exec runs console commands, DefaultXash3D.ExecCommand by default.
+	Exec func(cmd string)
+	// Cvar reads a cvar. Reading cvars is not available when nil.
+	Cvar func(name string) (string, bool)
+	// Secret reports whether a cvar holds a secret: it cannot be read and
+	// its value stays out of the audit log. SecretCvar by default.
+	Secret func(name string) bool
+	// Ping returns the round trip time of the peer at addr.
+	Ping func(addr goxash3d_fwgs.Addr) (time.Duration, bool)
+	// Transport names the transport of a player, e.g. "webrtc".
+	// By default it is derived from the type of the peer.
+	Transport func(p players.Player) string
+	// Audit receives the audit log as JSON lines, it may be nil.
+	Audit io.Writer
+	// AuditSize is the number of entries kept for GET /audit, 256 by default.
+	AuditSize int
+}
+
+// Server is the admin API.
+type Server struct {
+	opts    Options
+	started time.Time
+	audit   *auditLog
+}
+
+// New creates the admin API.
+func New(opts Options) *Server {
+	if opts.Registry == nil {
+		opts.Registry = players.Default
+	}
+	if opts.Bans == nil {
+		opts.Bans = NewBans()
+	}
+	if opts.Exec == nil {
+		opts.Exec = goxash3d_fwgs.DefaultXash3D.ExecCommand
+	}
+	if opts.Secret == nil {
+		opts.Secret = SecretCvar
+	}
+	if opts.Transport == nil {
+		opts.Transport = peerTransport
+	}
+	if opts.AuditSize == 0 {
+		opts.AuditSize = 256
+	}
+	return &Server{
+		opts:    opts,
+		started: time.Now(),
+		audit:   newAuditLog(opts.Audit, opts.AuditSize),
+	}
+}
+
+// Bans returns the ban list of the server.
+func (s *Server) Bans() *Bans {
+	return s.opts.Bans
+}
+
+// SecretCvar reports whether the name of a cvar contains "password",
+// e.g. rcon_password or sv_password.
+func SecretCvar(name string) bool {
+	return strings.Contains(strings.ToLower(name), "password")
+}
+
+func peerTransport(p players.Player) string {
+	switch peer := p.Peer.(type) {
+	case nil:
+		return "udp"
+	case *goxash3d_fwgs.LocalPeer:
+		return "local"
+	default:
+		name := fmt.Sprintf("%T", peer)
+		if strings.Contains(strings.ToLower(name), "webrtc") {
+			return "webrtc"
+		}
+		return name
+	}
+}
+
+// Authorize returns the key of the request if it has at least the role,
+// for endpoints served next to the API.
+func (s *Server) Authorize(r *http.Request, role Role) (Key, bool) {
+	key, status := s.authorize(r, role)
+	return key, status == 0
+}
+
+// Record writes an admin action to the audit log.
+func (s *Server) Record(e AuditEntry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	s.audit.record(e)
+}
+
+// authorize returns the key of the request if it has at least the role,
+// otherwise the HTTP status to reject it with.
+func (s *Server) authorize(r *http.Request, role Role) (Key, int) {
+	token := auth.BearerToken(r)
+	if token == "" {
+		return Key{}, http.StatusUnauthorized
+	}
+	for _, key := range s.opts.Keys {
+		if subtle.ConstantTimeCompare([]byte(key.Token), []byte(token)) == 1 {
+			if key.Role < role {
+				return key, http.StatusForbidden
+			}
+			return key, 0
+		}
+	}
+	return Key{}, http.StatusUnauthorized
+}
@@ -0,0 +1,56 @@
+package admin
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// AuditEntry records one admin action.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Key and Role are the name and role of the API key used.
+	Key    string `json:"key"`
+	Role   string `json:"role"`
+	Remote string `json:"remote"`
+	// Action is the endpoint, e.g. "kick" or "exec".
+	Action string `json:"action"`
+	Target string `json:"target,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	// Status is the HTTP status of the response.
+	Status int `json:"status"`
+}
+
+// auditLog writes entries as JSON lines and keeps the most recent ones.
+type auditLog struct {
+	mu      sync.Mutex
+	w       io.Writer
+	entries []AuditEntry
+	size    int
+}
+
+func newAuditLog(w io.Writer, size int) *auditLog {
+	return &auditLog{w: w, size: size}
+}
+
+func (l *auditLog) record(e AuditEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.w != nil {
+		if line, err := json.Marshal(e); err == nil {
+			_, _ = l.w.Write(append(line, '\n'))
+		}
+	}
+	l.entries = append(l.entries, e)
+	if len(l.entries) > l.size {
+		l.entries = l.entries[len(l.entries)-l.size:]
+	}
+}
+
+// Entries returns the recent entries, oldest first.
+func (l *auditLog) Entries() []AuditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]AuditEntry(nil), l.entries...)
+}
@@ -0,0 +1,156 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/yohimik/goxash3d-fwgs/pkg/auth"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrBanned = errors.New("admin: account is banned")
+
+// Ban bans an account, identified by its auth.Identity subject.
+type Ban struct {
+	Subject string `json:"subject"`
+	// Name is the player name at the time of the ban.
+	Name    string    `json:"name,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	By      string    `json:"by,omitempty"`
+	Created time.Time `json:"created"`
+	// Expires is zero for permanent bans.
+	Expires time.Time `json:"expires,omitzero"`
+}
+
+// Expired reports whether the ban no longer applies at t.
+func (b Ban) Expired(t time.Time) bool {
+	return !b.Expires.IsZero() && !t.Before(b.Expires)
+}
+
+// Bans is a list of account bans, optionally persisted to a JSON file.
+type Bans struct {
+	mu   sync.Mutex
+	bans map[string]Ban
+	path string
+}
+
+// NewBans creates an empty in-memory ban list.
+func NewBans() *Bans {
+	return &Bans{bans: make(map[string]Ban)}
+}
+
+// LoadBans reads the ban list from path and saves every change back to it.
+// A missing file is an empty list.
+func LoadBans(path string) (*Bans, error) {
+	b := NewBans()
+	b.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Ban
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, ban := range list {
+		b.bans[ban.Subject] = ban
+	}
+	return b, nil
+}
+
+// Add bans the subject of the ban, replacing an earlier ban.
+func (b *Bans) Add(ban Ban) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ban.Created.IsZero() {
+		ban.Created = time.Now()
+	}
+	b.bans[ban.Subject] = ban
+	return b.save()
+}
+
+// Remove lifts the ban of the subject.
+func (b *Bans) Remove(subject string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.bans[subject]; !ok {
+		return false, nil
+	}
+	delete(b.bans, subject)
+	return true, b.save()
+}
+
+// Lookup returns the ban of the subject if it still applies.
+func (b *Bans) Lookup(subject string) (Ban, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ban, ok := b.bans[subject]
+	if !ok || ban.Expired(time.Now()) {
+		return Ban{}, false
+	}
+	return ban, true
+}
+
+// List returns the bans that still apply, oldest first.
+func (b *Bans) List() []Ban {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	list := make([]Ban, 0, len(b.bans))
+	for _, ban := range b.bans {
+		if !ban.Expired(now) {
+			list = append(list, ban)
+		}
+	}
+	slices.SortFunc(list, func(a, b Ban) int { return a.Created.Compare(b.Created) })
+	return list
+}
+
+// save writes the list to the file, caller holds mu.
+func (b *Bans) save() error {
+	if b.path == "" {
+		return nil
+	}
+	list := make([]Ban, 0, len(b.bans))
+	for _, ban := range b.bans {
+		list = append(list, ban)
+	}
+	slices.SortFunc(list, func(a, b Ban) int { return a.Created.Compare(b.Created) })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path)
+}
+
+// Authenticator rejects signalling sessions of banned accounts after the
+// inner authenticator accepted them. A nil inner admits everyone anonymously,
+// and anonymous peers cannot be banned.
+func (b *Bans) Authenticator(inner auth.Authenticator) auth.Authenticator {
+	return auth.AuthenticatorFunc(func(r *http.Request) (*auth.Identity, error) {
+		identity := auth.Anonymous
+		if inner != nil {
+			id, err := inner.Authenticate(r)
+			if err != nil {
+				return nil, err
+			}
+			identity = id
+		}
+		if identity != nil && identity.Subject != "" {
+			if _, banned := b.Lookup(identity.Subject); banned {
+				return nil, ErrBanned
+			}
+		}
+		return identity, nil
+	})
+}
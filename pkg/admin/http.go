@@ -0,0 +1,436 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"github.com/yohimik/goxash3d-fwgs/pkg/players"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// response is the result of an endpoint.
+type response struct {
+	status int
+	body   any
+	// target and detail are written to the audit log
+	target string
+	detail string
+}
+
+func fail(status int, format string, args ...any) response {
+	return response{status: status, body: map[string]string{"error": fmt.Sprintf(format, args...)}}
+}
+
+// Handler serves the API. Mount it with http.StripPrefix, e.g. under /admin/:
+//
+//	GET    /status                  server status (viewer)
+//	GET    /players                 players with ping, address and transport (viewer)
+//	POST   /players/{addr}/kick     {"reason":"..."} (operator)
+//	POST   /players/{addr}/ban      {"reason":"...","duration":"24h"} (admin)
+//	GET    /bans                    bans (viewer)
+//	POST   /bans                    {"subject":"...","reason":"...","duration":"24h"} (admin)
+//	DELETE /bans/{subject}          lift a ban (admin)
+//	POST   /map                     {"map":"crossfire"} (operator)
+//	POST   /exec                    {"command":"..."} (admin)
+//	GET    /cvars/{name}            read a cvar (viewer)
+//	PUT    /cvars/{name}            {"value":"..."} (admin)
+//	GET    /audit                   recent admin actions (admin)
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	s.handle(mux, "GET /status", RoleViewer, "", s.status)
+	s.handle(mux, "GET /players", RoleViewer, "", s.players)
+	s.handle(mux, "POST /players/{addr}/kick", RoleOperator, "kick", s.kick)
+	s.handle(mux, "POST /players/{addr}/ban", RoleAdmin, "ban", s.banPlayer)
+	s.handle(mux, "GET /bans", RoleViewer, "", s.bans)
+	s.handle(mux, "POST /bans", RoleAdmin, "ban", s.banSubject)
+	s.handle(mux, "DELETE /bans/{subject}", RoleAdmin, "unban", s.unban)
+	s.handle(mux, "POST /map", RoleOperator, "map", s.changeMap)
+	s.handle(mux, "POST /exec", RoleAdmin, "exec", s.exec)
+	s.handle(mux, "GET /cvars/{name}", RoleViewer, "", s.cvar)
+	s.handle(mux, "PUT /cvars/{name}", RoleAdmin, "cvar", s.setCvar)
+	s.handle(mux, "GET /audit", RoleAdmin, "", s.auditEntries)
+	return mux
+}
+
+// handle registers an endpoint. Endpoints with an action are audited,
+// including rejected attempts.
+func (s *Server) handle(mux *http.ServeMux, pattern string, role Role, action string, fn func(r *http.Request, key Key) response) {
+	mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		key, status := s.authorize(r, role)
+		var res response
+		if status != 0 {
+			res = fail(status, "%s", strings.ToLower(http.StatusText(status)))
+		} else {
+			res = fn(r, key)
+		}
+		if res.status == 0 {
+			res.status = http.StatusOK
+		}
+		if action != "" {
+			s.audit.record(AuditEntry{
+				Time:   time.Now(),
+				Key:    key.Name,
+				Role:   key.Role.String(),
+				Remote: r.RemoteAddr,
+				Action: action,
+				Target: res.target,
+				Detail: res.detail,
+				Status: res.status,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(res.status)
+		if res.body != nil {
+			_ = json.NewEncoder(w).Encode(res.body)
+		}
+	})
+}
+
+// decode reads the JSON request body into v.
+func decode(r *http.Request, v any) error {
+	return json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<16)).Decode(v)
+}
+
+// safe reports whether an argument can be passed to a console command.
+func safe(arg string) bool {
+	return !strings.ContainsAny(arg, "\";\r\n")
+}
+
+// identifier reports whether s is a map or cvar name.
+func identifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Status is the body of GET /status.
+type Status struct {
+	Uptime    string `json:"uptime"`
+	Players   int    `json:"players"`
+	Connected int    `json:"connected"`
+	Map       string `json:"map,omitempty"`
+	NextMap   string `json:"next_map,omitempty"`
+	Hostname  string `json:"hostname,omitempty"`
+	Bans      int    `json:"bans"`
+}
+
+func (s *Server) status(r *http.Request, key Key) response {
+	st := Status{
+		Uptime: time.Since(s.started).Round(time.Second).String(),
+		Bans:   len(s.opts.Bans.List()),
+	}
+	for _, p := range s.opts.Registry.Players() {
+		st.Players++
+		if p.Connected {
+			st.Connected++
+		}
+	}
+	if s.opts.Maps != nil {
+		st.Map = s.opts.Maps.Current()
+		st.NextMap = s.opts.Maps.Next()
+	}
+	if s.opts.Cvar != nil {
+		st.Hostname, _ = s.opts.Cvar("hostname")
+		if st.Map == "" {
+			st.Map, _ = s.opts.Cvar("mapname")
+		}
+	}
+	return response{body: st}
+}
+
+// PlayerView is an entry of GET /players.
+type PlayerView struct {
+	Addr      string    `json:"addr"`
+	Name      string    `json:"name"`
+	Slot      int       `json:"slot"`
+	UserID    int       `json:"userid"`
+	Subject   string    `json:"subject,omitempty"`
+	Transport string    `json:"transport"`
+	Ping      float64   `json:"ping_ms,omitempty"`
+	Connected bool      `json:"connected"`
+	Since     time.Time `json:"since"`
+}
+
+func (s *Server) view(p players.Player) PlayerView {
+	v := PlayerView{
+		Addr:      p.Addr.String(),
+		Name:      p.Name,
+		Slot:      p.Slot,
+		UserID:    p.UserID,
+		Transport: s.opts.Transport(p),
+		Connected: p.Connected,
+		Since:     p.Since,
+	}
+	if p.Identity != nil {
+		v.Subject = p.Identity.Subject
+	}
+	if s.opts.Ping != nil {
+		if rtt, ok := s.opts.Ping(p.Addr); ok {
+			v.Ping = float64(rtt.Microseconds()) / 1000
+		}
+	}
+	return v
+}
+
+func (s *Server) players(r *http.Request, key Key) response {
+	list := s.opts.Registry.Players()
+	out := make([]PlayerView, 0, len(list))
+	for _, p := range list {
+		out = append(out, s.view(p))
+	}
+	return response{body: out}
+}
+
+// player resolves the {addr} path value.
+func (s *Server) player(r *http.Request) (players.Player, *response) {
+	addr, ok := goxash3d_fwgs.ParseAddr(r.PathValue("addr"))
+	if !ok {
+		res := fail(http.StatusBadRequest, "invalid addr")
+		return players.Player{}, &res
+	}
+	p, ok := s.opts.Registry.ByAddr(addr)
+	if !ok {
+		res := fail(http.StatusNotFound, "no player at %s", addr)
+		res.target = addr.String()
+		return players.Player{}, &res
+	}
+	return p, nil
+}
+
+// kickCommand returns the console command that drops the player.
+func kickCommand(p players.Player, reason string) (string, bool) {
+	var cmd string
+	switch {
+	case p.UserID >= 0:
+		cmd = "kick #" + strconv.Itoa(p.UserID)
+	case p.Name != "" && safe(p.Name):
+		cmd = `kick "` + p.Name + `"`
+	default:
+		return "", false
+	}
+	if reason != "" {
+		cmd += ` "` + reason + `"`
+	}
+	return cmd, true
+}
+
+type kickRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (s *Server) kick(r *http.Request, key Key) response {
+	p, res := s.player(r)
+	if res != nil {
+		return *res
+	}
+	var req kickRequest
+	if r.ContentLength != 0 {
+		if err := decode(r, &req); err != nil {
+			return fail(http.StatusBadRequest, "%v", err)
+		}
+	}
+	if !safe(req.Reason) {
+		return fail(http.StatusBadRequest, "invalid reason")
+	}
+	cmd, ok := kickCommand(p, req.Reason)
+	if !ok {
+		return fail(http.StatusConflict, "player is not in game")
+	}
+	s.opts.Exec(cmd)
+	return response{status: http.StatusAccepted, body: s.view(p), target: p.Addr.String(), detail: req.Reason}
+}
+
+type banRequest struct {
+	Subject  string `json:"subject"`
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"`
+}
+
+func (s *Server) newBan(req banRequest, key Key) (Ban, *response) {
+	ban := Ban{Subject: req.Subject, Reason: req.Reason, By: key.Name, Created: time.Now()}
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			res := fail(http.StatusBadRequest, "invalid duration")
+			return Ban{}, &res
+		}
+		ban.Expires = ban.Created.Add(d)
+	}
+	if !safe(req.Reason) {
+		res := fail(http.StatusBadRequest, "invalid reason")
+		return Ban{}, &res
+	}
+	return ban, nil
+}
+
+func (s *Server) banPlayer(r *http.Request, key Key) response {
+	p, res := s.player(r)
+	if res != nil {
+		return *res
+	}
+	var req banRequest
+	if r.ContentLength != 0 {
+		if err := decode(r, &req); err != nil {
+			return fail(http.StatusBadRequest, "%v", err)
+		}
+	}
+	if p.Identity == nil || p.Identity.Subject == "" {
+		res := fail(http.StatusConflict, "player has no authenticated identity")
+		res.target = p.Addr.String()
+		return res
+	}
+	req.Subject = p.Identity.Subject
+	ban, res := s.newBan(req, key)
+	if res != nil {
+		return *res
+	}
+	ban.Name = p.Name
+	if err := s.opts.Bans.Add(ban); err != nil {
+		return fail(http.StatusInternalServerError, "%v", err)
+	}
+	if cmd, ok := kickCommand(p, "banned"); ok {
+		s.opts.Exec(cmd)
+	}
+	return response{status: http.StatusCreated, body: ban, target: ban.Subject, detail: ban.Reason}
+}
+
+func (s *Server) banSubject(r *http.Request, key Key) response {
+	var req banRequest
+	if err := decode(r, &req); err != nil {
+		return fail(http.StatusBadRequest, "%v", err)
+	}
+	if req.Subject == "" {
+		return fail(http.StatusBadRequest, "subject is required")
+	}
+	ban, res := s.newBan(req, key)
+	if res != nil {
+		return *res
+	}
+	if p, ok := s.opts.Registry.BySubject(ban.Subject); ok {
+		ban.Name = p.Name
+		if cmd, ok := kickCommand(p, "banned"); ok {
+			s.opts.Exec(cmd)
+		}
+	}
+	if err := s.opts.Bans.Add(ban); err != nil {
+		return fail(http.StatusInternalServerError, "%v", err)
+	}
+	return response{status: http.StatusCreated, body: ban, target: ban.Subject, detail: ban.Reason}
+}
+
+func (s *Server) bans(r *http.Request, key Key) response {
+	return response{body: s.opts.Bans.List()}
+}
+
+func (s *Server) unban(r *http.Request, key Key) response {
+	subject := r.PathValue("subject")
+	removed, err := s.opts.Bans.Remove(subject)
+	if err != nil {
+		return fail(http.StatusInternalServerError, "%v", err)
+	}
+	if !removed {
+		res := fail(http.StatusNotFound, "%s is not banned", subject)
+		res.target = subject
+		return res
+	}
+	return response{status: http.StatusNoContent, target: subject}
+}
+
+type mapRequest struct {
+	Map string `json:"map"`
+}
+
+func (s *Server) changeMap(r *http.Request, key Key) response {
+	var req mapRequest
+	if err := decode(r, &req); err != nil {
+		return fail(http.StatusBadRequest, "%v", err)
+	}
+	if !identifier(req.Map) {
+		return fail(http.StatusBadRequest, "invalid map")
+	}
+	if s.opts.Maps != nil {
+		if err := s.opts.Maps.ChangeLevel(req.Map); err != nil {
+			return fail(http.StatusBadRequest, "%v", err)
+		}
+	} else {
+		s.opts.Exec("changelevel " + req.Map)
+	}
+	return response{status: http.StatusAccepted, target: req.Map}
+}
+
+type execRequest struct {
+	Command string `json:"command"`
+}
+
+func (s *Server) exec(r *http.Request, key Key) response {
+	var req execRequest
+	if err := decode(r, &req); err != nil {
+		return fail(http.StatusBadRequest, "%v", err)
+	}
+	if strings.TrimSpace(req.Command) == "" || strings.ContainsAny(req.Command, "\r\n") {
+		return fail(http.StatusBadRequest, "invalid command")
+	}
+	s.opts.Exec(req.Command)
+	return response{status: http.StatusAccepted, detail: s.redact(req.Command)}
+}
+
+// redact hides the value of a command setting a secret cvar, e.g.
+// "rcon_password foo", in the audit log.
+func (s *Server) redact(cmd string) string {
+	fields := strings.Fields(cmd)
+	if len(fields) > 1 && s.opts.Secret(fields[0]) {
+		return fields[0] + " ***"
+	}
+	return cmd
+}
+
+// CvarView is the body of the cvar endpoints.
+type CvarView struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func (s *Server) cvar(r *http.Request, key Key) response {
+	name := r.PathValue("name")
+	if s.opts.Cvar == nil {
+		return fail(http.StatusNotImplemented, "reading cvars is not available")
+	}
+	value, ok := s.opts.Cvar(name)
+	if !ok || s.opts.Secret(name) {
+		return fail(http.StatusNotFound, "unknown cvar %s", name)
+	}
+	return response{body: CvarView{Name: name, Value: value}}
+}
+
+func (s *Server) setCvar(r *http.Request, key Key) response {
+	name := r.PathValue("name")
+	var req CvarView
+	if err := decode(r, &req); err != nil {
+		return fail(http.StatusBadRequest, "%v", err)
+	}
+	if !identifier(name) || !safe(req.Value) {
+		return fail(http.StatusBadRequest, "invalid cvar")
+	}
+	s.opts.Exec(name + ` "` + req.Value + `"`)
+	// secrets stay out of the audit log
+	detail := req.Value
+	if s.opts.Secret(name) {
+		detail = "***"
+	}
+	return response{status: http.StatusAccepted, body: CvarView{Name: name, Value: req.Value}, target: name, detail: detail}
+}
+
+func (s *Server) auditEntries(r *http.Request, key Key) response {
+	return response{body: s.audit.Entries()}
+}
@@ -0,0 +1,81 @@
+// Package auth provides pluggable authentication for the signalling
+// endpoints of Go network transports (e.g. the WebRTC SFU examples).
+//
+// An Authenticator inspects the HTTP request that opens a signalling
+// session and returns the Identity of the remote peer. Identities are
+// attached to the peer's virtual Addr through a Directory, so game logic
+// can map engine clients back to application accounts.
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrNoCredentials    = errors.New("auth: no credentials")
+	ErrInvalidToken     = errors.New("auth: invalid token")
+	ErrTokenExpired     = errors.New("auth: token expired")
+	ErrOriginNotAllowed = errors.New("auth: origin not allowed")
+)
+
+// Identity describes an authenticated signalling peer.
+type Identity struct {
+	// Subject is the stable account identifier (JWT "sub").
+	Subject string
+	// Name is an optional display name.
+	Name string
+	// Claims holds every claim of the presented token.
+	Claims map[string]any
+}
+
+// Anonymous is returned by authenticators that admit a peer
+// without establishing who it is (e.g. an origin allowlist).
+var Anonymous = &Identity{}
+
+// Authenticator authenticates the HTTP request that opens a signalling session.
+// It returns the peer identity or an error if the request must be rejected.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// AuthenticatorFunc adapts an ordinary function to the Authenticator interface.
+type AuthenticatorFunc func(r *http.Request) (*Identity, error)
+
+// Authenticate calls f(r).
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*Identity, error) {
+	return f(r)
+}
+
+// All combines authenticators so that every one of them must accept the request.
+// The most specific identity (the first one that is not Anonymous) is returned.
+func All(authenticators ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (*Identity, error) {
+		identity := Anonymous
+		for _, a := range authenticators {
+			id, err := a.Authenticate(r)
+			if err != nil {
+				return nil, err
+			}
+			if identity == Anonymous && id != nil {
+				identity = id
+			}
+		}
+		return identity, nil
+	})
+}
+
+// BearerToken extracts a bearer token from the Authorization header.
+//
+// Browsers cannot set headers on WebSocket upgrades, so the "token"
+// query parameter is accepted as a fallback.
+func BearerToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		scheme, token, ok := strings.Cut(h, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return r.URL.Query().Get("token")
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// hmacAlgorithms maps supported JWT "alg" values to their hash constructors.
+var hmacAlgorithms = map[string]func() hash.Hash{
+	"HS256": sha256.New,
+	"HS384": sha512.New384,
+	"HS512": sha512.New,
+}
+
+// HMACAuthenticator validates HMAC-signed JWT bearer tokens (HS256/HS384/HS512).
+type HMACAuthenticator struct {
+	// Secret is the shared signing key.
+	Secret []byte
+	// Issuer, when set, must match the "iss" claim.
+	Issuer string
+	// Audience, when set, must be listed in the "aud" claim.
+	Audience string
+	// Leeway tolerates clock skew when checking "exp" and "nbf".
+	Leeway time.Duration
+	// Now overrides the clock, mostly useful for tests.
+	Now func() time.Time
+}
+
+// NewHMACAuthenticator creates an HMACAuthenticator for the given secret.
+func NewHMACAuthenticator(secret []byte) *HMACAuthenticator {
+	return &HMACAuthenticator{Secret: secret}
+}
+
+// Authenticate validates the bearer token of the request.
+func (a *HMACAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := BearerToken(r)
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+	return a.Verify(token)
+}
+
+// Verify checks the token signature and standard claims and returns its identity.
+func (a *HMACAuthenticator) Verify(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	newHash, ok := hmacAlgorithms[header.Alg]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	mac := hmac.New(newHash, a.Secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, ErrInvalidToken
+	}
+
+	claims := map[string]any{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now()
+	if a.Now != nil {
+		now = a.Now()
+	}
+	if exp, ok := numericClaim(claims, "exp"); ok && now.After(exp.Add(a.Leeway)) {
+		return nil, ErrTokenExpired
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(a.Leeway).Before(nbf) {
+		return nil, ErrInvalidToken
+	}
+	if a.Issuer != "" && claims["iss"] != a.Issuer {
+		return nil, ErrInvalidToken
+	}
+	if a.Audience != "" && !hasAudience(claims["aud"], a.Audience) {
+		return nil, ErrInvalidToken
+	}
+
+	identity := &Identity{Claims: claims}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Name, _ = claims["name"].(string)
+	return identity, nil
+}
+
+// Issuer mints HMAC-signed JWT tokens accepted by HMACAuthenticator.
+// It is intended for local development, tests and simple deployments
+// where the game server itself hands out tokens.
+type Issuer struct {
+	Secret    []byte
+	Algorithm string // defaults to HS256
+	Issuer    string
+	Audience  string
+}
+
+// NewIssuer creates an HS256 Issuer for the given secret.
+func NewIssuer(secret []byte) *Issuer {
+	return &Issuer{Secret: secret, Algorithm: "HS256"}
+}
+
+// Issue signs a token for the identity that expires after ttl (0 means never).
+func (i *Issuer) Issue(identity Identity, ttl time.Duration) (string, error) {
+	alg := i.Algorithm
+	if alg == "" {
+		alg = "HS256"
+	}
+	newHash, ok := hmacAlgorithms[alg]
+	if !ok {
+		return "", ErrInvalidToken
+	}
+
+	claims := map[string]any{}
+	for k, v := range identity.Claims {
+		claims[k] = v
+	}
+	now := time.Now()
+	claims["iat"] = now.Unix()
+	if ttl > 0 {
+		claims["exp"] = now.Add(ttl).Unix()
+	}
+	if identity.Subject != "" {
+		claims["sub"] = identity.Subject
+	}
+	if identity.Name != "" {
+		claims["name"] = identity.Name
+	}
+	if i.Issuer != "" {
+		claims["iss"] = i.Issuer
+	}
+	if i.Audience != "" {
+		claims["aud"] = i.Audience
+	}
+
+	header, err := encodeSegment(map[string]string{"alg": alg, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := encodeSegment(claims)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(newHash, i.Secret)
+	mac.Write([]byte(header + "." + payload))
+	return header + "." + payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func decodeSegment(segment string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func encodeSegment(v any) (string, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// numericClaim reads a NumericDate claim (seconds since epoch).
+func numericClaim(claims map[string]any, name string) (time.Time, bool) {
+	v, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+// hasAudience reports whether the "aud" claim (string or array) contains audience.
+func hasAudience(aud any, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []any:
+		for _, item := range v {
+			if item == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// forge builds a token from raw JSON segments. It is signed with the
+// secret and algorithm of sign, or carries signature when sign is nil.
+func forge(t *testing.T, header, claims string, sign *Issuer, signature string) string {
+	t.Helper()
+	h := base64.RawURLEncoding.EncodeToString([]byte(header))
+	c := base64.RawURLEncoding.EncodeToString([]byte(claims))
+	if sign == nil {
+		return h + "." + c + "." + signature
+	}
+	newHash, ok := hmacAlgorithms[sign.Algorithm]
+	if !ok {
+		t.Fatalf("unknown algorithm %q", sign.Algorithm)
+	}
+	mac := hmac.New(newHash, sign.Secret)
+	mac.Write([]byte(h + "." + c))
+	return h + "." + c + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestHMACVerify(t *testing.T) {
+	secret := []byte("local-secret")
+	now := time.Unix(1_760_000_000, 0)
+	issue := func(i *Issuer, identity Identity, ttl time.Duration) string {
+		t.Helper()
+		token, err := i.Issue(identity, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	hs256 := NewIssuer(secret)
+	claims := func(s string) string { return `{"sub":"p1",` + s + `}` }
+	exp := func(d time.Duration) string { return `"exp":` + strconv.FormatInt(now.Add(d).Unix(), 10) }
+	nbf := func(d time.Duration) string { return `"nbf":` + strconv.FormatInt(now.Add(d).Unix(), 10) }
+
+	tests := []struct {
+		name     string
+		verifier HMACAuthenticator
+		token    string
+		err      error
+		subject  string
+	}{
+		{
+			name:    "issued token",
+			token:   issue(hs256, Identity{Subject: "p1", Name: "Gordon"}, 0),
+			subject: "p1",
+		},
+		{
+			name:    "HS512 issuer",
+			token:   issue(&Issuer{Secret: secret, Algorithm: "HS512"}, Identity{Subject: "p1"}, 0),
+			subject: "p1",
+		},
+		{
+			name:  "other secret",
+			token: issue(NewIssuer([]byte("other")), Identity{Subject: "p1"}, 0),
+			err:   ErrInvalidToken,
+		},
+		{
+			name:  "alg none",
+			token: forge(t, `{"alg":"none","typ":"JWT"}`, claims(`"admin":true`), nil, ""),
+			err:   ErrInvalidToken,
+		},
+		{
+			name:  "alg RS256",
+			token: forge(t, `{"alg":"RS256"}`, claims(`"admin":true`), nil, strings.Repeat("A", 43)),
+			err:   ErrInvalidToken,
+		},
+		{
+			name: "alg in lower case",
+			token: func() string {
+				parts := strings.Split(forge(t, `{"alg":"HS256"}`, claims(`"x":1`), hs256, ""), ".")
+				parts[0] = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"hs256"}`))
+				return strings.Join(parts, ".")
+			}(),
+			err: ErrInvalidToken,
+		},
+		{
+			name: "alg switched after signing",
+			token: func() string {
+				parts := strings.Split(forge(t, `{"alg":"HS256"}`, claims(`"x":1`), hs256, ""), ".")
+				parts[0] = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS384"}`))
+				return strings.Join(parts, ".")
+			}(),
+			err: ErrInvalidToken,
+		},
+		{
+			name: "tampered claims",
+			token: func() string {
+				parts := strings.Split(issue(hs256, Identity{Subject: "p1"}, 0), ".")
+				parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`))
+				return strings.Join(parts, ".")
+			}(),
+			err: ErrInvalidToken,
+		},
+		{name: "two segments", token: "a.b", err: ErrInvalidToken},
+		{name: "garbage", token: "%%%.%%%.%%%", err: ErrInvalidToken},
+		{
+			name:  "expired",
+			token: forge(t, `{"alg":"HS256"}`, claims(exp(-time.Minute)), hs256, ""),
+			err:   ErrTokenExpired,
+		},
+		{
+			name:     "expired within leeway",
+			verifier: HMACAuthenticator{Leeway: 2 * time.Minute},
+			token:    forge(t, `{"alg":"HS256"}`, claims(exp(-time.Minute)), hs256, ""),
+			subject:  "p1",
+		},
+		{
+			name:    "not expired",
+			token:   forge(t, `{"alg":"HS256"}`, claims(exp(time.Minute)), hs256, ""),
+			subject: "p1",
+		},
+		{
+			name:  "not yet valid",
+			token: forge(t, `{"alg":"HS256"}`, claims(nbf(time.Minute)), hs256, ""),
+			err:   ErrInvalidToken,
+		},
+		{
+			name:     "not yet valid within leeway",
+			verifier: HMACAuthenticator{Leeway: 2 * time.Minute},
+			token:    forge(t, `{"alg":"HS256"}`, claims(nbf(time.Minute)), hs256, ""),
+			subject:  "p1",
+		},
+		{
+			name:     "issuer matches",
+			verifier: HMACAuthenticator{Issuer: "lobby"},
+			token:    issue(&Issuer{Secret: secret, Issuer: "lobby"}, Identity{Subject: "p1"}, 0),
+			subject:  "p1",
+		},
+		{
+			name:     "issuer differs",
+			verifier: HMACAuthenticator{Issuer: "lobby"},
+			token:    issue(&Issuer{Secret: secret, Issuer: "elsewhere"}, Identity{Subject: "p1"}, 0),
+			err:      ErrInvalidToken,
+		},
+		{
+			name:     "issuer missing",
+			verifier: HMACAuthenticator{Issuer: "lobby"},
+			token:    issue(hs256, Identity{Subject: "p1"}, 0),
+			err:      ErrInvalidToken,
+		},
+		{
+			name:     "audience string",
+			verifier: HMACAuthenticator{Audience: "xash"},
+			token:    issue(&Issuer{Secret: secret, Audience: "xash"}, Identity{Subject: "p1"}, 0),
+			subject:  "p1",
+		},
+		{
+			name:     "audience list",
+			verifier: HMACAuthenticator{Audience: "xash"},
+			token:    forge(t, `{"alg":"HS256"}`, claims(`"aud":["web","xash"]`), hs256, ""),
+			subject:  "p1",
+		},
+		{
+			name:     "audience differs",
+			verifier: HMACAuthenticator{Audience: "xash"},
+			token:    forge(t, `{"alg":"HS256"}`, claims(`"aud":["web"]`), hs256, ""),
+			err:      ErrInvalidToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := tt.verifier
+			v.Secret = secret
+			if v.Now == nil {
+				v.Now = func() time.Time { return now }
+			}
+			identity, err := v.Verify(tt.token)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err == nil && identity.Subject != tt.subject {
+				t.Fatalf("subject = %q, want %q", identity.Subject, tt.subject)
+			}
+		})
+	}
+}
+
+func TestHMACAuthenticate(t *testing.T) {
+	a := NewHMACAuthenticator([]byte("local-secret"))
+	token, err := NewIssuer([]byte("local-secret")).Issue(Identity{Subject: "p1", Name: "Gordon"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/websocket", nil)
+	if _, err := a.Authenticate(r); !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("no token: err = %v", err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	identity, err := a.Authenticate(r)
+	if err != nil || identity.Name != "Gordon" {
+		t.Fatalf("bearer token: %+v, %v", identity, err)
+	}
+}
+
+func TestOrigins(t *testing.T) {
+	origins := ParseOrigins(" https://play.example.com/ , *.games.test,")
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"https://play.example.com", true},
+		{"HTTPS://PLAY.EXAMPLE.COM", true},
+		{"http://play.example.com", false},
+		{"https://play.example.com.evil.test", false},
+		{"https://a.games.test", true},
+		{"https://a.b.games.test:8443", true},
+		{"https://games.test", false},
+		{"https://evilgames.test", false},
+		{"https://games.test.evil", false},
+		{"null", false},
+		{"://bad", false},
+	}
+	for _, tt := range tests {
+		if got := origins.Allowed(tt.origin); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+	if !(Origins{"*"}).Allowed("https://anything.test") {
+		t.Error("* must allow every origin")
+	}
+
+	r := httptest.NewRequest("GET", "/websocket", nil)
+	r.Header.Set("Origin", "https://evil.test")
+	if _, err := origins.Authenticate(r); !errors.Is(err, ErrOriginNotAllowed) {
+		t.Fatalf("Authenticate: err = %v", err)
+	}
+}
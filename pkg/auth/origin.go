@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Origins is an allowlist of request origins.
+//
+// Entries are matched case-insensitively against the Origin header, either
+// as a full origin ("https://play.example.com") or as a host suffix wildcard
+// ("*.example.com"). A single "*" allows every origin.
+type Origins []string
+
+// ParseOrigins splits a comma separated allowlist (e.g. from an env variable).
+func ParseOrigins(s string) Origins {
+	var origins Origins
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			origins = append(origins, item)
+		}
+	}
+	return origins
+}
+
+// Allowed reports whether the origin is on the allowlist.
+// Requests without an Origin header (non-browser clients) are allowed.
+func (o Origins) Allowed(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	for _, entry := range o {
+		switch {
+		case entry == "*":
+			return true
+		case strings.HasPrefix(entry, "*."):
+			host := strings.ToLower(u.Hostname())
+			if strings.HasSuffix(host, strings.ToLower(entry[1:])) {
+				return true
+			}
+		case strings.EqualFold(strings.TrimSuffix(entry, "/"), origin):
+			return true
+		}
+	}
+	return false
+}
+
+// CheckOrigin can be used as websocket.Upgrader.CheckOrigin.
+func (o Origins) CheckOrigin(r *http.Request) bool {
+	return o.Allowed(r.Header.Get("Origin"))
+}
+
+// Authenticate admits the request anonymously if its origin is allowed.
+func (o Origins) Authenticate(r *http.Request) (*Identity, error) {
+	if !o.CheckOrigin(r) {
+		return nil, ErrOriginNotAllowed
+	}
+	return Anonymous, nil
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/yohimik/goxash3d-fwgs/pkg/platform"
 	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 // BaseNetOptions holds configuration for the BaseNet instance.
@@ -28,6 +29,7 @@ type BaseNet struct {
 	lastSocketID int
 	sockets      map[int]*NetSocket
 	packets      *PacketQueue
+	dropped      atomic.Uint64
 	Options      BaseNetOptions
 }
 
@@ -66,8 +68,21 @@ func (n *BaseNet) CloseSocket(fd int) int {
 }
 
 // PushPacket adds a packet to the internal packet queue.
+// Packets that do not fit into a full queue are dropped and counted.
 func (n *BaseNet) PushPacket(packet Packet) {
-	n.packets.Enqueue(packet)
+	if err := n.packets.Enqueue(packet); err != nil {
+		n.dropped.Add(1)
+	}
+}
+
+// QueueLen returns the approximate number of packets waiting for RecvFrom.
+func (n *BaseNet) QueueLen() int {
+	return n.packets.Len()
+}
+
+// Dropped returns the number of packets dropped because the queue was full.
+func (n *BaseNet) Dropped() uint64 {
+	return n.dropped.Load()
 }
 
 // RecvFrom attempts to retrieve a packet from the queue.
@@ -0,0 +1,349 @@
+// Package capture records the packets crossing the Go network bridge into
+// pcapng files and replays them back into the engine.
+//
+// Packets are wrapped into synthetic IPv4/UDP headers between the peer's
+// virtual address and the server address, so captures open in Wireshark
+// with its GoldSrc/Xash dissectors.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotPcapng       = errors.New("capture: not a pcapng file")
+	ErrUnsupportedLink = errors.New("capture: unsupported link type")
+)
+
+// Direction tells whether a packet was received or sent by the server.
+type Direction uint8
+
+const (
+	// Inbound packets travel from a peer to the server (RecvFrom).
+	Inbound Direction = 1
+	// Outbound packets travel from the server to a peer (SendTo).
+	Outbound Direction = 2
+)
+
+// Record is a single captured packet.
+type Record struct {
+	Time      time.Time
+	Direction Direction
+	// Packet holds the payload and the peer address.
+	Packet goxash3d_fwgs.Packet
+	// Server is the server side address of the packet.
+	Server goxash3d_fwgs.Addr
+}
+
+const (
+	blockSHB = 0x0A0D0D0A
+	blockIDB = 0x00000001
+	blockEPB = 0x00000006
+
+	byteOrderMagic = 0x1A2B3C4D
+
+	// linkTypeRaw is LINKTYPE_RAW: packets start with an IPv4 header.
+	linkTypeRaw  = 101
+	linkTypeIPv4 = 228
+
+	optEnd      = 0
+	optTSResol  = 9
+	optEPBFlags = 2
+
+	ipHeaderLen  = 20
+	udpHeaderLen = 8
+)
+
+// Writer writes packets as a pcapng stream.
+type Writer struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	buf []byte
+}
+
+// NewWriter writes the section and interface headers and returns a Writer.
+func NewWriter(w io.Writer) (*Writer, error) {
+	pw := &Writer{w: bufio.NewWriter(w)}
+
+	// section header: magic, version 1.0, unknown section length
+	shb := binary.LittleEndian.AppendUint32(nil, byteOrderMagic)
+	shb = binary.LittleEndian.AppendUint16(shb, 1)
+	shb = binary.LittleEndian.AppendUint16(shb, 0)
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0))
+	if err := pw.writeBlock(blockSHB, shb); err != nil {
+		return nil, err
+	}
+
+	// interface description: raw IPv4, nanosecond timestamps
+	idb := binary.LittleEndian.AppendUint16(nil, linkTypeRaw)
+	idb = binary.LittleEndian.AppendUint16(idb, 0)
+	idb = binary.LittleEndian.AppendUint32(idb, 0)
+	idb = appendOption(idb, optTSResol, []byte{9})
+	idb = appendOption(idb, optEnd, nil)
+	if err := pw.writeBlock(blockIDB, idb); err != nil {
+		return nil, err
+	}
+	return pw, pw.w.Flush()
+}
+
+// Write appends a record to the capture.
+func (pw *Writer) Write(rec Record) error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	src, dst := rec.Packet.Addr, rec.Server
+	if rec.Direction == Outbound {
+		src, dst = dst, src
+	}
+	frame := appendIPv4UDP(pw.buf[:0], src, dst, rec.Packet.Data)
+	pw.buf = frame
+
+	ts := uint64(rec.Time.UnixNano())
+	epb := binary.LittleEndian.AppendUint32(nil, 0)
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts>>32))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(frame)))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(frame)))
+	epb = append(epb, frame...)
+	epb = append(epb, make([]byte, pad4(len(frame)))...)
+	epb = appendOption(epb, optEPBFlags, binary.LittleEndian.AppendUint32(nil, uint32(rec.Direction)))
+	epb = appendOption(epb, optEnd, nil)
+	return pw.writeBlock(blockEPB, epb)
+}
+
+// Flush writes buffered records to the underlying writer.
+func (pw *Writer) Flush() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	return pw.w.Flush()
+}
+
+func (pw *Writer) writeBlock(typ uint32, body []byte) error {
+	total := uint32(12 + len(body))
+	hdr := binary.LittleEndian.AppendUint32(nil, typ)
+	hdr = binary.LittleEndian.AppendUint32(hdr, total)
+	if _, err := pw.w.Write(hdr); err != nil {
+		return err
+	}
+	if _, err := pw.w.Write(body); err != nil {
+		return err
+	}
+	_, err := pw.w.Write(binary.LittleEndian.AppendUint32(nil, total))
+	return err
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return append(b, make([]byte, pad4(len(value)))...)
+}
+
+func pad4(n int) int {
+	return (4 - n%4) % 4
+}
+
+// appendIPv4UDP wraps the payload into synthetic IPv4 and UDP headers.
+func appendIPv4UDP(b []byte, src, dst goxash3d_fwgs.Addr, payload []byte) []byte {
+	total := ipHeaderLen + udpHeaderLen + len(payload)
+	ip := make([]byte, ipHeaderLen)
+	ip[0] = 0x45 // IPv4, 5 words header
+	binary.BigEndian.PutUint16(ip[2:], uint16(total))
+	binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+	ip[8] = 64                                 // ttl
+	ip[9] = 17                                 // udp
+	copy(ip[12:16], src.IP[:])
+	copy(ip[16:20], dst.IP[:])
+	binary.BigEndian.PutUint16(ip[10:], ipChecksum(ip))
+
+	b = append(b, ip...)
+	b = binary.BigEndian.AppendUint16(b, src.Port)
+	b = binary.BigEndian.AppendUint16(b, dst.Port)
+	b = binary.BigEndian.AppendUint16(b, uint16(udpHeaderLen+len(payload)))
+	b = binary.BigEndian.AppendUint16(b, 0) // checksum is optional over IPv4
+	return append(b, payload...)
+}
+
+func ipChecksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+// Reader reads records from a pcapng stream written by Writer
+// (or any capture of raw IPv4 UDP packets).
+type Reader struct {
+	r        *bufio.Reader
+	order    binary.ByteOrder
+	tsResol  []time.Duration
+	linkType []uint16
+}
+
+// NewReader reads the section header and returns a Reader.
+func NewReader(r io.Reader) (*Reader, error) {
+	pr := &Reader{r: bufio.NewReader(r), order: binary.LittleEndian}
+	typ, body, err := pr.readBlock()
+	if err != nil {
+		return nil, err
+	}
+	if typ != blockSHB {
+		return nil, ErrNotPcapng
+	}
+	pr.readSectionHeader(body)
+	return pr, nil
+}
+
+// Next returns the next captured UDP packet or io.EOF.
+func (pr *Reader) Next() (Record, error) {
+	for {
+		typ, body, err := pr.readBlock()
+		if err != nil {
+			return Record{}, err
+		}
+		switch typ {
+		case blockSHB:
+			pr.readSectionHeader(body)
+		case blockIDB:
+			if len(body) < 8 {
+				return Record{}, io.ErrUnexpectedEOF
+			}
+			pr.linkType = append(pr.linkType, pr.order.Uint16(body[0:]))
+			resol := time.Microsecond
+			pr.walkOptions(body[8:], func(code uint16, value []byte) {
+				if code == optTSResol && len(value) == 1 && value[0]&0x80 == 0 {
+					resol = time.Duration(1)
+					for i := value[0]; i < 9; i++ {
+						resol *= 10
+					}
+				}
+			})
+			pr.tsResol = append(pr.tsResol, resol)
+		case blockEPB:
+			rec, ok, err := pr.readPacket(body)
+			if err != nil {
+				return Record{}, err
+			}
+			if ok {
+				return rec, nil
+			}
+		}
+	}
+}
+
+func (pr *Reader) readSectionHeader(body []byte) {
+	if len(body) >= 4 && binary.BigEndian.Uint32(body) == byteOrderMagic {
+		pr.order = binary.BigEndian
+	} else {
+		pr.order = binary.LittleEndian
+	}
+	pr.tsResol = pr.tsResol[:0]
+	pr.linkType = pr.linkType[:0]
+}
+
+func (pr *Reader) readBlock() (uint32, []byte, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(pr.r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	order := pr.order
+	if binary.LittleEndian.Uint32(hdr[:]) == blockSHB {
+		// the section header is palindromic, its byte order follows the magic
+		peek, err := pr.r.Peek(4)
+		if err != nil {
+			return 0, nil, err
+		}
+		if binary.BigEndian.Uint32(peek) == byteOrderMagic {
+			order = binary.BigEndian
+		} else {
+			order = binary.LittleEndian
+		}
+	}
+	typ := order.Uint32(hdr[0:])
+	total := order.Uint32(hdr[4:])
+	if total < 12 || total%4 != 0 {
+		return 0, nil, ErrNotPcapng
+	}
+	body := make([]byte, total-8)
+	if _, err := io.ReadFull(pr.r, body); err != nil {
+		return 0, nil, err
+	}
+	return typ, body[:len(body)-4], nil
+}
+
+func (pr *Reader) walkOptions(b []byte, fn func(code uint16, value []byte)) {
+	for len(b) >= 4 {
+		code := pr.order.Uint16(b[0:])
+		length := int(pr.order.Uint16(b[2:]))
+		if code == optEnd || 4+length > len(b) {
+			return
+		}
+		fn(code, b[4:4+length])
+		b = b[4+length+pad4(length):]
+	}
+}
+
+func (pr *Reader) readPacket(body []byte) (Record, bool, error) {
+	if len(body) < 20 {
+		return Record{}, false, io.ErrUnexpectedEOF
+	}
+	iface := int(pr.order.Uint32(body[0:]))
+	if iface >= len(pr.linkType) {
+		return Record{}, false, ErrNotPcapng
+	}
+	if lt := pr.linkType[iface]; lt != linkTypeRaw && lt != linkTypeIPv4 {
+		return Record{}, false, ErrUnsupportedLink
+	}
+	ts := uint64(pr.order.Uint32(body[4:]))<<32 | uint64(pr.order.Uint32(body[8:]))
+	captured := int(pr.order.Uint32(body[12:]))
+	if 20+captured > len(body) {
+		return Record{}, false, io.ErrUnexpectedEOF
+	}
+	frame := body[20 : 20+captured]
+
+	var direction Direction
+	pr.walkOptions(body[20+captured+pad4(captured):], func(code uint16, value []byte) {
+		if code == optEPBFlags && len(value) == 4 {
+			direction = Direction(pr.order.Uint32(value) & 3)
+		}
+	})
+
+	if len(frame) < ipHeaderLen || frame[0]>>4 != 4 {
+		return Record{}, false, nil
+	}
+	ihl := int(frame[0]&0x0f) * 4
+	if frame[9] != 17 || len(frame) < ihl+udpHeaderLen {
+		return Record{}, false, nil
+	}
+	var src, dst goxash3d_fwgs.Addr
+	copy(src.IP[:], frame[12:16])
+	copy(dst.IP[:], frame[16:20])
+	udp := frame[ihl:]
+	src.Port = binary.BigEndian.Uint16(udp[0:])
+	dst.Port = binary.BigEndian.Uint16(udp[2:])
+	payload := append([]byte(nil), udp[udpHeaderLen:]...)
+
+	rec := Record{
+		Time:      time.Unix(0, int64(ts)*int64(pr.tsResol[iface])),
+		Direction: direction,
+	}
+	if direction == Outbound {
+		rec.Packet = goxash3d_fwgs.Packet{Addr: dst, Data: payload}
+		rec.Server = src
+	} else {
+		rec.Direction = Inbound
+		rec.Packet = goxash3d_fwgs.Packet{Addr: src, Data: payload}
+		rec.Server = dst
+	}
+	return rec, true, nil
+}
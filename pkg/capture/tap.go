@@ -0,0 +1,159 @@
+package capture
+
+import (
+	"context"
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultServer is used as the server side address until the engine binds its socket.
+var DefaultServer = goxash3d_fwgs.Addr{IP: [4]byte{127, 0, 0, 1}, Port: 27015}
+
+// Tap wraps a Xash3DNetwork and records every packet crossing it.
+type Tap struct {
+	goxash3d_fwgs.Xash3DNetwork
+
+	mu     sync.Mutex
+	w      *Writer
+	server goxash3d_fwgs.Addr
+	errors int
+}
+
+// NewTap wraps the network. Recording starts with Start.
+func NewTap(net goxash3d_fwgs.Xash3DNetwork) *Tap {
+	return &Tap{
+		Xash3DNetwork: net,
+		server:        DefaultServer,
+	}
+}
+
+// Start records into w, replacing the previous writer.
+func (t *Tap) Start(w *Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.w = w
+}
+
+// Stop stops recording and flushes the writer.
+func (t *Tap) Stop() error {
+	t.mu.Lock()
+	w := t.w
+	t.w = nil
+	t.mu.Unlock()
+	if w == nil {
+		return nil
+	}
+	return w.Flush()
+}
+
+// Errors returns how many records could not be written.
+func (t *Tap) Errors() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.errors
+}
+
+func (t *Tap) record(direction Direction, pkt goxash3d_fwgs.Packet) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.w == nil {
+		return
+	}
+	err := t.w.Write(Record{
+		Time:      time.Now(),
+		Direction: direction,
+		Packet:    pkt,
+		Server:    t.server,
+	})
+	if err != nil {
+		t.errors++
+	}
+}
+
+// Bind binds the socket and remembers its address as the server address.
+func (t *Tap) Bind(fd int, addr goxash3d_fwgs.Addr) int {
+	ret := t.Xash3DNetwork.Bind(fd, addr)
+	if ret == 0 {
+		t.mu.Lock()
+		if addr.IP == [4]byte{} {
+			addr.IP = DefaultServer.IP
+		}
+		t.server = addr
+		t.mu.Unlock()
+	}
+	return ret
+}
+
+// RecvFrom receives a packet from the wrapped network and records it.
+func (t *Tap) RecvFrom() *goxash3d_fwgs.Packet {
+	pkt := t.Xash3DNetwork.RecvFrom()
+	if pkt != nil {
+		t.record(Inbound, *pkt)
+	}
+	return pkt
+}
+
+// SendTo records the packet and sends it through the wrapped network.
+func (t *Tap) SendTo(fd int, pkt goxash3d_fwgs.Packet, flags int) int {
+	t.record(Outbound, pkt)
+	return t.Xash3DNetwork.SendTo(fd, pkt, flags)
+}
+
+// SendToBatch records the packets and sends them through the wrapped network.
+func (t *Tap) SendToBatch(fd int, packets []goxash3d_fwgs.Packet, flags int) int {
+	for _, pkt := range packets {
+		t.record(Outbound, pkt)
+	}
+	return t.Xash3DNetwork.SendToBatch(fd, packets, flags)
+}
+
+// ReplayOptions configures Replay.
+type ReplayOptions struct {
+	// Speed scales the original timing, 2 replays twice as fast.
+	// Zero or negative pushes packets as fast as possible.
+	Speed float64
+	// Filter selects inbound records to replay, nil replays all of them.
+	Filter func(Record) bool
+}
+
+// Replay feeds the inbound packets of a capture back through PushPacket,
+// preserving their relative timing. It returns the number of packets pushed.
+func Replay(ctx context.Context, r *Reader, pusher goxash3d_fwgs.PacketPusher, opts ReplayOptions) (int, error) {
+	var first time.Time
+	start := time.Now()
+	count := 0
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if rec.Direction != Inbound || (opts.Filter != nil && !opts.Filter(rec)) {
+			continue
+		}
+
+		if opts.Speed > 0 {
+			if first.IsZero() {
+				first = rec.Time
+			}
+			due := start.Add(time.Duration(float64(rec.Time.Sub(first)) / opts.Speed))
+			if wait := time.Until(due); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return count, ctx.Err()
+				case <-time.After(wait):
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+
+		pusher.PushPacket(rec.Packet)
+		count++
+	}
+}
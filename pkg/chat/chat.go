@@ -0,0 +1,151 @@
+// Package chat inspects say and say_team before they reach other players.
+//
+// A Pipeline runs a chain of handlers over every chat message. A handler
+// may rewrite the text, suppress the message, answer the sender privately
+// or let the message through at once. The package ships handlers for
+// profanity masking, flood throttling, mutes and "!command" chat commands:
+//
+//	mutes := chat.NewMutes()
+//	commands := chat.NewCommands("!")
+//	commands.Handle("rank", func(m *chat.Message, args []string) { m.Reply("rank 1") })
+//	p := chat.New(mutes.Handler(), chat.Flood(3, time.Second), commands.Handler(), chat.Profanity("darn"))
+//	p.Install(goxash3d_fwgs.DefaultXash3D)
+//
+// Handlers run on the engine thread while it processes the command, so
+// they must return quickly.
+package chat
+
+import (
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"github.com/yohimik/goxash3d-fwgs/pkg/netchan"
+	"strings"
+	"sync"
+)
+
+// Action is the decision of a handler.
+type Action uint8
+
+const (
+	// Continue passes the message to the next handler.
+	Continue Action = iota
+	// Allow delivers the message without running the later handlers.
+	Allow
+	// Suppress drops the message.
+	Suppress
+)
+
+// Message is a chat message on its way through the pipeline.
+type Message struct {
+	Client goxash3d_fwgs.Client
+	// Team is set for say_team.
+	Team bool
+	// Text is the message, handlers may change it.
+	Text string
+
+	cmd *goxash3d_fwgs.ClientCommand
+}
+
+// Reply prints a message to the sender only.
+func (m *Message) Reply(format string, args ...any) {
+	if m.cmd != nil {
+		m.cmd.Reply(format, args...)
+	}
+}
+
+// Handler inspects a message.
+type Handler func(m *Message) Action
+
+// Pipeline is a chain of handlers.
+type Pipeline struct {
+	mu       sync.RWMutex
+	handlers []Handler
+}
+
+// New creates a pipeline running the handlers in order.
+func New(handlers ...Handler) *Pipeline {
+	return &Pipeline{handlers: handlers}
+}
+
+// Use appends handlers to the chain.
+func (p *Pipeline) Use(handlers ...Handler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers = append(p.handlers, handlers...)
+}
+
+// Process runs the chain over a message. A message left without text is
+// suppressed.
+func (p *Pipeline) Process(m *Message) Action {
+	p.mu.RLock()
+	handlers := p.handlers
+	p.mu.RUnlock()
+	for _, h := range handlers {
+		switch h(m) {
+		case Allow:
+			return Allow
+		case Suppress:
+			return Suppress
+		}
+	}
+	if strings.TrimSpace(m.Text) == "" {
+		return Suppress
+	}
+	return Continue
+}
+
+// Install feeds the say and say_team commands of clients into the pipeline
+// and returns a function removing it.
+func (p *Pipeline) Install(x *goxash3d_fwgs.Xash3D) (remove func()) {
+	return x.FilterClientCommands(p.filter)
+}
+
+func (p *Pipeline) filter(cmd *goxash3d_fwgs.ClientCommand) {
+	m, ok := Parse(cmd.Line)
+	if !ok {
+		return
+	}
+	m.Client = cmd.Client
+	m.cmd = cmd
+	text := m.Text
+	if p.Process(m) == Suppress {
+		cmd.Drop = true
+		return
+	}
+	if m.Text != text {
+		cmd.Line = m.Command()
+	}
+}
+
+// Parse reads a say or say_team command line. The text is taken like the
+// engine does: the rest of the line, without the quotes around it.
+func Parse(line string) (*Message, bool) {
+	line = strings.TrimSpace(line)
+	args := netchan.Tokenize(line)
+	if len(args) == 0 {
+		return nil, false
+	}
+	m := &Message{}
+	switch strings.ToLower(args[0]) {
+	case "say":
+	case "say_team":
+		m.Team = true
+	default:
+		return nil, false
+	}
+	text := strings.TrimSpace(line[len(args[0]):])
+	if len(text) >= 2 && text[0] == '"' && text[len(text)-1] == '"' {
+		text = text[1 : len(text)-1]
+	}
+	m.Text = text
+	return m, true
+}
+
+// Command returns the message as a command line. Quotes in the text are
+// replaced, the engine cannot escape them.
+func (m *Message) Command() string {
+	name := "say"
+	if m.Team {
+		name = "say_team"
+	}
+	return name + ` "` + strings.ReplaceAll(m.Text, `"`, "'") + `"`
+}
@@ -0,0 +1,217 @@
+package chat
+
+import (
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Profanity masks the words with asterisks, ignoring case. Words only
+// match as a whole, "class" is left alone when "ass" is listed.
+func Profanity(words ...string) Handler {
+	var quoted []string
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w != "" {
+			quoted = append(quoted, regexp.QuoteMeta(w))
+		}
+	}
+	if len(quoted) == 0 {
+		return func(*Message) Action { return Continue }
+	}
+	re := regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	return func(m *Message) Action {
+		m.Text = re.ReplaceAllStringFunc(m.Text, func(w string) string {
+			return strings.Repeat("*", utf8.RuneCountInString(w))
+		})
+		return Continue
+	}
+}
+
+// floodIdle is how long a sender is remembered by Flood after its bucket refilled.
+const floodIdle = time.Minute
+
+// Flood lets every sender post burst messages at once and one more per
+// interval after that. Messages over the limit are suppressed.
+func Flood(burst int, interval time.Duration) Handler {
+	type bucket struct {
+		tokens float64
+		last   time.Time
+	}
+	var (
+		mu      sync.Mutex
+		buckets = map[goxash3d_fwgs.Addr]*bucket{}
+		pruned  time.Time
+	)
+	return func(m *Message) Action {
+		now := time.Now()
+		mu.Lock()
+		defer mu.Unlock()
+
+		if now.Sub(pruned) > floodIdle {
+			for addr, b := range buckets {
+				if now.Sub(b.last) > floodIdle+time.Duration(burst)*interval {
+					delete(buckets, addr)
+				}
+			}
+			pruned = now
+		}
+
+		b, ok := buckets[m.Client.Addr]
+		if !ok {
+			b = &bucket{tokens: float64(burst), last: now}
+			buckets[m.Client.Addr] = b
+		}
+		b.tokens = min(float64(burst), b.tokens+float64(now.Sub(b.last))/float64(interval))
+		b.last = now
+		if b.tokens < 1 {
+			m.Reply("You are sending messages too fast.")
+			return Suppress
+		}
+		b.tokens--
+		return Continue
+	}
+}
+
+// Mute is a muted sender.
+type Mute struct {
+	Addr goxash3d_fwgs.Addr `json:"addr"`
+	// Until is when the mute ends, zero for a permanent mute.
+	Until  time.Time `json:"until,omitzero"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Mutes is the list of muted senders by client address.
+type Mutes struct {
+	mu    sync.RWMutex
+	mutes map[goxash3d_fwgs.Addr]Mute
+}
+
+// NewMutes creates an empty mute list.
+func NewMutes() *Mutes {
+	return &Mutes{mutes: make(map[goxash3d_fwgs.Addr]Mute)}
+}
+
+// Mute silences the client at addr for d, or until Unmute when d is 0.
+func (m *Mutes) Mute(addr goxash3d_fwgs.Addr, d time.Duration, reason string) {
+	mute := Mute{Addr: addr, Reason: reason}
+	if d > 0 {
+		mute.Until = time.Now().Add(d)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mutes[addr] = mute
+}
+
+// Unmute lifts the mute of addr and reports whether there was one.
+func (m *Mutes) Unmute(addr goxash3d_fwgs.Addr) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.mutes[addr]
+	delete(m.mutes, addr)
+	return ok
+}
+
+// Lookup returns the active mute of addr.
+func (m *Mutes) Lookup(addr goxash3d_fwgs.Addr) (Mute, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mute, ok := m.mutes[addr]
+	if ok && !mute.Until.IsZero() && time.Now().After(mute.Until) {
+		delete(m.mutes, addr)
+		return Mute{}, false
+	}
+	return mute, ok
+}
+
+// List returns the mutes ordered by address.
+func (m *Mutes) List() []Mute {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	out := make([]Mute, 0, len(m.mutes))
+	for _, mute := range m.mutes {
+		if mute.Until.IsZero() || now.Before(mute.Until) {
+			out = append(out, mute)
+		}
+	}
+	slices.SortFunc(out, func(a, b Mute) int { return strings.Compare(a.Addr.String(), b.Addr.String()) })
+	return out
+}
+
+// Handler suppresses the messages of muted senders.
+func (m *Mutes) Handler() Handler {
+	return func(msg *Message) Action {
+		mute, ok := m.Lookup(msg.Client.Addr)
+		if !ok {
+			return Continue
+		}
+		if mute.Until.IsZero() {
+			msg.Reply("You are muted.")
+		} else {
+			msg.Reply("You are muted for %s.", time.Until(mute.Until).Round(time.Second))
+		}
+		return Suppress
+	}
+}
+
+// CommandFunc runs a chat command with the words following its name.
+type CommandFunc func(m *Message, args []string)
+
+// Commands handles chat messages starting with a prefix, e.g. "!rank".
+type Commands struct {
+	prefix string
+
+	mu       sync.RWMutex
+	commands map[string]CommandFunc
+}
+
+// NewCommands creates the chat commands of the prefix.
+func NewCommands(prefix string) *Commands {
+	return &Commands{prefix: prefix, commands: make(map[string]CommandFunc)}
+}
+
+// Handle registers a command by name, without the prefix. Names ignore case.
+func (c *Commands) Handle(name string, fn CommandFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.commands[strings.ToLower(name)] = fn
+}
+
+// Names returns the registered command names in order.
+func (c *Commands) Names() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	names := make([]string, 0, len(c.commands))
+	for name := range c.commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Handler runs known commands and suppresses them, so only the sender
+// sees the answer. Other messages pass.
+func (c *Commands) Handler() Handler {
+	return func(m *Message) Action {
+		text, ok := strings.CutPrefix(strings.TrimSpace(m.Text), c.prefix)
+		if !ok {
+			return Continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			return Continue
+		}
+		c.mu.RLock()
+		fn, ok := c.commands[strings.ToLower(fields[0])]
+		c.mu.RUnlock()
+		if !ok {
+			return Continue
+		}
+		fn(m, fields[1:])
+		return Suppress
+	}
+}
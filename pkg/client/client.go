@@ -0,0 +1,459 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/yohimik/goxash3d-fwgs/pkg/netchan"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrHandshakeTimeout = errors.New("client: handshake timed out")
+	ErrClosed           = errors.New("client: closed")
+)
+
+// RejectedError is returned when the server refuses the connection.
+type RejectedError struct {
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return "client: connection rejected: " + e.Reason
+}
+
+// DisconnectedError is returned by Run when the server drops the client.
+type DisconnectedError struct {
+	Reason string
+}
+
+func (e *DisconnectedError) Error() string {
+	return "client: disconnected: " + e.Reason
+}
+
+// resendInterval is the retry interval of handshake requests.
+const resendInterval = time.Second
+
+// Options configures a Client.
+type Options struct {
+	// Name is the player name.
+	Name string
+	// UserInfo overrides or extends the default userinfo keys.
+	UserInfo netchan.Info
+	// Protocol is the protocol version, defaults to netchan.ProtocolVersion.
+	Protocol int
+	// QPort identifies the client behind NAT, random by default.
+	QPort uint16
+	// HandshakeTimeout bounds the challenge/connect exchange, defaults to 10s.
+	HandshakeTimeout time.Duration
+	// CmdRate is the number of usercmd packets sent per second, defaults to 30.
+	CmdRate int
+	// Signon are the string commands sent after connecting, one at a time
+	// whenever the server stops sending reliable data for SignonDelay.
+	// Servers drive the rest of the signon with "cmd" stufftexts, which the
+	// client forwards on its own. Defaults to "new".
+	Signon      []string
+	SignonDelay time.Duration
+	// UserCmdFields is the usercmd_t delta table, defaults to DefaultUserCmdFields.
+	UserCmdFields []DeltaField
+	// OnMessage is called from Run for every decoded server message.
+	OnMessage func(msg netchan.Message)
+	// OnPacket is called from Run for every sequenced server packet before
+	// its messages are handled.
+	OnPacket func(pkt *netchan.Packet)
+}
+
+// DefaultUserInfo are the userinfo keys sent unless overridden.
+var DefaultUserInfo = netchan.Info{
+	"model":         "gordon",
+	"topcolor":      "0",
+	"bottomcolor":   "0",
+	"rate":          "25000",
+	"cl_updaterate": "20",
+	"cl_lw":         "1",
+	"cl_lc":         "1",
+}
+
+// Stats are the traffic counters of a client.
+type Stats struct {
+	PacketsIn  uint64
+	PacketsOut uint64
+	BytesIn    uint64
+	BytesOut   uint64
+	// RTT is the smoothed round trip time of the netchan session.
+	RTT time.Duration
+}
+
+// Client is a headless player connected to a server.
+type Client struct {
+	transport Transport
+	opts      Options
+	incoming  chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+	readErr   error
+	// transportOnce closes the transport, apart from closeOnce which a
+	// failed read may use up
+	transportOnce sync.Once
+
+	mu           sync.Mutex
+	ch           *netchan.Chan
+	splits       netchan.Reassembler
+	cmd          UserCmd
+	serverData   *netchan.ServerData
+	stats        Stats
+	signon       int
+	lastReliable time.Time
+	lastIncoming uint32
+	dropped      uint32
+}
+
+// Connect performs the challenge/connect handshake over the transport and
+// returns a client with an established netchan session. Call Run to keep
+// the session alive.
+func Connect(ctx context.Context, t Transport, opts Options) (*Client, error) {
+	if opts.Protocol == 0 {
+		opts.Protocol = netchan.ProtocolVersion
+	}
+	if opts.QPort == 0 {
+		var b [2]byte
+		rand.Read(b[:])
+		opts.QPort = binary.LittleEndian.Uint16(b[:])
+	}
+	if opts.HandshakeTimeout <= 0 {
+		opts.HandshakeTimeout = 10 * time.Second
+	}
+	if opts.CmdRate <= 0 {
+		opts.CmdRate = 30
+	}
+	if opts.Signon == nil {
+		opts.Signon = []string{"new"}
+	}
+	if opts.SignonDelay <= 0 {
+		opts.SignonDelay = 2 * time.Second
+	}
+	if opts.UserCmdFields == nil {
+		opts.UserCmdFields = DefaultUserCmdFields
+	}
+
+	c := &Client{
+		transport: t,
+		opts:      opts,
+		incoming:  make(chan []byte, 64),
+		closed:    make(chan struct{}),
+		ch:        netchan.NewChan(netchan.ClientToServer, opts.QPort),
+	}
+	go c.readLoop()
+
+	ctx, cancel := context.WithTimeout(ctx, opts.HandshakeTimeout)
+	defer cancel()
+	if err := c.handshake(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// handshake requests a challenge, then connects with it.
+func (c *Client) handshake(ctx context.Context) error {
+	challenge, err := c.exchange(ctx, func() string { return "getchallenge\n" }, "challenge")
+	if err != nil {
+		return err
+	}
+	if len(challenge) < 2 {
+		return &RejectedError{Reason: "malformed challenge"}
+	}
+	connect := func() string {
+		return fmt.Sprintf("connect %d %s \"%s\" \"%s\"\n",
+			c.opts.Protocol, challenge[1], c.protInfo(), c.userInfo())
+	}
+	_, err = c.exchange(ctx, connect, "client_connect")
+	return err
+}
+
+// exchange sends an out-of-band request until the expected reply arrives.
+func (c *Client) exchange(ctx context.Context, request func() string, reply string) ([]string, error) {
+	ticker := time.NewTicker(resendInterval)
+	defer ticker.Stop()
+	c.sendOutOfBand(request())
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, ErrHandshakeTimeout
+			}
+			return nil, ctx.Err()
+		case <-c.closed:
+			return nil, c.closeErr()
+		case <-ticker.C:
+			c.sendOutOfBand(request())
+		case data := <-c.incoming:
+			c.countIn(data)
+			pkt, err := netchan.Decode(data, netchan.ServerToClient)
+			if err != nil || pkt.Kind != netchan.KindConnectionless {
+				continue
+			}
+			oob := pkt.Connectionless
+			switch oob.Command {
+			case reply:
+				return oob.Args, nil
+			case "print", "errormsg", "disconnect":
+				reason := strings.TrimSpace(strings.TrimPrefix(string(oob.Data), oob.Command))
+				return nil, &RejectedError{Reason: strings.TrimRight(reason, "\x00")}
+			}
+		}
+	}
+}
+
+func (c *Client) protInfo() string {
+	var uuid [16]byte
+	rand.Read(uuid[:])
+	return netchan.Info{
+		netchan.ProtInfoUUID:       hex.EncodeToString(uuid[:]),
+		netchan.ProtInfoQPort:      strconv.Itoa(int(c.opts.QPort)),
+		netchan.ProtInfoExtensions: "0",
+	}.String()
+}
+
+func (c *Client) userInfo() string {
+	info := netchan.Info{}
+	for k, v := range DefaultUserInfo {
+		info[k] = v
+	}
+	info["name"] = c.opts.Name
+	for k, v := range c.opts.UserInfo {
+		info[k] = v
+	}
+	return info.String()
+}
+
+// Run keeps the session alive: it sends usercmds at the command rate,
+// processes server packets and answers the server driven signon. It
+// returns when the context ends, the client is closed or the server
+// disconnects it.
+func (c *Client) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Second / time.Duration(c.opts.CmdRate))
+	defer ticker.Stop()
+
+	c.mu.Lock()
+	c.lastReliable = time.Now()
+	c.mu.Unlock()
+	c.nextSignon()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.closed:
+			return c.closeErr()
+		case <-ticker.C:
+			c.mu.Lock()
+			if !c.ch.HasPendingReliable() && time.Since(c.lastReliable) > c.opts.SignonDelay {
+				c.lastReliable = time.Now()
+				c.mu.Unlock()
+				c.nextSignon()
+				c.mu.Lock()
+			}
+			c.mu.Unlock()
+			c.transmit()
+		case data := <-c.incoming:
+			if err := c.process(data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// nextSignon sends the next configured signon command.
+func (c *Client) nextSignon() {
+	c.mu.Lock()
+	if c.signon >= len(c.opts.Signon) {
+		c.mu.Unlock()
+		return
+	}
+	cmd := c.opts.Signon[c.signon]
+	c.signon++
+	c.mu.Unlock()
+	c.StringCmd(cmd)
+}
+
+// transmit sends a netchan packet carrying the current usercmd.
+func (c *Client) transmit() {
+	c.mu.Lock()
+	loss := byte(0)
+	if c.lastIncoming > 0 {
+		loss = byte(min(100, c.dropped*100/c.lastIncoming))
+	}
+	move, bits := buildMove(c.opts.UserCmdFields, &c.cmd, loss, c.ch.OutgoingSequence())
+	data := c.ch.Transmit(move, bits)
+	c.mu.Unlock()
+	c.write(data)
+}
+
+// process handles one datagram received from the server.
+func (c *Client) process(data []byte) error {
+	c.countIn(data)
+	c.mu.Lock()
+	if split, err := netchan.ParseSplit(data); err == nil {
+		full, ok := c.splits.Add(split)
+		if !ok {
+			c.mu.Unlock()
+			return nil
+		}
+		data = full
+	}
+	pkt, ok := c.ch.Process(data)
+	if ok {
+		if c.lastIncoming > 0 && pkt.Header.Sequence > c.lastIncoming+1 {
+			c.dropped += pkt.Header.Sequence - c.lastIncoming - 1
+		}
+		c.lastIncoming = pkt.Header.Sequence
+		if pkt.Header.Reliable {
+			c.lastReliable = time.Now()
+		}
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if c.opts.OnPacket != nil {
+		c.opts.OnPacket(pkt)
+	}
+
+	for _, msg := range pkt.Messages {
+		switch msg.Op {
+		case netchan.SvcDisconnect:
+			return &DisconnectedError{Reason: strings.TrimSpace(msg.String)}
+		case netchan.SvcServerData:
+			c.mu.Lock()
+			c.serverData = msg.ServerData
+			c.mu.Unlock()
+		case netchan.SvcStuffText:
+			c.stuffText(msg.String)
+		}
+		if c.opts.OnMessage != nil {
+			c.opts.OnMessage(msg)
+		}
+	}
+	return nil
+}
+
+// stuffText forwards the "cmd" lines of a stufftext to the server, which
+// is how the engine drives the signon and queries clients.
+func (c *Client) stuffText(text string) {
+	for _, line := range strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == ';' }) {
+		line = strings.TrimSpace(line)
+		if cmd, ok := strings.CutPrefix(line, "cmd "); ok {
+			c.StringCmd(strings.TrimSpace(cmd))
+		}
+	}
+}
+
+// StringCmd queues a reliable console command for the server, e.g. "say hi".
+func (c *Client) StringCmd(cmd string) {
+	w := &netchan.BitWriter{}
+	w.WriteUint8(netchan.ClcStringCmd)
+	w.WriteString(cmd)
+	c.mu.Lock()
+	c.ch.QueueReliable(w.Bytes())
+	c.mu.Unlock()
+}
+
+// SetUserCmd sets the movement state sent with every following packet.
+func (c *Client) SetUserCmd(cmd UserCmd) {
+	c.mu.Lock()
+	c.cmd = cmd
+	c.mu.Unlock()
+}
+
+// ServerData returns the last svc_serverdata received, or nil.
+func (c *Client) ServerData() *netchan.ServerData {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.serverData
+}
+
+// Stats returns the traffic counters of the client.
+func (c *Client) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.RTT = c.ch.RTT()
+	return stats
+}
+
+// Close sends a disconnect command and closes the transport.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		w := &netchan.BitWriter{}
+		w.WriteUint8(netchan.ClcStringCmd)
+		w.WriteString("disconnect")
+		c.mu.Lock()
+		c.ch.QueueReliable(w.Bytes())
+		data := c.ch.Transmit(nil, 0)
+		c.mu.Unlock()
+		c.write(data)
+		close(c.closed)
+	})
+	// a failed read closes the client first, the transport still needs closing
+	var err error
+	c.transportOnce.Do(func() {
+		err = c.transport.Close()
+	})
+	return err
+}
+
+func (c *Client) closeErr() error {
+	if c.readErr != nil {
+		return c.readErr
+	}
+	return ErrClosed
+}
+
+// readLoop moves datagrams from the transport to the incoming channel.
+func (c *Client) readLoop() {
+	buf := make([]byte, 65536)
+	for {
+		n, err := c.transport.Read(buf)
+		if err != nil {
+			c.closeOnce.Do(func() {
+				c.readErr = err
+				close(c.closed)
+			})
+			return
+		}
+		select {
+		case c.incoming <- append([]byte(nil), buf[:n]...):
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *Client) sendOutOfBand(text string) {
+	data := binary.LittleEndian.AppendUint32(nil, netchan.ConnectionlessHeader)
+	c.write(append(data, text...))
+}
+
+func (c *Client) write(data []byte) {
+	if _, err := c.transport.Write(data); err != nil {
+		return
+	}
+	c.mu.Lock()
+	c.stats.PacketsOut++
+	c.stats.BytesOut += uint64(len(data))
+	c.mu.Unlock()
+}
+
+func (c *Client) countIn(data []byte) {
+	c.mu.Lock()
+	c.stats.PacketsIn++
+	c.stats.BytesIn += uint64(len(data))
+	c.mu.Unlock()
+}
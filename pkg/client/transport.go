@@ -0,0 +1,18 @@
+package client
+
+import (
+	"io"
+	"net"
+)
+
+// Transport carries datagrams between the client and the server.
+// Each Read returns one datagram and each Write sends one. net.Conn from
+// DialUDP and the in-process goxash3d_fwgs.LocalPeer both implement it.
+type Transport interface {
+	io.ReadWriteCloser
+}
+
+// DialUDP connects a UDP transport to the server address, e.g. "127.0.0.1:27015".
+func DialUDP(addr string) (Transport, error) {
+	return net.Dial("udp", addr)
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"github.com/yohimik/goxash3d-fwgs/pkg/netchan"
+	"hash/crc32"
+	"math"
+)
+
+// Buttons of UserCmd.Buttons.
+const (
+	InAttack    = 1 << 0
+	InJump      = 1 << 1
+	InDuck      = 1 << 2
+	InForward   = 1 << 3
+	InBack      = 1 << 4
+	InUse       = 1 << 5
+	InLeft      = 1 << 7
+	InRight     = 1 << 8
+	InMoveLeft  = 1 << 9
+	InMoveRight = 1 << 10
+	InAttack2   = 1 << 11
+	InReload    = 1 << 13
+)
+
+// UserCmd is the movement state sent to the server every frame.
+type UserCmd struct {
+	LerpMsec     int
+	Msec         int
+	ViewAngles   [3]float32
+	ForwardMove  float32
+	SideMove     float32
+	UpMove       float32
+	LightLevel   int
+	Buttons      int
+	Impulse      int
+	WeaponSelect int
+}
+
+// Field encodings of DeltaField.
+const (
+	DeltaInteger = iota
+	DeltaFloat
+	DeltaAngle
+)
+
+// DeltaField describes one usercmd_t entry of the server delta table.
+type DeltaField struct {
+	Name       string
+	Type       int
+	Signed     bool
+	Bits       int
+	Multiplier float32
+	// Value extracts the field from a command.
+	Value func(cmd *UserCmd) float64
+}
+
+// DefaultUserCmdFields is the usercmd_t table of the stock delta.lst.
+// Servers running mods with a modified table need a matching field list.
+var DefaultUserCmdFields = []DeltaField{
+	{"lerp_msec", DeltaInteger, false, 9, 1, func(c *UserCmd) float64 { return float64(c.LerpMsec) }},
+	{"msec", DeltaInteger, false, 8, 1, func(c *UserCmd) float64 { return float64(c.Msec) }},
+	{"viewangles[1]", DeltaAngle, false, 16, 1, func(c *UserCmd) float64 { return float64(c.ViewAngles[1]) }},
+	{"viewangles[0]", DeltaAngle, false, 16, 1, func(c *UserCmd) float64 { return float64(c.ViewAngles[0]) }},
+	{"buttons", DeltaInteger, false, 16, 1, func(c *UserCmd) float64 { return float64(c.Buttons) }},
+	{"forwardmove", DeltaFloat, true, 12, 1, func(c *UserCmd) float64 { return float64(c.ForwardMove) }},
+	{"lightlevel", DeltaInteger, false, 8, 1, func(c *UserCmd) float64 { return float64(c.LightLevel) }},
+	{"sidemove", DeltaFloat, true, 12, 1, func(c *UserCmd) float64 { return float64(c.SideMove) }},
+	{"upmove", DeltaFloat, true, 12, 1, func(c *UserCmd) float64 { return float64(c.UpMove) }},
+	{"impulse", DeltaInteger, false, 8, 1, func(c *UserCmd) float64 { return float64(c.Impulse) }},
+	{"viewangles[2]", DeltaAngle, false, 16, 1, func(c *UserCmd) float64 { return float64(c.ViewAngles[2]) }},
+	{"weaponselect", DeltaInteger, false, 8, 1, func(c *UserCmd) float64 { return float64(c.WeaponSelect) }},
+}
+
+// writeDelta writes the fields of cmd that differ from the null command.
+func writeDelta(w *netchan.BitWriter, fields []DeltaField, cmd *UserCmd) {
+	var null UserCmd
+	for _, f := range fields {
+		v := f.Value(cmd)
+		if v == f.Value(&null) {
+			w.WriteBit(false)
+			continue
+		}
+		w.WriteBit(true)
+		switch f.Type {
+		case DeltaAngle:
+			shift := float64(uint32(1) << f.Bits)
+			w.WriteBits(uint32(int64(v*shift/360))&(uint32(shift)-1), f.Bits)
+		case DeltaFloat:
+			writeBitLong(w, int32(v*float64(f.Multiplier)), f.Bits, f.Signed)
+		default:
+			writeBitLong(w, int32(math.Round(v*float64(f.Multiplier))), f.Bits, f.Signed)
+		}
+	}
+}
+
+// writeBitLong writes an integer the way MSG_WriteBitLong does: signed
+// values carry their sign in the bit following the magnitude bits.
+func writeBitLong(w *netchan.BitWriter, v int32, bits int, signed bool) {
+	if !signed {
+		w.WriteBits(uint32(v), bits)
+		return
+	}
+	if v < 0 {
+		w.WriteBits(uint32(0x80000000+int64(v)), bits-1)
+		w.WriteBit(true)
+		return
+	}
+	w.WriteBits(uint32(v), bits-1)
+	w.WriteBit(false)
+}
+
+// moveChecksum is CRC32_BlockSequence: the CRC of up to 60 bytes of the
+// move followed by four bytes of the CRC table selected by the sequence.
+func moveChecksum(data []byte, sequence uint32) byte {
+	if len(data) > 60 {
+		data = data[:60]
+	}
+	buf := make([]byte, 0, 64)
+	buf = append(buf, data...)
+	offset := int(sequence % 0x3FC)
+	for i := offset; i < offset+4; i++ {
+		buf = append(buf, byte(crc32.IEEETable[i/4]>>(8*(i%4))))
+	}
+	return byte(crc32.ChecksumIEEE(buf))
+}
+
+// buildMove encodes a clc_move message carrying one new command and
+// returns the message bytes with its length in bits.
+func buildMove(fields []DeltaField, cmd *UserCmd, loss byte, sequence uint32) ([]byte, int) {
+	w := &netchan.BitWriter{}
+	w.WriteUint8(netchan.ClcMove)
+	w.WriteUint8(0) // checksum, patched below
+	w.WriteUint8(loss)
+	w.WriteUint8(0) // numbackup
+	w.WriteUint8(1) // newcmds
+	writeDelta(w, fields, cmd)
+	data := w.Bytes()
+	data[1] = moveChecksum(data[2:], sequence)
+	return data, w.BitPos()
+}
@@ -0,0 +1,162 @@
+package goxash3d_fwgs
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// Results of lib_sv_client_command.
+const (
+	clientCommandRun     = 0
+	clientCommandDrop    = 1
+	clientCommandRewrite = 2
+)
+
+// ClientCommand is a string command sent by a client, as seen by the
+// client command filters.
+type ClientCommand struct {
+	Client Client
+	// Line is the command line. A filter may replace it, the engine then
+	// runs the new line instead.
+	Line string
+	// Drop keeps the command from the engine and the later filters.
+	Drop bool
+
+	reply strings.Builder
+}
+
+// Reply prints a message to the client only.
+func (c *ClientCommand) Reply(format string, args ...any) {
+	fmt.Fprintf(&c.reply, format, args...)
+	if !strings.HasSuffix(c.reply.String(), "\n") {
+		c.reply.WriteByte('\n')
+	}
+}
+
+// ClientCommandFilter inspects a client command before the engine runs it.
+type ClientCommandFilter func(cmd *ClientCommand)
+
+// clientCommandFilters runs the filters of client commands and keeps the
+// strings handed back to the engine.
+type clientCommandFilters struct {
+	mu      sync.RWMutex
+	filters []*ClientCommandFilter
+
+	// reply and rewrite stay valid until the next client command, engine thread only
+	reply   *C.char
+	rewrite *C.char
+}
+
+// FilterClientCommands adds a filter for the string commands of clients,
+// e.g. say, and returns a function removing it. Filters run in the order
+// they were added, synchronously on the engine thread while the command
+// is processed, so they must return quickly. They only see commands when
+// the engine reports them through lib_sv_client_command.
+func (x *Xash3D) FilterClientCommands(filter ClientCommandFilter) (remove func()) {
+	f := &x.clientCommands
+	h := &filter
+	f.mu.Lock()
+	f.filters = append(f.filters, h)
+	f.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			for i, other := range f.filters {
+				if other == h {
+					f.filters = append(f.filters[:i:i], f.filters[i+1:]...)
+					return
+				}
+			}
+		})
+	}
+}
+
+// handle filters a client command and runs it when it is a Go command.
+// It returns the result for lib_sv_client_command.
+func (f *clientCommandFilters) handle(console *consoleCommands, client Client, line string) int {
+	cmd := &ClientCommand{Client: client, Line: line}
+
+	f.mu.RLock()
+	filters := f.filters
+	f.mu.RUnlock()
+	for _, filter := range filters {
+		f.call(*filter, cmd)
+		if cmd.Drop {
+			break
+		}
+	}
+
+	result := clientCommandRun
+	if cmd.Drop {
+		result = clientCommandDrop
+	} else if out, ok := console.runClient(client, cmd.Line); ok {
+		cmd.reply.WriteString(out)
+		result = clientCommandDrop
+	} else if cmd.Line != line {
+		result = clientCommandRewrite
+	}
+
+	setCString(&f.reply, cmd.reply.String())
+	if result == clientCommandRewrite {
+		setCString(&f.rewrite, cmd.Line)
+	} else {
+		setCString(&f.rewrite, "")
+	}
+	return result
+}
+
+// call runs a filter, a panicking filter lets the command through unchanged.
+func (f *clientCommandFilters) call(filter ClientCommandFilter, cmd *ClientCommand) {
+	line := cmd.Line
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "goxash3d_fwgs: client command filter panicked: %v\n%s", r, debug.Stack())
+			cmd.Line, cmd.Drop = line, false
+		}
+	}()
+	filter(cmd)
+}
+
+// setCString replaces a C string kept for the engine, empty text clears it.
+func setCString(p **C.char, text string) {
+	if *p != nil {
+		C.free(unsafe.Pointer(*p))
+		*p = nil
+	}
+	if text != "" {
+		*p = C.CString(text)
+	}
+}
+
+// lib_sv_client_reply returns the text to print to the client whose
+// command lib_sv_client_command just handled, or NULL. The engine must not
+// free it, it stays valid until the next client command.
+//
+//	const char *lib_sv_client_reply( void );
+//
+//export lib_sv_client_reply
+func lib_sv_client_reply() *C.char {
+	return DefaultXash3D.clientCommands.reply
+}
+
+// lib_sv_client_rewrite returns the command line to run instead when
+// lib_sv_client_command returned 2. Like lib_sv_client_reply it is owned by
+// Go and valid until the next client command.
+//
+//	const char *lib_sv_client_rewrite( void );
+//
+//export lib_sv_client_rewrite
+func lib_sv_client_rewrite() *C.char {
+	return DefaultXash3D.clientCommands.rewrite
+}
@@ -0,0 +1,96 @@
+package goxash3d_fwgs
+
+/*
+#include "xash.h"
+#include <stdlib.h>
+
+extern void lib_cmd_dispatch( void );
+
+// go_command is the callback of every command registered from Go,
+// lib_cmd_dispatch finds the handler by the command name
+static void go_command( void ) {
+	lib_cmd_dispatch();
+}
+
+static void add_go_command( const char *name, const char *desc ) {
+	Cmd_AddCommand( name, go_command, desc );
+}
+
+static void con_print( const char *text ) {
+	Con_Printf( "%s", text );
+}
+*/
+import "C"
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// commandQueue holds console commands until the engine thread picks them up.
+type commandQueue struct {
+	// pending keeps the per packet check in lib_net_recvfrom lock free
+	pending  atomic.Bool
+	mu       sync.Mutex
+	commands []string
+	// registrations are Go commands not yet added to the engine
+	registrations []*Command
+}
+
+// ExecCommand queues a console command, e.g. "changelevel crossfire".
+// The engine is not thread safe, so the command is added to its command
+// buffer from the engine thread the next time it polls the network and
+// runs with the following frame.
+func (x *Xash3D) ExecCommand(cmd string) {
+	x.commands.mu.Lock()
+	defer x.commands.mu.Unlock()
+	x.commands.commands = append(x.commands.commands, cmd)
+	x.commands.pending.Store(true)
+}
+
+// drain passes the queued commands to the engine command buffer.
+// It must run on the engine thread.
+func (q *commandQueue) drain() {
+	if !q.pending.Load() {
+		return
+	}
+	q.mu.Lock()
+	q.pending.Store(false)
+	commands := q.commands
+	registrations := q.registrations
+	q.commands, q.registrations = nil, nil
+	q.mu.Unlock()
+
+	// register first, queued commands may already use them
+	for _, cmd := range registrations {
+		name := C.CString(cmd.Name)
+		desc := C.CString(cmd.Description)
+		C.add_go_command(name, desc)
+		C.free(unsafe.Pointer(name))
+		C.free(unsafe.Pointer(desc))
+	}
+	for _, cmd := range commands {
+		text := C.CString(cmd + "\n")
+		C.Cbuf_AddText(text)
+		C.free(unsafe.Pointer(text))
+	}
+}
+
+// engineArgs returns the arguments of the command being executed.
+// It must run on the engine thread.
+func engineArgs() []string {
+	argc := int(C.Cmd_Argc())
+	args := make([]string, argc)
+	for i := range args {
+		args[i] = C.GoString(C.Cmd_Argv(C.int(i)))
+	}
+	return args
+}
+
+// enginePrint prints text to the console, or to the rcon client when the
+// command came over rcon. It must run on the engine thread.
+func enginePrint(text string) {
+	cText := C.CString(text)
+	C.con_print(cText)
+	C.free(unsafe.Pointer(cText))
+}
@@ -0,0 +1,392 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/yohimik/goxash3d-fwgs/pkg/netchan"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// setting is a cvar of the model.
+type setting struct {
+	// names are the cvar names, the first one is rendered
+	names []string
+	// onoff renders a bool as on/off instead of 1/0
+	onoff bool
+	// index is the field path from Server
+	index []int
+}
+
+var settings = collectSettings(reflect.TypeOf(Server{}), nil)
+
+func collectSettings(t reflect.Type, index []int) []setting {
+	var out []setting
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		path := append(append([]int(nil), index...), i)
+		if tag, ok := f.Tag.Lookup("cfg"); ok {
+			names := strings.Split(tag, ",")
+			st := setting{index: path}
+			for _, name := range names {
+				if name == "onoff" {
+					st.onoff = true
+				} else {
+					st.names = append(st.names, name)
+				}
+			}
+			out = append(out, st)
+			continue
+		}
+		switch {
+		case f.Type.Kind() == reflect.Struct:
+			out = append(out, collectSettings(f.Type, path)...)
+		case f.Type.Kind() == reflect.Pointer && f.Type.Elem().Kind() == reflect.Struct:
+			out = append(out, collectSettings(f.Type.Elem(), path)...)
+		}
+	}
+	return out
+}
+
+// lookupSetting finds the setting of a cvar name.
+func lookupSetting(name string) (setting, bool) {
+	name = strings.ToLower(name)
+	for _, st := range settings {
+		for _, n := range st.names {
+			if n == name {
+				return st, true
+			}
+		}
+	}
+	return setting{}, false
+}
+
+// value returns the field of the setting. A nil mod section is allocated
+// with the defaults of the mod when alloc is set, otherwise ok is false.
+func (st setting) value(s *Server, alloc bool) (reflect.Value, bool) {
+	v := reflect.ValueOf(s).Elem()
+	for _, i := range st.index {
+		if v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				v.Set(sectionDefaults(v.Type()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v, true
+}
+
+// sectionDefaults returns the defaults of a mod section type.
+func sectionDefaults(t reflect.Type) reflect.Value {
+	for _, game := range []string{"valve", "cstrike"} {
+		d := reflect.ValueOf(Default(game)).Elem()
+		for i := 0; i < d.NumField(); i++ {
+			if f := d.Field(i); f.Type() == t && !f.IsNil() {
+				return f
+			}
+		}
+	}
+	return reflect.New(t.Elem())
+}
+
+// set parses text into the field v of the setting.
+func (st setting) set(v reflect.Value, text string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(text)
+	case reflect.Bool:
+		switch strings.ToLower(text) {
+		case "on":
+			v.SetBool(true)
+		case "off":
+			v.SetBool(false)
+		default:
+			f, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return fmt.Errorf("config: %s: %q is not a boolean", st.names[0], text)
+			}
+			v.SetBool(f != 0)
+		}
+	case reflect.Int:
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("config: %s: %q is not a number", st.names[0], text)
+		}
+		v.SetInt(int64(f))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("config: %s: %q is not a number", st.names[0], text)
+		}
+		v.SetFloat(f)
+	}
+	return nil
+}
+
+// format renders the value of the setting, ok is false when it is not set.
+func (st setting) format(s *Server) (string, bool) {
+	v, ok := st.value(s, false)
+	if !ok {
+		return "", false
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), v.String() != ""
+	case reflect.Bool:
+		switch {
+		case st.onoff && v.Bool():
+			return "on", true
+		case st.onoff:
+			return "off", true
+		case v.Bool():
+			return "1", true
+		default:
+			return "0", true
+		}
+	case reflect.Int:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), true
+	}
+	return "", false
+}
+
+// line is a line of a parsed .cfg file. Render writes the lines back, so
+// comments, the order and the spelling of the cvar names survive.
+type line struct {
+	statements []statement
+	// comment is the rest of the line from //
+	comment string
+	// crlf is set when the line ended with \r\n
+	crlf bool
+}
+
+// statement is a command of a line as written in the file.
+type statement struct {
+	text string
+	args []string
+}
+
+// file is the text of a parsed .cfg file.
+type file struct {
+	lines []line
+	// extra is the number of Extra commands read from the file
+	extra int
+	// newline is set when the last line ended with a line break
+	newline bool
+}
+
+// readLines splits a .cfg file into lines of statements.
+func readLines(r io.Reader) (*file, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text := string(data)
+	f := &file{newline: strings.HasSuffix(text, "\n")}
+	if text == "" {
+		return f, nil
+	}
+	for _, raw := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		l := line{crlf: strings.HasSuffix(raw, "\r")}
+		texts, comment := splitStatements(strings.TrimSuffix(raw, "\r"))
+		l.comment = comment
+		for _, t := range texts {
+			l.statements = append(l.statements, statement{text: t, args: netchan.Tokenize(t)})
+		}
+		f.lines = append(f.lines, l)
+	}
+	return f, nil
+}
+
+// ParseCommands splits a .cfg file into commands. Comments start with //
+// outside of quotes and commands on one line are separated by semicolons.
+func ParseCommands(r io.Reader) ([]Command, error) {
+	f, err := readLines(r)
+	if err != nil {
+		return nil, err
+	}
+	var commands []Command
+	for _, l := range f.lines {
+		for _, stmt := range l.statements {
+			if len(stmt.args) > 0 {
+				commands = append(commands, Command{Name: stmt.args[0], Args: stmt.args[1:]})
+			}
+		}
+	}
+	return commands, nil
+}
+
+// splitStatements splits a line at the semicolons outside of quotes and
+// returns the comment starting with // separately.
+func splitStatements(line string) ([]string, string) {
+	var out []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '"':
+			quoted = !quoted
+		case quoted:
+		case line[i] == ';':
+			out = append(out, line[start:i])
+			start = i + 1
+		case strings.HasPrefix(line[i:], "//"):
+			return append(out, line[start:i]), line[i:]
+		}
+	}
+	return append(out, line[start:]), ""
+}
+
+// Parse reads a .cfg file on top of the defaults of the game directory.
+// Commands the model does not know are kept in Extra. With an unknown game
+// the first mod option decides which mod section is filled. Render writes
+// the file back and only rewrites the settings changed after Parse.
+func Parse(r io.Reader, game string) (*Server, error) {
+	f, err := readLines(r)
+	if err != nil {
+		return nil, err
+	}
+	s := Default(game)
+	s.present = make(map[string]bool)
+	for _, l := range f.lines {
+		for _, stmt := range l.statements {
+			if len(stmt.args) == 0 {
+				continue
+			}
+			cmd := Command{Name: stmt.args[0], Args: stmt.args[1:]}
+			st, ok := lookupSetting(cmd.Name)
+			if !ok || len(cmd.Args) == 0 {
+				s.Extra = append(s.Extra, cmd)
+				continue
+			}
+			// options of another mod stay commands, e.g. mp_flashlight for cstrike
+			v, ok := st.value(s, s.Valve == nil && s.Cstrike == nil)
+			if !ok {
+				s.Extra = append(s.Extra, cmd)
+				continue
+			}
+			if err := st.set(v, cmd.Args[0]); err != nil {
+				return nil, err
+			}
+			s.present[st.names[0]] = true
+		}
+	}
+	f.extra = len(s.Extra)
+	s.file = f
+	s.parsed = make(map[string]string)
+	for _, st := range settings {
+		if value, ok := st.format(s); ok {
+			s.parsed[st.names[0]] = value
+		}
+	}
+	return s, nil
+}
+
+// rendered reports whether Render writes the setting: always for servers
+// built in code, otherwise when the file had it or it changed since Parse.
+func (s *Server) rendered(st setting, value string) bool {
+	if s.parsed == nil || s.present[st.names[0]] {
+		return true
+	}
+	parsed, ok := s.parsed[st.names[0]]
+	return !ok || parsed != value
+}
+
+// Render writes the settings as a .cfg file, followed by Extra. A parsed
+// file is written back line by line with the settings changed since Parse
+// rewritten in place, under the name the file spells them with. Settings
+// the file left out and commands appended to Extra follow at the end.
+func (s *Server) Render(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	written := map[string]bool{}
+	if f := s.file; f != nil {
+		for i, l := range f.lines {
+			for j, stmt := range l.statements {
+				if j > 0 {
+					bw.WriteByte(';')
+				}
+				bw.WriteString(s.renderStatement(stmt, written))
+			}
+			bw.WriteString(l.comment)
+			if l.crlf {
+				bw.WriteByte('\r')
+			}
+			if i < len(f.lines)-1 || f.newline {
+				bw.WriteByte('\n')
+			}
+		}
+	}
+
+	var tail []string
+	for _, st := range settings {
+		value, ok := st.format(s)
+		if !ok || written[st.names[0]] || !s.rendered(st, value) {
+			continue
+		}
+		tail = append(tail, settingLine(st.names[0], st, value))
+	}
+	extra := s.Extra
+	if s.file != nil {
+		extra = extra[min(s.file.extra, len(extra)):]
+	}
+	if s.file == nil && len(tail) > 0 && len(extra) > 0 {
+		tail = append(tail, "")
+	}
+	for _, cmd := range extra {
+		tail = append(tail, cmd.String())
+	}
+	if len(tail) > 0 && s.file != nil && len(s.file.lines) > 0 && !s.file.newline {
+		bw.WriteByte('\n')
+	}
+	for _, t := range tail {
+		bw.WriteString(t)
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// renderStatement returns a statement of a parsed file, with the current
+// value when it sets a setting that changed since Parse.
+func (s *Server) renderStatement(stmt statement, written map[string]bool) string {
+	if len(stmt.args) < 2 {
+		return stmt.text
+	}
+	st, ok := lookupSetting(stmt.args[0])
+	if !ok {
+		return stmt.text
+	}
+	value, ok := st.format(s)
+	if !ok {
+		return stmt.text
+	}
+	written[st.names[0]] = true
+	if value == s.parsed[st.names[0]] {
+		return stmt.text
+	}
+	trimmed := strings.TrimSpace(stmt.text)
+	at := strings.Index(stmt.text, trimmed)
+	return stmt.text[:at] + settingLine(stmt.args[0], st, value) + stmt.text[at+len(trimmed):]
+}
+
+// settingLine renders the setting under the given cvar name.
+func settingLine(name string, st setting, value string) string {
+	if st.onoff {
+		return name + " " + value
+	}
+	return name + " " + strconv.Quote(value)
+}
+
+// quote quotes a command argument when the engine would split it.
+func quote(arg string) string {
+	if arg == "" || strings.ContainsAny(arg, " \t;") || strings.Contains(arg, "//") {
+		return `"` + arg + `"`
+	}
+	return arg
+}
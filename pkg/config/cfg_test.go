@@ -0,0 +1,105 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// shipped are the configs of configs/ with the game directory they belong to.
+var shipped = []struct {
+	path string
+	game string
+}{
+	{"valve/default.cfg", "valve"},
+	{"valve/server.cfg", "valve"},
+	{"valve/config/server.cfg", "valve"},
+	{"cstrike/server.cfg", "cstrike"},
+}
+
+func parseFile(t *testing.T, path, game string) ([]byte, *Server) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "configs", path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Parse(bytes.NewReader(data), game)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return data, s
+}
+
+func render(t *testing.T, s *Server) string {
+	t.Helper()
+	var b strings.Builder
+	if err := s.Render(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestRenderRoundTrip(t *testing.T) {
+	for _, cfg := range shipped {
+		data, s := parseFile(t, cfg.path, cfg.game)
+		if got := render(t, s); got != string(data) {
+			t.Errorf("%s: rendered\n%s\nwant\n%s", cfg.path, got, data)
+		}
+		// the rendered file parses to the same settings
+		again, err := Parse(strings.NewReader(render(t, s)), cfg.game)
+		if err != nil {
+			t.Fatalf("%s: %v", cfg.path, err)
+		}
+		if got, want := render(t, again), string(data); got != want {
+			t.Errorf("%s: second round trip rendered\n%s\nwant\n%s", cfg.path, got, want)
+		}
+	}
+}
+
+func TestRenderChanged(t *testing.T) {
+	data, s := parseFile(t, "valve/default.cfg", "valve")
+	s.Rates.MinUpdateRate = 20
+	s.MaxPlayers = 16
+	s.Extra = append(s.Extra, Command{Name: "exec", Args: []string{"extra.cfg"}})
+	env := map[string]string{"XASH_MP_FRAGLIMIT": "50"}
+	if err := ApplyEnv(s, "XASH_", func(k string) (string, bool) { v, ok := env[k]; return v, ok }); err != nil {
+		t.Fatal(err)
+	}
+
+	// changed settings keep their line and the spelling of the file
+	want := strings.NewReplacer(
+		"sv_minupaterate \"30\"\n", "sv_minupaterate \"20\"\n",
+		"maxplayers 12\n", "maxplayers \"16\"\n",
+	).Replace(string(data)) + "mp_fraglimit \"50\"\nexec extra.cfg\n"
+	if got := render(t, s); got != want {
+		t.Errorf("rendered\n%s\nwant\n%s", got, want)
+	}
+	if strings.Contains(want, "sv_minupdaterate") {
+		t.Error("the misspelt cvar was renamed")
+	}
+}
+
+func TestRenderNoFinalNewline(t *testing.T) {
+	s, err := Parse(strings.NewReader("hostname a // name\r\nsv_lan 0; mp_timelimit 5"), "cstrike")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Hostname = "b"
+	s.TimeLimit = 10
+	s.Cstrike.StartMoney = 1000
+	want := "hostname \"b\" // name\r\nsv_lan 0; mp_timelimit \"10\"\nmp_startmoney \"1000\"\n"
+	if got := render(t, s); got != want {
+		t.Errorf("rendered %q, want %q", got, want)
+	}
+}
+
+func TestRenderCode(t *testing.T) {
+	s := Default("valve")
+	s.Extra = []Command{{Name: "exec", Args: []string{"banned.cfg"}}}
+	got := render(t, s)
+	if !strings.HasPrefix(got, "hostname \"XashDS Docker\"\nmaxplayers \"12\"\n") || !strings.HasSuffix(got, "\n\nexec banned.cfg\n") {
+		t.Errorf("rendered\n%s", got)
+	}
+}
@@ -0,0 +1,202 @@
+// Package config is a typed model of the server configuration files
+// (server.cfg, default.cfg and mapcycle.txt).
+//
+// Parse reads a .cfg file into a Server, Render writes it back out and
+// Validate checks the values before they reach the engine. Render keeps the
+// comments, the order and the cvar spelling of a parsed file, including the
+// misspelt sv_minupaterate of the stock default.cfg, and only rewrites the
+// settings that changed. Settings a parsed file leaves out keep their
+// defaults but are not rendered, so they do not override other configs
+// such as default.cfg. Commands the model does not know, such as exec or
+// echo, are kept in Extra, so deployment tooling can produce configs from
+// code and environment variables without losing hand-written parts:
+//
+//	cfg, err := config.Parse(file, "cstrike")
+//	...
+//	err = config.ApplyEnv(cfg, "XASH_", os.LookupEnv)
+//	...
+//	err = cfg.Render(out)
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// MaxPlayers is the largest maxplayers value the engine accepts.
+const MaxPlayers = 32
+
+// Server holds the common server settings and the options of one mod.
+type Server struct {
+	Hostname     string  `cfg:"hostname"`
+	MaxPlayers   int     `cfg:"maxplayers"`
+	LAN          bool    `cfg:"sv_lan"`
+	Public       bool    `cfg:"public"`
+	Password     string  `cfg:"sv_password"`
+	RconPassword string  `cfg:"rcon_password"`
+	Pausable     bool    `cfg:"pausable"`
+	TimeLimit    float64 `cfg:"mp_timelimit"`
+	FragLimit    int     `cfg:"mp_fraglimit"`
+
+	// MapCycleFile, MapChangeCfgFile and MOTDFile are paths relative to the game directory.
+	MapCycleFile     string `cfg:"mapcyclefile"`
+	MapChangeCfgFile string `cfg:"mapchangecfgfile"`
+	MOTDFile         string `cfg:"motdfile"`
+
+	Rates   Rates
+	Logging Logging
+
+	// Valve and Cstrike hold the options of the mod, at most one is set.
+	Valve   *Valve
+	Cstrike *Cstrike
+
+	// Extra are the commands not covered by the model, in file order.
+	// Render writes those of a parsed file at their line and commands
+	// appended after Parse at the end.
+	Extra []Command
+
+	// present are the settings given by the parsed file or ApplyEnv and
+	// parsed the values after Parse by cvar name. Both are nil for servers
+	// built in code, which render every setting.
+	present map[string]bool
+	parsed  map[string]string
+	// file is the text of the parsed file, nil for servers built in code
+	file *file
+}
+
+// Rates limits the bandwidth and update rate of clients.
+type Rates struct {
+	MinRate int `cfg:"sv_minrate"`
+	MaxRate int `cfg:"sv_maxrate"`
+	// the stock default.cfg misspells the update rate cvars, both spellings are read
+	MinUpdateRate int `cfg:"sv_minupdaterate,sv_minupaterate"`
+	MaxUpdateRate int `cfg:"sv_maxupdaterate,sv_maxupaterate"`
+}
+
+// Logging configures the server log.
+type Logging struct {
+	Enabled bool `cfg:"log,onoff"`
+	// File writes the log into the logs directory.
+	File   bool `cfg:"mp_logfile"`
+	Echo   bool `cfg:"mp_logecho"`
+	Detail int  `cfg:"mp_logdetail"`
+}
+
+// Valve holds the Half-Life deathmatch options.
+type Valve struct {
+	Teamplay      bool `cfg:"mp_teamplay"`
+	Flashlight    bool `cfg:"mp_flashlight"`
+	AutoCrosshair bool `cfg:"mp_autocrosshair"`
+	FallDamage    bool `cfg:"mp_falldamage"`
+	WeaponStay    bool `cfg:"mp_weaponstay"`
+	Footsteps     bool `cfg:"mp_footsteps"`
+	AutoAim       bool `cfg:"sv_aim"`
+}
+
+// Cstrike holds the Counter-Strike options.
+type Cstrike struct {
+	FriendlyFire    bool    `cfg:"mp_friendlyfire"`
+	AutoTeamBalance bool    `cfg:"mp_autoteambalance"`
+	LimitTeams      int     `cfg:"mp_limitteams"`
+	RoundTime       float64 `cfg:"mp_roundtime"`
+	FreezeTime      int     `cfg:"mp_freezetime"`
+	BuyTime         float64 `cfg:"mp_buytime"`
+	StartMoney      int     `cfg:"mp_startmoney"`
+	C4Timer         int     `cfg:"mp_c4timer"`
+}
+
+// Command is a console command line of a .cfg file.
+type Command struct {
+	Name string
+	Args []string
+}
+
+// String returns the command as a .cfg line.
+func (c Command) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name)
+	for _, arg := range c.Args {
+		b.WriteByte(' ')
+		b.WriteString(quote(arg))
+	}
+	return b.String()
+}
+
+// Default returns the settings of the configs shipped in configs/ for the
+// game directory, "valve" or "cstrike". Other games get no mod options.
+func Default(game string) *Server {
+	s := &Server{
+		Hostname:         "XashDS Docker",
+		MaxPlayers:       12,
+		TimeLimit:        15,
+		MapCycleFile:     "mapcycle.txt",
+		MapChangeCfgFile: "server.cfg",
+		MOTDFile:         "motd.txt",
+		Rates: Rates{
+			MinRate:       5000,
+			MaxRate:       100000,
+			MinUpdateRate: 30,
+			MaxUpdateRate: 101,
+		},
+		Logging: Logging{Enabled: true},
+	}
+	switch game {
+	case "valve":
+		s.Valve = &Valve{Flashlight: true, Footsteps: true}
+	case "cstrike":
+		s.Hostname = "My Xash3D CS Server"
+		s.TimeLimit = 30
+		s.Cstrike = &Cstrike{
+			FriendlyFire:    true,
+			AutoTeamBalance: true,
+			LimitTeams:      2,
+			RoundTime:       5,
+			FreezeTime:      6,
+			BuyTime:         1.5,
+			StartMoney:      800,
+			C4Timer:         45,
+		}
+	}
+	return s
+}
+
+// Validate reports every setting the engine or the mod would reject or clamp.
+func (s *Server) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("config: "+format, args...))
+		}
+	}
+
+	check(s.Hostname != "", "hostname is empty")
+	for _, st := range settings {
+		if v, ok := st.value(s, false); ok && v.Kind() == reflect.String {
+			check(!strings.ContainsAny(v.String(), "\"\r\n"), "%s contains a quote or a line break", st.names[0])
+		}
+	}
+	check(s.MaxPlayers >= 1 && s.MaxPlayers <= MaxPlayers, "maxplayers %d is not in 1..%d", s.MaxPlayers, MaxPlayers)
+	check(s.TimeLimit >= 0, "mp_timelimit %g is negative", s.TimeLimit)
+	check(s.FragLimit >= 0, "mp_fraglimit %d is negative", s.FragLimit)
+
+	r := s.Rates
+	check(r.MinRate >= 0 && r.MaxRate >= 0, "rates are negative")
+	check(r.MaxRate == 0 || r.MinRate <= r.MaxRate, "sv_minrate %d exceeds sv_maxrate %d", r.MinRate, r.MaxRate)
+	check(r.MinUpdateRate >= 0 && r.MaxUpdateRate >= 0, "update rates are negative")
+	check(r.MaxUpdateRate == 0 || r.MinUpdateRate <= r.MaxUpdateRate,
+		"sv_minupdaterate %d exceeds sv_maxupdaterate %d", r.MinUpdateRate, r.MaxUpdateRate)
+	check(s.Logging.Detail >= 0 && s.Logging.Detail <= 3, "mp_logdetail %d is not in 0..3", s.Logging.Detail)
+
+	check(s.Valve == nil || s.Cstrike == nil, "both valve and cstrike options are set")
+	if c := s.Cstrike; c != nil {
+		check(c.LimitTeams >= 0, "mp_limitteams %d is negative", c.LimitTeams)
+		check(c.RoundTime >= 1 && c.RoundTime <= 9, "mp_roundtime %g is not in 1..9", c.RoundTime)
+		check(c.FreezeTime >= 0, "mp_freezetime %d is negative", c.FreezeTime)
+		check(c.BuyTime > 0, "mp_buytime %g is not positive", c.BuyTime)
+		check(c.StartMoney >= 800 && c.StartMoney <= 16000, "mp_startmoney %d is not in 800..16000", c.StartMoney)
+		check(c.C4Timer >= 10 && c.C4Timer <= 90, "mp_c4timer %d is not in 10..90", c.C4Timer)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,28 @@
+package config
+
+import "strings"
+
+// ApplyEnv overrides settings with variables named after their cvar with
+// the prefix, e.g. XASH_HOSTNAME or XASH_MP_TIMELIMIT for prefix "XASH_".
+// Options of a mod are only applied when its section is set. Applied
+// settings are rendered even when a parsed file left them out.
+// lookup is usually os.LookupEnv.
+func ApplyEnv(s *Server, prefix string, lookup func(string) (string, bool)) error {
+	for _, st := range settings {
+		text, ok := lookup(prefix + strings.ToUpper(st.names[0]))
+		if !ok {
+			continue
+		}
+		v, ok := st.value(s, false)
+		if !ok {
+			continue
+		}
+		if err := st.set(v, text); err != nil {
+			return err
+		}
+		if s.present != nil {
+			s.present[st.names[0]] = true
+		}
+	}
+	return nil
+}
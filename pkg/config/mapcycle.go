@@ -0,0 +1,65 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/yohimik/goxash3d-fwgs/pkg/netchan"
+	"io"
+)
+
+// MapCycle is the map rotation of mapcycle.txt.
+type MapCycle []string
+
+// ParseMapCycle reads a mapcycle.txt. Only the map names are kept,
+// per map settings following a name are dropped.
+func ParseMapCycle(r io.Reader) (MapCycle, error) {
+	var maps MapCycle
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		statements, _ := splitStatements(scanner.Text())
+		if args := netchan.Tokenize(statements[0]); len(args) > 0 && args[0] != "{" && args[0] != "}" {
+			maps = append(maps, args[0])
+		}
+	}
+	return maps, scanner.Err()
+}
+
+// Render writes one map per line.
+func (m MapCycle) Render(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, name := range m {
+		bw.WriteString(name)
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
+
+// Validate checks that the rotation is not empty and every name is a bare
+// map name, e.g. "crossfire" rather than "maps/crossfire.bsp".
+func (m MapCycle) Validate() error {
+	if len(m) == 0 {
+		return errors.New("config: map cycle is empty")
+	}
+	var errs []error
+	for _, name := range m {
+		if !validMapName(name) {
+			errs = append(errs, fmt.Errorf("config: invalid map name %q", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validMapName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,174 @@
+package goxash3d_fwgs
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"github.com/yohimik/goxash3d-fwgs/pkg/netchan"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrCommandExists   = errors.New("goxash3d_fwgs: command already registered")
+	ErrBadCommandName  = errors.New("goxash3d_fwgs: command name must be a single word")
+	ErrMissingArgument = errors.New("goxash3d_fwgs: missing argument")
+)
+
+// CommandHandler runs a Go console command. A returned error is printed to the caller.
+type CommandHandler func(ctx *CommandContext) error
+
+// Command is a console command implemented in Go.
+type Command struct {
+	// Name is typed in the console, e.g. "go_stats".
+	Name        string
+	Description string
+	// Clients lets players run the command with "cmd <name>" when the
+	// engine reports client commands, see lib_sv_client_command.
+	Clients bool
+	Handler CommandHandler
+}
+
+// CommandContext is a single run of a Command.
+type CommandContext struct {
+	// Args are the arguments after the command name.
+	Args []string
+	// Client is the player running the command, nil for the server
+	// console and rcon.
+	Client *Client
+
+	out strings.Builder
+}
+
+// Printf prints to the caller: the console, the rcon client or the player.
+func (c *CommandContext) Printf(format string, args ...any) {
+	fmt.Fprintf(&c.out, format, args...)
+}
+
+// Arg returns the argument i, or an empty string when it is missing.
+func (c *CommandContext) Arg(i int) string {
+	if i < 0 || i >= len(c.Args) {
+		return ""
+	}
+	return c.Args[i]
+}
+
+// Int parses the argument i as an integer.
+func (c *CommandContext) Int(i int) (int, error) {
+	if i < 0 || i >= len(c.Args) {
+		return 0, fmt.Errorf("%w %d", ErrMissingArgument, i+1)
+	}
+	return strconv.Atoi(c.Args[i])
+}
+
+// Float parses the argument i as a number.
+func (c *CommandContext) Float(i int) (float64, error) {
+	if i < 0 || i >= len(c.Args) {
+		return 0, fmt.Errorf("%w %d", ErrMissingArgument, i+1)
+	}
+	return strconv.ParseFloat(c.Args[i], 64)
+}
+
+// consoleCommands holds the Go commands by lower-case name, like the
+// engine the lookup ignores case.
+type consoleCommands struct {
+	mu       sync.RWMutex
+	commands map[string]*Command
+}
+
+// RegisterCommand adds a Go command to the engine console. The engine
+// learns about it on its thread before the next queued command runs.
+//
+// Handlers run on the engine thread while the command executes, so their
+// output reaches rcon clients; they must return quickly and hand longer
+// work to a goroutine.
+func (x *Xash3D) RegisterCommand(cmd Command) error {
+	if cmd.Name == "" || strings.ContainsAny(cmd.Name, " \t\r\n\";") {
+		return ErrBadCommandName
+	}
+	key := strings.ToLower(cmd.Name)
+
+	x.console.mu.Lock()
+	defer x.console.mu.Unlock()
+	if x.console.commands == nil {
+		x.console.commands = make(map[string]*Command)
+	}
+	if _, ok := x.console.commands[key]; ok {
+		return fmt.Errorf("%w: %s", ErrCommandExists, cmd.Name)
+	}
+	registered := &cmd
+	x.console.commands[key] = registered
+
+	x.commands.mu.Lock()
+	x.commands.registrations = append(x.commands.registrations, registered)
+	x.commands.pending.Store(true)
+	x.commands.mu.Unlock()
+	return nil
+}
+
+// lookup returns the command of name.
+func (c *consoleCommands) lookup(name string) (*Command, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	cmd, ok := c.commands[strings.ToLower(name)]
+	return cmd, ok
+}
+
+// run executes the handler and returns its output.
+func (c *consoleCommands) run(cmd *Command, args []string, client *Client) string {
+	ctx := &CommandContext{Args: args, Client: client}
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				fmt.Fprintf(os.Stderr, "goxash3d_fwgs: command %s panicked: %v\n%s", cmd.Name, r, debug.Stack())
+				ctx.Printf("%s failed\n", cmd.Name)
+			}
+		}()
+		if err := cmd.Handler(ctx); err != nil {
+			ctx.Printf("%s: %v\n", cmd.Name, err)
+		}
+	}()
+	out := ctx.out.String()
+	if out != "" && !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	return out
+}
+
+// runClient runs a client string command if it names a Go command open
+// to clients and returns the output.
+func (c *consoleCommands) runClient(client Client, line string) (string, bool) {
+	args := netchan.Tokenize(line)
+	if len(args) == 0 {
+		return "", false
+	}
+	cmd, ok := c.lookup(args[0])
+	if !ok || !cmd.Clients {
+		return "", false
+	}
+	return c.run(cmd, args[1:], &client), true
+}
+
+// lib_cmd_dispatch runs the Go command named by the first engine command
+// argument, it is the callback of every command added by RegisterCommand.
+//
+//export lib_cmd_dispatch
+func lib_cmd_dispatch() {
+	args := engineArgs()
+	if len(args) == 0 {
+		return
+	}
+	cmd, ok := DefaultXash3D.console.lookup(args[0])
+	if !ok {
+		return
+	}
+	if out := DefaultXash3D.console.run(cmd, args[1:], nil); out != "" {
+		enginePrint(out)
+	}
+}
@@ -0,0 +1,279 @@
+package goxash3d_fwgs
+
+/*
+#include "xash.h"
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+var (
+	ErrCvarExists  = errors.New("goxash3d_fwgs: cvar already registered")
+	ErrBadCvarName = errors.New("goxash3d_fwgs: cvar name must be a single word")
+	ErrBadCvarText = errors.New("goxash3d_fwgs: cvar value must not contain quotes or line breaks")
+)
+
+// cvarPollInterval is how often the engine values of Go cvars are compared
+// with the last seen ones to detect changes.
+const cvarPollInterval = 100 * time.Millisecond
+
+// cvarLookupTimeout bounds the wait for the engine thread in LookupCvar.
+const cvarLookupTimeout = time.Second
+
+// CvarFlag is an engine cvar flag.
+type CvarFlag int
+
+const (
+	// CvarArchive saves the value into config.cfg.
+	CvarArchive CvarFlag = 1 << 0
+	// CvarServer announces changes to the players and server browsers.
+	CvarServer CvarFlag = 1 << 2
+	// CvarProtected hides the value from queries, e.g. for passwords.
+	CvarProtected CvarFlag = 1 << 5
+)
+
+// Cvar is an engine cvar registered from Go. It can be set from
+// server.cfg, the console and rcon like any other cvar; the Go side sees
+// the engine value with a delay of up to cvarPollInterval.
+type Cvar struct {
+	name        string
+	description string
+	def         string
+	flags       CvarFlag
+	x           *Xash3D
+
+	// cName is kept for the polls on the engine thread
+	cName *C.char
+	// registered is set once the engine knows the cvar, engine thread only
+	registered bool
+
+	mu    sync.RWMutex
+	value string
+}
+
+// cvarRegistry keeps the Go cvars in sync with the engine.
+type cvarRegistry struct {
+	// pending is set while registrations or lookups wait for the engine thread
+	pending  atomic.Bool
+	active   atomic.Bool
+	lastPoll atomic.Int64
+
+	mu            sync.RWMutex
+	cvars         map[string]*Cvar
+	registrations []*Cvar
+	lookups       []cvarLookup
+}
+
+// cvarLookup is a LookupCvar call waiting for the engine thread.
+type cvarLookup struct {
+	name  string
+	reply chan cvarValue
+}
+
+type cvarValue struct {
+	value string
+	ok    bool
+}
+
+// RegisterCvar adds a cvar to the engine. The engine creates it on its
+// thread before the next queued command runs, so it can be registered
+// before the engine starts and set from server.cfg.
+func (x *Xash3D) RegisterCvar(name, value string, flags CvarFlag, description string) (*Cvar, error) {
+	if name == "" || strings.ContainsAny(name, " \t\r\n\";") {
+		return nil, ErrBadCvarName
+	}
+	if !validCvarText(value) || !validCvarText(description) {
+		return nil, ErrBadCvarText
+	}
+	key := strings.ToLower(name)
+
+	r := &x.cvars
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cvars == nil {
+		r.cvars = make(map[string]*Cvar)
+	}
+	if _, ok := r.cvars[key]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrCvarExists, name)
+	}
+	c := &Cvar{
+		name:        name,
+		description: description,
+		def:         value,
+		flags:       flags,
+		x:           x,
+		cName:       C.CString(name),
+		value:       value,
+	}
+	r.cvars[key] = c
+	r.registrations = append(r.registrations, c)
+	r.pending.Store(true)
+	return c, nil
+}
+
+func validCvarText(s string) bool {
+	return !strings.ContainsAny(s, "\"\r\n")
+}
+
+// LookupCvar returns the value of any engine cvar. Values of Go cvars come
+// from their cache, others are read on the engine thread, so the call
+// waits for the next engine frame. Protected Go cvars are not reported.
+func (x *Xash3D) LookupCvar(name string) (string, bool) {
+	r := &x.cvars
+	r.mu.Lock()
+	if c, ok := r.cvars[strings.ToLower(name)]; ok {
+		r.mu.Unlock()
+		if c.flags&CvarProtected != 0 {
+			return "", false
+		}
+		return c.String(), true
+	}
+	reply := make(chan cvarValue, 1)
+	r.lookups = append(r.lookups, cvarLookup{name: name, reply: reply})
+	r.pending.Store(true)
+	r.mu.Unlock()
+
+	select {
+	case v := <-reply:
+		return v.value, v.ok
+	case <-time.After(cvarLookupTimeout):
+		return "", false
+	}
+}
+
+// Name returns the name of the cvar.
+func (c *Cvar) Name() string {
+	return c.name
+}
+
+// Default returns the value the cvar was registered with.
+func (c *Cvar) Default() string {
+	return c.def
+}
+
+// String returns the current value.
+func (c *Cvar) String() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.value
+}
+
+// Float returns the value as a number, 0 when it is not one, like the engine.
+func (c *Cvar) Float() float64 {
+	f, _ := strconv.ParseFloat(strings.TrimSpace(c.String()), 64)
+	return f
+}
+
+// Int returns the value truncated to an integer.
+func (c *Cvar) Int() int {
+	return int(c.Float())
+}
+
+// Bool reports whether the value is a non-zero number.
+func (c *Cvar) Bool() bool {
+	return c.Float() != 0
+}
+
+// Duration parses the value as a Go duration, or as seconds when it is a
+// plain number.
+func (c *Cvar) Duration() time.Duration {
+	value := strings.TrimSpace(c.String())
+	if d, err := time.ParseDuration(value); err == nil {
+		return d
+	}
+	return time.Duration(c.Float() * float64(time.Second))
+}
+
+// Set changes the value through the engine console, so the flags of the
+// cvar apply and change handlers run once the engine took the value.
+func (c *Cvar) Set(value string) error {
+	if !validCvarText(value) {
+		return ErrBadCvarText
+	}
+	c.x.ExecCommand(c.name + ` "` + value + `"`)
+	return nil
+}
+
+// OnChange registers a handler called with the new and the previous value
+// whenever the cvar changes. It runs on the event dispatcher like the
+// handlers of Xash3D.On.
+func (c *Cvar) OnChange(handler func(value, old string)) (remove func()) {
+	return c.x.On(EventCvarChanged, func(e Event) {
+		if e.Cvar == c.name {
+			handler(e.Value, e.OldValue)
+		}
+	})
+}
+
+// service registers pending cvars, answers lookups and polls the values
+// of the Go cvars. It must run on the engine thread.
+func (r *cvarRegistry) service(events *eventDispatcher) {
+	if r.pending.Load() {
+		r.mu.Lock()
+		r.pending.Store(false)
+		registrations, lookups := r.registrations, r.lookups
+		r.registrations, r.lookups = nil, nil
+		r.mu.Unlock()
+
+		for _, c := range registrations {
+			value := C.CString(c.def)
+			desc := C.CString(c.description)
+			C.Cvar_Get(c.cName, value, C.int(c.flags), desc)
+			C.free(unsafe.Pointer(value))
+			C.free(unsafe.Pointer(desc))
+			c.registered = true
+			r.active.Store(true)
+		}
+		for _, l := range lookups {
+			l.reply <- engineCvar(l.name)
+		}
+	}
+
+	if !r.active.Load() {
+		return
+	}
+	now := time.Now().UnixNano()
+	if now-r.lastPoll.Load() < int64(cvarPollInterval) {
+		return
+	}
+	r.lastPoll.Store(now)
+	r.poll(events)
+}
+
+// poll reads the engine values of the Go cvars and reports changes.
+func (r *cvarRegistry) poll(events *eventDispatcher) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, c := range r.cvars {
+		if !c.registered {
+			continue
+		}
+		value := C.GoString(C.Cvar_VariableString(c.cName))
+		c.mu.Lock()
+		old := c.value
+		c.value = value
+		c.mu.Unlock()
+		if value != old && events.wants(EventCvarChanged) {
+			events.emit(Event{Kind: EventCvarChanged, Cvar: c.name, Value: value, OldValue: old})
+		}
+	}
+}
+
+// engineCvar reads a cvar on the engine thread.
+func engineCvar(name string) cvarValue {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	if C.Cvar_FindVarExt(cName, 0) == nil {
+		return cvarValue{}
+	}
+	return cvarValue{value: C.GoString(C.Cvar_VariableString(cName)), ok: true}
+}
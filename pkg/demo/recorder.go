@@ -0,0 +1,278 @@
+package demo
+
+import (
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"github.com/yohimik/goxash3d-fwgs/pkg/netchan"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configures a Recorder.
+type Options struct {
+	// Dir receives the demo files, one per map.
+	Dir string
+	// Name returns the file name of a demo, defaults to
+	// "<yyyymmdd-hhmmss>-<map>.dem".
+	Name func(mapName string, t time.Time) string
+	// GameDir is written into the demo header, defaults to "valve".
+	GameDir string
+	// HostFPS is written into the demo header, defaults to 60.
+	HostFPS float64
+	// MaxSignon bounds the bytes of signon messages kept for recordings
+	// started mid-map, defaults to 4 MiB.
+	MaxSignon int
+}
+
+type message struct {
+	at   time.Time
+	seq  Sequence
+	data []byte
+}
+
+// Recorder wraps a Xash3DNetwork and writes the server to client stream of
+// one watched peer into demo files. It keeps the signon of the current
+// map, so a recording started mid-map plays back from the map start state.
+type Recorder struct {
+	goxash3d_fwgs.Xash3DNetwork
+	opts Options
+
+	mu          sync.Mutex
+	peer        goxash3d_fwgs.Addr
+	watching    bool
+	ch          *netchan.Chan
+	splits      netchan.Reassembler
+	mapName     string
+	inGame      bool
+	signon      []message
+	signonBytes int
+
+	recording bool
+	file      *os.File
+	path      string
+	w         *Writer
+	start     time.Time
+	errors    int
+}
+
+var _ goxash3d_fwgs.PacketPusher = (*Recorder)(nil)
+
+// NewRecorder wraps the network. Recording starts with Watch and Start.
+func NewRecorder(net goxash3d_fwgs.Xash3DNetwork, opts Options) *Recorder {
+	if opts.Name == nil {
+		opts.Name = func(mapName string, t time.Time) string {
+			return t.Format("20060102-150405") + "-" + mapName + ".dem"
+		}
+	}
+	if opts.GameDir == "" {
+		opts.GameDir = "valve"
+	}
+	if opts.HostFPS == 0 {
+		opts.HostFPS = 60
+	}
+	if opts.MaxSignon <= 0 {
+		opts.MaxSignon = 4 << 20
+	}
+	return &Recorder{Xash3DNetwork: net, opts: opts}
+}
+
+// Watch selects the peer whose stream is recorded, typically a spectator.
+// Its signon is only known from the next map change or reconnect on.
+func (r *Recorder) Watch(addr goxash3d_fwgs.Addr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.peer = addr
+	r.watching = true
+	r.ch = netchan.NewChan(netchan.ClientToServer, 0)
+	r.splits = netchan.Reassembler{}
+	r.inGame = false
+	r.signon = nil
+	r.signonBytes = 0
+}
+
+// Start begins recording. A file is opened as soon as the map is known.
+func (r *Recorder) Start() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.recording {
+		return nil
+	}
+	r.recording = true
+	if r.mapName == "" {
+		return nil
+	}
+	return r.open()
+}
+
+// Stop ends recording and closes the current file.
+func (r *Recorder) Stop() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recording = false
+	return r.close()
+}
+
+// Path returns the file being written, or "" when no file is open.
+func (r *Recorder) Path() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.path
+}
+
+// Errors returns how many messages could not be written.
+func (r *Recorder) Errors() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.errors
+}
+
+// open starts a demo file of the current map with the cached signon.
+func (r *Recorder) open() error {
+	if err := os.MkdirAll(r.opts.Dir, 0o755); err != nil {
+		return err
+	}
+	r.start = time.Now()
+	name := filepath.Join(r.opts.Dir, r.opts.Name(r.mapName, r.start))
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w, err := NewWriter(file, Header{
+		NetProtocol: netchan.ProtocolVersion,
+		HostFPS:     r.opts.HostFPS,
+		MapName:     r.mapName,
+		GameDir:     r.opts.GameDir,
+	})
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file, r.path, r.w = file, name, w
+	for _, m := range r.signon {
+		if err := w.WriteMessage(0, true, m.seq, m.data); err != nil {
+			r.errors++
+		}
+	}
+	return nil
+}
+
+func (r *Recorder) close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.w.Close()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	r.file, r.path, r.w = nil, "", nil
+	return err
+}
+
+// observe feeds a packet sent to the watched peer into the recording.
+func (r *Recorder) observe(pkt goxash3d_fwgs.Packet) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.watching || pkt.Addr != r.peer {
+		return
+	}
+	raw := pkt.Data
+	if split, err := netchan.ParseSplit(raw); err == nil {
+		full, ok := r.splits.Add(split)
+		if !ok {
+			return
+		}
+		raw = full
+	}
+	decoded, ok := r.ch.Process(raw)
+	if !ok {
+		return
+	}
+	data := append(append([]byte(nil), decoded.Assembled...), decoded.Payload...)
+	if len(data) == 0 {
+		return
+	}
+	h := decoded.Header
+	m := message{
+		at: time.Now(),
+		seq: Sequence{
+			IncomingSequence:             h.Sequence,
+			IncomingAcknowledged:         h.Ack,
+			IncomingReliableAcknowledged: boolInt(h.AckReliable),
+			IncomingReliableSequence:     boolInt(h.Reliable),
+		},
+		data: data,
+	}
+
+	for _, msg := range decoded.Messages {
+		if msg.Op == netchan.SvcServerData && msg.ServerData != nil {
+			r.changeMap(mapName(msg.ServerData.MapName))
+		}
+	}
+	// the game starts with the first frame that carries no reliable data
+	startup := !r.inGame && (h.Reliable || decoded.Assembled != nil)
+	if !startup {
+		r.inGame = true
+	}
+	if startup && r.signonBytes+len(data) <= r.opts.MaxSignon {
+		r.signon = append(r.signon, m)
+		r.signonBytes += len(data)
+	}
+	if r.w == nil {
+		return
+	}
+	if err := r.w.WriteMessage(m.at.Sub(r.start), startup, m.seq, data); err != nil {
+		r.errors++
+	}
+}
+
+// changeMap resets the signon cache and rotates the recording.
+func (r *Recorder) changeMap(name string) {
+	r.mapName = name
+	r.inGame = false
+	r.signon = nil
+	r.signonBytes = 0
+	if !r.recording {
+		return
+	}
+	if err := r.close(); err != nil {
+		r.errors++
+	}
+	if err := r.open(); err != nil {
+		r.errors++
+	}
+}
+
+func boolInt(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// mapName turns "maps/crossfire.bsp" into "crossfire".
+func mapName(p string) string {
+	return strings.TrimSuffix(path.Base(p), path.Ext(p))
+}
+
+// PushPacket forwards an inbound packet to the wrapped network, which must
+// implement goxash3d_fwgs.PacketPusher.
+func (r *Recorder) PushPacket(pkt goxash3d_fwgs.Packet) {
+	r.Xash3DNetwork.(goxash3d_fwgs.PacketPusher).PushPacket(pkt)
+}
+
+// SendTo records the packet and sends it through the wrapped network.
+func (r *Recorder) SendTo(fd int, pkt goxash3d_fwgs.Packet, flags int) int {
+	r.observe(pkt)
+	return r.Xash3DNetwork.SendTo(fd, pkt, flags)
+}
+
+// SendToBatch records the packets and sends them through the wrapped network.
+func (r *Recorder) SendToBatch(fd int, packets []goxash3d_fwgs.Packet, flags int) int {
+	for _, pkt := range packets {
+		r.observe(pkt)
+	}
+	return r.Xash3DNetwork.SendToBatch(fd, packets, flags)
+}
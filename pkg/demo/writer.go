@@ -0,0 +1,205 @@
+package demo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+var ErrClosed = errors.New("demo: writer closed")
+
+// Header values of the engine demo format.
+const (
+	// Magic is "IDEM" read as a little-endian int.
+	Magic         = 'I' | 'D'<<8 | 'E'<<16 | 'M'<<24
+	Protocol      = 3
+	headerNameLen = 64
+	headerLen     = 4 + 4 + 4 + 8 + 3*headerNameLen + 4
+)
+
+// Demo commands.
+const (
+	cmdNoRewind = 1
+	cmdRead     = 2
+	cmdStop     = 6
+)
+
+// Directory entry types.
+const (
+	entryStartup = 0
+	entryNormal  = 1
+)
+
+// Header describes the recorded game.
+type Header struct {
+	NetProtocol int
+	HostFPS     float64
+	// MapName is the bare map name, e.g. "crossfire".
+	MapName string
+	Comment string
+	GameDir string
+}
+
+// Sequence is the netchan state stored with every network message.
+type Sequence struct {
+	IncomingSequence             uint32
+	IncomingAcknowledged         uint32
+	IncomingReliableAcknowledged uint32
+	IncomingReliableSequence     uint32
+	OutgoingSequence             uint32
+	ReliableSequence             uint32
+	LastReliableSequence         uint32
+}
+
+// File is the destination of a Writer. The header is patched with WriteAt
+// once the directory is written, *os.File implements it.
+type File interface {
+	io.Writer
+	io.WriterAt
+}
+
+type entry struct {
+	typ    int32
+	name   string
+	time   float32
+	frames int32
+	offset int32
+	length int32
+}
+
+// Writer writes a demo: a startup segment holding the signon messages and
+// a normal segment holding the game, followed by the segment directory.
+type Writer struct {
+	f      File
+	w      *bufio.Writer
+	offset int64
+
+	entries []entry
+	frame   int32
+	last    time.Duration
+	closed  bool
+}
+
+// NewWriter writes the demo header and opens the startup segment.
+func NewWriter(f File, h Header) (*Writer, error) {
+	w := &Writer{f: f, w: bufio.NewWriter(f)}
+	b := make([]byte, 0, headerLen)
+	b = binary.LittleEndian.AppendUint32(b, Magic)
+	b = binary.LittleEndian.AppendUint32(b, Protocol)
+	b = binary.LittleEndian.AppendUint32(b, uint32(h.NetProtocol))
+	b = binary.LittleEndian.AppendUint64(b, math.Float64bits(h.HostFPS))
+	b = appendName(b, h.MapName)
+	b = appendName(b, h.Comment)
+	b = appendName(b, h.GameDir)
+	b = binary.LittleEndian.AppendUint32(b, 0) // directory offset, patched on Close
+	if err := w.write(b); err != nil {
+		return nil, err
+	}
+	w.entries = append(w.entries, entry{typ: entryStartup, name: "LOADING", offset: int32(w.offset)})
+	return w, nil
+}
+
+func appendName(b []byte, s string) []byte {
+	name := make([]byte, headerNameLen)
+	copy(name[:headerNameLen-1], s)
+	return append(b, name...)
+}
+
+func (w *Writer) write(b []byte) error {
+	n, err := w.w.Write(b)
+	w.offset += int64(n)
+	return err
+}
+
+// WriteMessage appends a server message stream received at t (relative
+// to the start of the recording). Startup messages must precede the game;
+// the first game message closes the startup segment.
+func (w *Writer) WriteMessage(t time.Duration, startup bool, seq Sequence, data []byte) error {
+	if w.closed {
+		return ErrClosed
+	}
+	cmd := byte(cmdNoRewind)
+	if !startup {
+		cmd = cmdRead
+		if len(w.entries) == 1 {
+			if err := w.nextSegment(t); err != nil {
+				return err
+			}
+		}
+	}
+	b := w.cmdHeader(cmd, t)
+	for _, v := range []uint32{
+		seq.IncomingSequence, seq.IncomingAcknowledged, seq.IncomingReliableAcknowledged,
+		seq.IncomingReliableSequence, seq.OutgoingSequence, seq.ReliableSequence, seq.LastReliableSequence,
+	} {
+		b = binary.LittleEndian.AppendUint32(b, v)
+	}
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(data)))
+	b = append(b, data...)
+	return w.write(b)
+}
+
+func (w *Writer) cmdHeader(cmd byte, t time.Duration) []byte {
+	w.frame++
+	w.last = t
+	b := []byte{cmd}
+	b = binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(t.Seconds())))
+	return binary.LittleEndian.AppendUint32(b, uint32(w.frame))
+}
+
+// nextSegment ends the current segment with a stop command and opens the normal one.
+func (w *Writer) nextSegment(t time.Duration) error {
+	err := w.endSegment(t)
+	w.entries = append(w.entries, entry{typ: entryNormal, name: "Playback", offset: int32(w.offset)})
+	return err
+}
+
+func (w *Writer) endSegment(t time.Duration) error {
+	err := w.write(w.cmdHeader(cmdStop, t))
+	e := &w.entries[len(w.entries)-1]
+	e.time = float32(t.Seconds())
+	e.frames = w.frame
+	e.length = int32(w.offset) - e.offset
+	return err
+}
+
+// Close ends the open segment, writes the directory and patches the header.
+// It does not close the underlying file.
+func (w *Writer) Close() error {
+	if w.closed {
+		return ErrClosed
+	}
+	w.closed = true
+	if len(w.entries) == 1 {
+		// a demo without game frames still needs both segments
+		if err := w.nextSegment(w.last); err != nil {
+			return err
+		}
+	}
+	if err := w.endSegment(w.last); err != nil {
+		return err
+	}
+
+	directory := w.offset
+	b := binary.LittleEndian.AppendUint32(nil, uint32(len(w.entries)))
+	for _, e := range w.entries {
+		b = binary.LittleEndian.AppendUint32(b, uint32(e.typ))
+		b = binary.LittleEndian.AppendUint32(b, math.Float32bits(e.time))
+		b = binary.LittleEndian.AppendUint32(b, uint32(e.frames))
+		b = binary.LittleEndian.AppendUint32(b, uint32(e.offset))
+		b = binary.LittleEndian.AppendUint32(b, uint32(e.length))
+		b = binary.LittleEndian.AppendUint32(b, 0) // flags
+		b = appendName(b, e.name)
+	}
+	if err := w.write(b); err != nil {
+		return err
+	}
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	_, err := w.f.WriteAt(binary.LittleEndian.AppendUint32(nil, uint32(directory)), headerLen-4)
+	return err
+}
@@ -0,0 +1,374 @@
+package goxash3d_fwgs
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// eventBacklog bounds the events waiting for slow handlers.
+const eventBacklog = 1024
+
+// EventKind is a point of the server lifecycle.
+type EventKind uint8
+
+const (
+	// EventServerActivated fires once the server spawned a level and accepts clients.
+	EventServerActivated EventKind = iota + 1
+	// EventMapLoaded fires when a level finished loading, before it is activated.
+	EventMapLoaded
+	EventClientConnect
+	EventClientDisconnect
+	// EventClientPutInServer fires when a client finished the signon and entered the game.
+	EventClientPutInServer
+	// EventClientCommand fires for every string command a client sends.
+	EventClientCommand
+	EventFrameStart
+	EventFrameEnd
+	EventShutdown
+	// EventCvarChanged fires when the value of a cvar registered from Go changed.
+	EventCvarChanged
+	// EventClientState fires about ten times a second for every client in the game.
+	EventClientState
+
+	eventKinds
+)
+
+// String returns the name of the event kind.
+func (k EventKind) String() string {
+	switch k {
+	case EventServerActivated:
+		return "server_activated"
+	case EventMapLoaded:
+		return "map_loaded"
+	case EventClientConnect:
+		return "client_connect"
+	case EventClientDisconnect:
+		return "client_disconnect"
+	case EventClientPutInServer:
+		return "client_put_in_server"
+	case EventClientCommand:
+		return "client_command"
+	case EventFrameStart:
+		return "frame_start"
+	case EventFrameEnd:
+		return "frame_end"
+	case EventShutdown:
+		return "shutdown"
+	case EventCvarChanged:
+		return "cvar_changed"
+	case EventClientState:
+		return "client_state"
+	default:
+		return "unknown"
+	}
+}
+
+// Client identifies the engine client of an event.
+type Client struct {
+	// Slot is the zero based client slot.
+	Slot   int
+	UserID int
+	Name   string
+	// Addr is the (virtual) address of the client.
+	Addr Addr
+}
+
+// ClientState is the game state of a client in EventClientState.
+type ClientState struct {
+	// Alive is false for dead players and spectators.
+	Alive bool
+	// Team and Model are the keys of the server side userinfo, which the
+	// game rules update, e.g. on a team change.
+	Team  string
+	Model string
+	// Origin is the position in world units.
+	Origin [3]float32
+}
+
+// Event is a server lifecycle event. Only the fields of its kind are set.
+type Event struct {
+	Kind EventKind
+	// Time is when the engine reported the event.
+	Time time.Time
+	// Map is the level of EventServerActivated and EventMapLoaded.
+	Map string
+	// MaxClients is the client limit of EventServerActivated.
+	MaxClients int
+	// Client is the player of the client events.
+	Client Client
+	// Command is the command line of EventClientCommand.
+	Command string
+	// Reason is the drop reason of EventClientDisconnect.
+	Reason string
+	// EngineTime is the engine clock of frame events, in seconds.
+	EngineTime float64
+	// Cvar, Value and OldValue describe EventCvarChanged.
+	Cvar     string
+	Value    string
+	OldValue string
+	// State is the game state of EventClientState.
+	State ClientState
+}
+
+// EventHandler handles lifecycle events.
+type EventHandler func(Event)
+
+// eventDispatcher copies engine events into a queue and runs the handlers
+// on its own goroutine, so a slow handler never stalls the engine frame.
+type eventDispatcher struct {
+	// active counts the handlers per kind, letting the engine skip
+	// events nobody listens to without taking a lock
+	active [eventKinds]atomic.Int32
+
+	mu       sync.RWMutex
+	handlers [eventKinds][]*EventHandler
+
+	start   sync.Once
+	queue   chan Event
+	dropped atomic.Uint64
+}
+
+// On registers a handler for the kind of events and returns a function
+// removing it. Handlers run one at a time in event order on a dispatcher
+// goroutine, never on the engine thread. Events are dropped while the
+// handlers are more than a backlog behind; see DroppedEvents. Unknown kinds
+// are ignored.
+func (x *Xash3D) On(kind EventKind, handler EventHandler) (remove func()) {
+	if kind == 0 || kind >= eventKinds || handler == nil {
+		return func() {}
+	}
+	d := &x.events
+	d.start.Do(func() {
+		d.queue = make(chan Event, eventBacklog)
+		go d.run()
+	})
+
+	h := &handler
+	d.mu.Lock()
+	d.handlers[kind] = append(d.handlers[kind], h)
+	d.active[kind].Add(1)
+	d.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			for i, other := range d.handlers[kind] {
+				if other == h {
+					d.handlers[kind] = append(d.handlers[kind][:i:i], d.handlers[kind][i+1:]...)
+					d.active[kind].Add(-1)
+					return
+				}
+			}
+		})
+	}
+}
+
+// DroppedEvents returns the number of events dropped because handlers fell behind.
+func (x *Xash3D) DroppedEvents() uint64 {
+	return x.events.dropped.Load()
+}
+
+// wants reports whether any handler listens to the kind.
+func (d *eventDispatcher) wants(kind EventKind) bool {
+	return d.active[kind].Load() > 0
+}
+
+// emit queues an event without blocking.
+func (d *eventDispatcher) emit(e Event) {
+	e.Time = time.Now()
+	select {
+	case d.queue <- e:
+	default:
+		d.dropped.Add(1)
+	}
+}
+
+func (d *eventDispatcher) run() {
+	for e := range d.queue {
+		d.mu.RLock()
+		handlers := d.handlers[e.Kind]
+		d.mu.RUnlock()
+		for _, h := range handlers {
+			d.call(*h, e)
+		}
+	}
+}
+
+// call runs a handler, a panicking handler must not take the server down.
+func (d *eventDispatcher) call(h EventHandler, e Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "goxash3d_fwgs: %s handler panicked: %v\n%s", e.Kind, r, debug.Stack())
+		}
+	}()
+	h(e)
+}
+
+// The engine reports lifecycle events through the lib_sv_* and lib_host_*
+// functions below, declared on the engine side as:
+//
+//	void lib_sv_activate( const char *mapname, int maxclients );
+//	void lib_sv_map_loaded( const char *mapname );
+//	void lib_sv_client_connect( int slot, int userid, const char *name, const char *address );
+//	void lib_sv_client_disconnect( int slot, int userid, const char *name, const char *address, const char *reason );
+//	void lib_sv_client_put_in_server( int slot, int userid, const char *name, const char *address );
+//	void lib_sv_client_state( int slot, int userid, const char *address, int alive, const char *team, const char *model, float x, float y, float z );
+//	int  lib_sv_client_command( int slot, int userid, const char *name, const char *address, const char *cmd );
+//	void lib_host_frame_start( double time );
+//	void lib_host_frame_end( double time );
+//	void lib_host_shutdown( void );
+//
+// They copy their arguments and return at once. The engine fork only calls
+// them with patches/xash3d-fwgs applied, without it no lifecycle events or
+// client commands are reported.
+
+// lib_sv_activate reports the server activation of a level.
+//
+//export lib_sv_activate
+func lib_sv_activate(mapname *C.char, maxclients C.int) {
+	d := &DefaultXash3D.events
+	if d.wants(EventServerActivated) {
+		d.emit(Event{Kind: EventServerActivated, Map: C.GoString(mapname), MaxClients: int(maxclients)})
+	}
+}
+
+// lib_sv_map_loaded reports a loaded level.
+//
+//export lib_sv_map_loaded
+func lib_sv_map_loaded(mapname *C.char) {
+	d := &DefaultXash3D.events
+	if d.wants(EventMapLoaded) {
+		d.emit(Event{Kind: EventMapLoaded, Map: C.GoString(mapname)})
+	}
+}
+
+// eventClient converts the client arguments of the engine hooks.
+func eventClient(slot, userid C.int, name, address *C.char) Client {
+	c := Client{Slot: int(slot), UserID: int(userid)}
+	if name != nil {
+		c.Name = C.GoString(name)
+	}
+	if address != nil {
+		c.Addr, _ = ParseAddr(C.GoString(address))
+	}
+	return c
+}
+
+// lib_sv_client_connect reports an accepted connection.
+//
+//export lib_sv_client_connect
+func lib_sv_client_connect(slot, userid C.int, name, address *C.char) {
+	d := &DefaultXash3D.events
+	if d.wants(EventClientConnect) {
+		d.emit(Event{Kind: EventClientConnect, Client: eventClient(slot, userid, name, address)})
+	}
+}
+
+// lib_sv_client_disconnect reports a dropped client.
+//
+//export lib_sv_client_disconnect
+func lib_sv_client_disconnect(slot, userid C.int, name, address, reason *C.char) {
+	d := &DefaultXash3D.events
+	if d.wants(EventClientDisconnect) {
+		e := Event{Kind: EventClientDisconnect, Client: eventClient(slot, userid, name, address)}
+		if reason != nil {
+			e.Reason = C.GoString(reason)
+		}
+		d.emit(e)
+	}
+}
+
+// lib_sv_client_put_in_server reports a client entering the game.
+//
+//export lib_sv_client_put_in_server
+func lib_sv_client_put_in_server(slot, userid C.int, name, address *C.char) {
+	d := &DefaultXash3D.events
+	if d.wants(EventClientPutInServer) {
+		d.emit(Event{Kind: EventClientPutInServer, Client: eventClient(slot, userid, name, address)})
+	}
+}
+
+// lib_sv_client_state reports the game state of a client in the game.
+//
+//export lib_sv_client_state
+func lib_sv_client_state(slot, userid C.int, address *C.char, alive C.int, team, model *C.char, x, y, z C.float) {
+	d := &DefaultXash3D.events
+	if !d.wants(EventClientState) {
+		return
+	}
+	e := Event{Kind: EventClientState, Client: eventClient(slot, userid, nil, address)}
+	e.State = ClientState{Alive: alive != 0, Origin: [3]float32{float32(x), float32(y), float32(z)}}
+	if team != nil {
+		e.State.Team = C.GoString(team)
+	}
+	if model != nil {
+		e.State.Model = C.GoString(model)
+	}
+	d.emit(e)
+}
+
+// lib_sv_client_command reports a client string command, passes it through
+// the client command filters and runs it when it names a Go command open
+// to clients. The result tells the engine what to do with the command:
+// 0 runs it, 1 drops it and 2 runs lib_sv_client_rewrite instead. Either
+// way the engine then prints lib_sv_client_reply to the client.
+//
+//export lib_sv_client_command
+func lib_sv_client_command(slot, userid C.int, name, address, cmd *C.char) C.int {
+	client := eventClient(slot, userid, name, address)
+	line := C.GoString(cmd)
+	d := &DefaultXash3D.events
+	if d.wants(EventClientCommand) {
+		d.emit(Event{Kind: EventClientCommand, Client: client, Command: line})
+	}
+	return C.int(DefaultXash3D.clientCommands.handle(&DefaultXash3D.console, client, line))
+}
+
+// lib_host_frame_start reports the start of an engine frame.
+//
+//export lib_host_frame_start
+func lib_host_frame_start(engineTime C.double) {
+	d := &DefaultXash3D.events
+	if d.wants(EventFrameStart) {
+		d.emit(Event{Kind: EventFrameStart, EngineTime: float64(engineTime)})
+	}
+}
+
+// lib_host_frame_end reports the end of an engine frame.
+//
+//export lib_host_frame_end
+func lib_host_frame_end(engineTime C.double) {
+	d := &DefaultXash3D.events
+	if d.wants(EventFrameEnd) {
+		d.emit(Event{Kind: EventFrameEnd, EngineTime: float64(engineTime)})
+	}
+}
+
+// lib_host_shutdown reports the engine shutdown. It waits briefly for the
+// handlers to see the event, since the process exits afterwards.
+//
+//export lib_host_shutdown
+func lib_host_shutdown() {
+	d := &DefaultXash3D.events
+	if !d.wants(EventShutdown) {
+		return
+	}
+	done := make(chan struct{})
+	remove := DefaultXash3D.On(EventShutdown, func(Event) { close(done) })
+	defer remove()
+	d.emit(Event{Kind: EventShutdown})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+	}
+}
@@ -0,0 +1,172 @@
+// Package fastdl serves the game directory read-only over HTTP as an
+// sv_downloadurl endpoint, so clients fetch maps, models and sounds from the
+// web server instead of through the engine's netchan download.
+//
+// Only files with an allowlisted extension are served. Precompressed
+// variants are served as they are stored, a request for maps/foo.bsp.bz2
+// returns that file when it exists. Range and conditional requests are
+// supported and responses carry cache headers.
+package fastdl
+
+import (
+	"errors"
+	"fmt"
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+var ErrBadURL = errors.New("fastdl: download url must be an absolute http(s) url without quotes")
+
+// DefaultExtensions are the resource types a client downloads on its own.
+var DefaultExtensions = []string{".bsp", ".mdl", ".spr", ".wav", ".mp3", ".wad", ".tga", ".bmp", ".res"}
+
+// compressed is the extension of the precompressed variants.
+const compressed = ".bz2"
+
+// Options configures a Server.
+type Options struct {
+	// Dirs are searched in order, e.g. the mod directory before valve.
+	Dirs []string
+	// Extensions are the served file types, DefaultExtensions by default.
+	Extensions []string
+	// MaxAge is the Cache-Control max-age of responses, an hour by default.
+	MaxAge time.Duration
+	// URL is the public address of the server. When set it is announced to
+	// the engine as sv_downloadurl.
+	URL string
+	// Exec runs console commands, DefaultXash3D.ExecCommand by default.
+	Exec func(cmd string)
+}
+
+// Server is the download HTTP handler.
+type Server struct {
+	roots      []*os.Root
+	extensions map[string]bool
+	maxAge     time.Duration
+}
+
+// New opens the directories and announces the download url.
+func New(opts Options) (*Server, error) {
+	if opts.Extensions == nil {
+		opts.Extensions = DefaultExtensions
+	}
+	if opts.MaxAge == 0 {
+		opts.MaxAge = time.Hour
+	}
+	if opts.Exec == nil {
+		opts.Exec = goxash3d_fwgs.DefaultXash3D.ExecCommand
+	}
+	s := &Server{
+		extensions: make(map[string]bool, len(opts.Extensions)),
+		maxAge:     opts.MaxAge,
+	}
+	for _, ext := range opts.Extensions {
+		s.extensions[strings.ToLower(ext)] = true
+	}
+	for _, dir := range opts.Dirs {
+		root, err := os.OpenRoot(dir)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.roots = append(s.roots, root)
+	}
+	if opts.URL != "" {
+		if err := SetDownloadURL(opts.Exec, opts.URL); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Close releases the directories.
+func (s *Server) Close() error {
+	var errs []error
+	for _, root := range s.roots {
+		errs = append(errs, root.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// SetDownloadURL points the engine sv_downloadurl at url.
+func SetDownloadURL(exec func(cmd string), url string) error {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") ||
+		strings.ContainsAny(url, "\"\r\n;") {
+		return ErrBadURL
+	}
+	// clients append "/<file>" to the url
+	exec(`sv_downloadurl "` + strings.TrimRight(url, "/") + `"`)
+	return nil
+}
+
+// allowed reports whether the cleaned name may be served.
+func (s *Server) allowed(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	ext := path.Ext(strings.TrimSuffix(strings.ToLower(name), compressed))
+	return s.extensions[ext]
+}
+
+// open finds name in the directories. Clients of case-insensitive systems
+// may ask with other casing, so the lower-case name is tried as well.
+func (s *Server) open(name string) (*os.File, fs.FileInfo, error) {
+	names := []string{name}
+	if lower := strings.ToLower(name); lower != name {
+		names = append(names, lower)
+	}
+	for _, root := range s.roots {
+		for _, n := range names {
+			f, err := root.Open(n)
+			if err != nil {
+				continue
+			}
+			info, err := f.Stat()
+			if err != nil || !info.Mode().IsRegular() {
+				_ = f.Close()
+				continue
+			}
+			return f, info, nil
+		}
+	}
+	return nil, nil, fs.ErrNotExist
+}
+
+// ServeHTTP serves a resource file, the url path is relative to the directories.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if !s.allowed(name) {
+		http.NotFound(w, r)
+		return
+	}
+	f, info, err := s.open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	h := w.Header()
+	h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(s.maxAge.Seconds())))
+	h.Set("ETag", fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano()))
+	h.Set("X-Content-Type-Options", "nosniff")
+	if strings.HasSuffix(strings.ToLower(name), compressed) {
+		h.Set("Content-Type", "application/x-bzip2")
+	} else {
+		h.Set("Content-Type", "application/octet-stream")
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
@@ -0,0 +1,162 @@
+package goxash3d_fwgs
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrLocalPeerClosed is returned by LocalPeer reads and writes after Close.
+var ErrLocalPeerClosed = errors.New("goxash3d_fwgs: local peer closed")
+
+// LocalPeerPort is the port of the virtual addresses handed to local peers.
+const LocalPeerPort = 27005
+
+// localPeerInbox bounds the packets buffered for a local peer that does not read.
+const localPeerInbox = 256
+
+// LocalNet wraps a Xash3DNetwork with an in-process transport: Go code
+// dials the engine through LocalPeer connections next to the peers of the
+// wrapped network, without any real network in between.
+type LocalNet struct {
+	Xash3DNetwork
+
+	mu    sync.RWMutex
+	peers map[Addr]*LocalPeer
+	next  uint32
+}
+
+// NewLocalNet wraps the network. Inbound packets of local peers are pushed
+// into the wrapped network, which therefore must implement PacketPusher.
+func NewLocalNet(net Xash3DNetwork) *LocalNet {
+	return &LocalNet{
+		Xash3DNetwork: net,
+		peers:         make(map[Addr]*LocalPeer),
+	}
+}
+
+// Dial creates a local peer with a fresh virtual address in 127.0.0.0/8.
+func (n *LocalNet) Dial() *LocalPeer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.next++
+	id := n.next
+	peer := &LocalPeer{
+		net: n,
+		addr: Addr{
+			IP:   [4]byte{127, byte(id >> 16), byte(id >> 8), byte(id)},
+			Port: LocalPeerPort,
+		},
+		inbox:  make(chan []byte, localPeerInbox),
+		closed: make(chan struct{}),
+	}
+	n.peers[peer.addr] = peer
+	return peer
+}
+
+// Peer returns the local peer with the given address.
+func (n *LocalNet) Peer(addr Addr) (*LocalPeer, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	peer, ok := n.peers[addr]
+	return peer, ok
+}
+
+// PushPacket forwards an inbound packet to the wrapped network.
+func (n *LocalNet) PushPacket(pkt Packet) {
+	n.Xash3DNetwork.(PacketPusher).PushPacket(pkt)
+}
+
+// SendTo delivers packets addressed to local peers and sends the rest
+// through the wrapped network.
+func (n *LocalNet) SendTo(fd int, pkt Packet, flags int) int {
+	if peer, ok := n.Peer(pkt.Addr); ok {
+		peer.deliver(pkt.Data)
+		return len(pkt.Data)
+	}
+	return n.Xash3DNetwork.SendTo(fd, pkt, flags)
+}
+
+// SendToBatch delivers packets addressed to local peers and sends the rest
+// through the wrapped network.
+func (n *LocalNet) SendToBatch(fd int, packets []Packet, flags int) int {
+	n.mu.RLock()
+	local := len(n.peers) > 0
+	n.mu.RUnlock()
+	if !local {
+		return n.Xash3DNetwork.SendToBatch(fd, packets, flags)
+	}
+	remote := make([]Packet, 0, len(packets))
+	sent := 0
+	for _, pkt := range packets {
+		if peer, ok := n.Peer(pkt.Addr); ok {
+			peer.deliver(pkt.Data)
+			sent += len(pkt.Data)
+			continue
+		}
+		remote = append(remote, pkt)
+	}
+	if len(remote) > 0 {
+		ret := n.Xash3DNetwork.SendToBatch(fd, remote, flags)
+		if ret == -1 {
+			return -1
+		}
+		sent += ret
+	}
+	return sent
+}
+
+// LocalPeer is an in-process connection to the engine. Each Read returns
+// one datagram sent by the engine and each Write pushes one datagram.
+type LocalPeer struct {
+	net    *LocalNet
+	addr   Addr
+	inbox  chan []byte
+	closed chan struct{}
+	once   sync.Once
+}
+
+// Addr returns the virtual address the engine sees for the peer.
+func (p *LocalPeer) Addr() Addr {
+	return p.addr
+}
+
+// Read receives the next datagram from the engine.
+// Datagrams longer than b are truncated.
+func (p *LocalPeer) Read(b []byte) (int, error) {
+	select {
+	case data := <-p.inbox:
+		return copy(b, data), nil
+	case <-p.closed:
+		return 0, ErrLocalPeerClosed
+	}
+}
+
+// Write pushes a datagram to the engine.
+func (p *LocalPeer) Write(b []byte) (int, error) {
+	select {
+	case <-p.closed:
+		return 0, ErrLocalPeerClosed
+	default:
+	}
+	p.net.PushPacket(Packet{Data: append([]byte(nil), b...), Addr: p.addr})
+	return len(b), nil
+}
+
+// Close detaches the peer from the network.
+func (p *LocalPeer) Close() error {
+	p.once.Do(func() {
+		p.net.mu.Lock()
+		delete(p.net.peers, p.addr)
+		p.net.mu.Unlock()
+		close(p.closed)
+	})
+	return nil
+}
+
+// deliver copies engine data into the inbox, dropping it when the peer lags.
+func (p *LocalPeer) deliver(data []byte) {
+	select {
+	case p.inbox <- append([]byte(nil), data...):
+	default:
+	}
+}
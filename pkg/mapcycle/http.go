@@ -0,0 +1,106 @@
+package mapcycle
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// HandlerOptions configures Manager.Handler.
+type HandlerOptions struct {
+	// Authorize returns the name of the caller, or false to reject the
+	// request. Without it the handler is read-only.
+	Authorize func(r *http.Request) (string, bool)
+	// Audit is called for every accepted change before it applies, it may be nil.
+	Audit func(r *http.Request, by, change string)
+}
+
+// state is the JSON document served by Handler.
+type state struct {
+	Current  string `json:"current"`
+	Next     string `json:"next"`
+	Rotation []Map  `json:"rotation"`
+	Vote     *Vote  `json:"vote,omitempty"`
+}
+
+// change is the body accepted by Handler.
+type change struct {
+	// Next sets the next map.
+	Next string `json:"next,omitempty"`
+	// Map changes the level now.
+	Map string `json:"map,omitempty"`
+	// Rotation replaces the rotation.
+	Rotation []Map `json:"rotation,omitempty"`
+}
+
+// Handler serves the current map, the next map, the rotation and the running
+// vote as JSON. Authorized callers POST or PUT a body like {"next":"crossfire"},
+// {"map":"bounce"} or {"rotation":[{"name":"crossfire"}]} to change them.
+func (m *Manager) Handler(opts HandlerOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			if opts.Authorize == nil {
+				http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+				return
+			}
+			by, ok := opts.Authorize(r)
+			if !ok {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			var c change
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&c); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := c.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if opts.Audit != nil {
+				body, _ := json.Marshal(c)
+				opts.Audit(r, by, string(body))
+			}
+			if c.Rotation != nil {
+				_ = m.SetRotation(c.Rotation)
+			}
+			if c.Next != "" {
+				_ = m.SetNext(c.Next)
+			}
+			if c.Map != "" {
+				_ = m.ChangeLevel(c.Map)
+			}
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		out := state{Current: m.Current(), Next: m.Next(), Rotation: m.Rotation()}
+		if v, ok := m.CurrentVote(); ok {
+			out.Vote = &v
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	})
+}
+
+// validate checks all names up front, so a change applies entirely or not at all.
+func (c change) validate() error {
+	if c.Next != "" && !ValidName(c.Next) {
+		return ErrInvalidMap
+	}
+	if c.Map != "" && !ValidName(c.Map) {
+		return ErrInvalidMap
+	}
+	for _, mp := range c.Rotation {
+		if !ValidName(mp.Name) {
+			return ErrInvalidMap
+		}
+	}
+	if c.Next == "" && c.Map == "" && c.Rotation == nil {
+		return errors.New("mapcycle: empty change")
+	}
+	return nil
+}
@@ -0,0 +1,499 @@
+// Package mapcycle owns the map rotation on the Go side.
+//
+// A Manager picks the next map from the rotation, sequentially or weighted
+// at random, from the pool that fits the current player count. Players
+// vote with chat commands: "rtv" rocks the vote, "nominate <map>" adds a
+// map to the next vote and a number picks an option while a vote runs.
+// At the end of the map the manager switches levels with changelevel
+// through Xash3D.ExecCommand.
+//
+// The Manager learns about map changes from the engine (see Follow) and is a
+// Xash3DNetwork decorator detecting the intermission in server packets. Chat commands reach it through the chat
+// pipeline (see ChatHandler), after mutes and flood limits had their say.
+package mapcycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"github.com/yohimik/goxash3d-fwgs/pkg/chat"
+	"github.com/yohimik/goxash3d-fwgs/pkg/netchan"
+	"github.com/yohimik/goxash3d-fwgs/pkg/players"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidMap is returned for map names that are not a single word of
+// letters, digits, '_', '-' and '.', they must not reach the console.
+var ErrInvalidMap = errors.New("mapcycle: invalid map name")
+
+// Options configures a Manager.
+type Options struct {
+	Order Order
+	// Start is the map the server starts with.
+	Start string
+	// TimeLimit ends the map after the duration, 0 waits for the intermission
+	// of the game rules (mp_timelimit, mp_fraglimit).
+	TimeLimit time.Duration
+	// ChatTime is how long the intermission lasts before the level changes,
+	// 5s by default. Keep mp_chattime above it so the engine does not change
+	// to its own mapcycle first.
+	ChatTime time.Duration
+	// RTVRatio is the share of players needed to rock the vote, 0.6 by default.
+	RTVRatio float64
+	// VoteDuration is how long a vote runs, 30s by default.
+	VoteDuration time.Duration
+	// VoteOptions is the number of maps in a vote, 5 by default.
+	VoteOptions int
+	// Exec runs console commands, DefaultXash3D.ExecCommand by default.
+	Exec func(cmd string)
+	// Players counts the connected players, players.Default by default.
+	Players func() int
+}
+
+// Vote is a running map vote.
+type Vote struct {
+	Options  []string  `json:"options"`
+	Tally    []int     `json:"tally"`
+	Deadline time.Time `json:"deadline"`
+
+	ballots map[goxash3d_fwgs.Addr]int
+}
+
+// Manager owns the rotation, the next map and the votes.
+type Manager struct {
+	goxash3d_fwgs.Xash3DNetwork
+
+	opts Options
+
+	mu          sync.Mutex
+	rotation    []Map
+	current     string
+	next        string
+	started     time.Time
+	ending      bool
+	rtv         map[goxash3d_fwgs.Addr]bool
+	nominations []string
+	nominators  map[goxash3d_fwgs.Addr]string
+	vote        *Vote
+	// following is set once the engine reported an activation, server
+	// data in packets is ignored from then on
+	following bool
+}
+
+// New wraps the network with a Manager for the rotation. Maps with an
+// invalid name are left out of the rotation.
+func New(net goxash3d_fwgs.Xash3DNetwork, rotation []Map, opts Options) *Manager {
+	if opts.ChatTime == 0 {
+		opts.ChatTime = 5 * time.Second
+	}
+	if opts.RTVRatio == 0 {
+		opts.RTVRatio = 0.6
+	}
+	if opts.VoteDuration == 0 {
+		opts.VoteDuration = 30 * time.Second
+	}
+	if opts.VoteOptions == 0 {
+		opts.VoteOptions = 5
+	}
+	if opts.Exec == nil {
+		opts.Exec = goxash3d_fwgs.DefaultXash3D.ExecCommand
+	}
+	if opts.Players == nil {
+		opts.Players = connectedPlayers
+	}
+	m := &Manager{
+		Xash3DNetwork: net,
+		opts:          opts,
+		rotation:      validMaps(rotation),
+	}
+	m.start(opts.Start)
+	return m
+}
+
+func connectedPlayers() int {
+	count := 0
+	for _, p := range players.Default.Players() {
+		if p.Connected {
+			count++
+		}
+	}
+	return count
+}
+
+// start resets the state for a new map. Caller holds mu or owns m.
+func (m *Manager) start(name string) {
+	m.current = name
+	m.started = time.Now()
+	m.ending = false
+	m.rtv = make(map[goxash3d_fwgs.Addr]bool)
+	m.nominations = nil
+	m.nominators = make(map[goxash3d_fwgs.Addr]string)
+	m.vote = nil
+	m.next = ""
+	if next, ok := pick(m.rotation, m.opts.Order, name, m.opts.Players()); ok {
+		m.next = next.Name
+	}
+}
+
+// MapStarted tells the manager that the engine loaded a map, e.g. after a
+// changelevel from the console. Follow calls it for every activation.
+func (m *Manager) MapStarted(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ending || name != m.current {
+		m.start(name)
+	}
+}
+
+// Follow starts every map the engine activates, including restarts of the
+// same map and changes while nobody is connected. It returns a function
+// that stops following. Without the engine events the manager falls back
+// to the server data of unfragmented packets.
+func (m *Manager) Follow(x *goxash3d_fwgs.Xash3D) (stop func()) {
+	return x.On(goxash3d_fwgs.EventServerActivated, func(e goxash3d_fwgs.Event) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.following = true
+		m.start(e.Map)
+	})
+}
+
+// Current returns the running map.
+func (m *Manager) Current() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.current
+}
+
+// Next returns the map that follows the current one.
+func (m *Manager) Next() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.next
+}
+
+// SetNext overrides the next map.
+func (m *Manager) SetNext(name string) error {
+	if !ValidName(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidMap, name)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.next = name
+	return nil
+}
+
+// Rotation returns a copy of the rotation.
+func (m *Manager) Rotation() []Map {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Map(nil), m.rotation...)
+}
+
+// SetRotation replaces the rotation and picks the next map from it. The
+// rotation is left as it was when a map name is invalid.
+func (m *Manager) SetRotation(rotation []Map) error {
+	for _, mp := range rotation {
+		if !ValidName(mp.Name) {
+			return fmt.Errorf("%w: %q", ErrInvalidMap, mp.Name)
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rotation = rotation
+	if next, ok := pick(m.rotation, m.opts.Order, m.current, m.opts.Players()); ok {
+		m.next = next.Name
+	}
+	return nil
+}
+
+// CurrentVote returns a copy of the running vote.
+func (m *Manager) CurrentVote() (Vote, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.vote == nil {
+		return Vote{}, false
+	}
+	v := *m.vote
+	v.Options = append([]string(nil), v.Options...)
+	v.Tally = append([]int(nil), v.Tally...)
+	v.ballots = nil
+	return v, true
+}
+
+// ChangeLevel switches to the map now.
+func (m *Manager) ChangeLevel(name string) error {
+	if !ValidName(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidMap, name)
+	}
+	m.mu.Lock()
+	m.start(name)
+	m.mu.Unlock()
+	m.opts.Exec("changelevel " + name)
+	return nil
+}
+
+// say prints a chat message to everyone.
+func (m *Manager) say(format string, args ...any) {
+	text := strings.ReplaceAll(fmt.Sprintf(format, args...), `"`, "'")
+	m.opts.Exec(`say "` + text + `"`)
+}
+
+// Chat handles a chat line of the player at addr.
+func (m *Manager) Chat(addr goxash3d_fwgs.Addr, text string) {
+	args := strings.Fields(strings.ToLower(strings.TrimSpace(text)))
+	if len(args) == 0 {
+		return
+	}
+	switch args[0] {
+	case "rtv", "rockthevote":
+		m.rockTheVote(addr)
+	case "nominate":
+		if len(args) > 1 {
+			m.nominate(addr, args[1])
+		}
+	case "nextmap":
+		m.say("Next map: %s", m.Next())
+	case "currentmap":
+		m.say("Current map: %s", m.Current())
+	default:
+		if n, err := strconv.Atoi(args[0]); err == nil {
+			m.ballot(addr, n)
+		}
+	}
+}
+
+// ChatHandler passes the chat messages that made it through the earlier
+// handlers of a chat pipeline to Chat. Messages always continue, the vote
+// commands stay visible to everyone.
+func (m *Manager) ChatHandler() chat.Handler {
+	return func(msg *chat.Message) chat.Action {
+		m.Chat(msg.Client.Addr, msg.Text)
+		return chat.Continue
+	}
+}
+
+func (m *Manager) rockTheVote(addr goxash3d_fwgs.Addr) {
+	m.mu.Lock()
+	if m.vote != nil || m.ending {
+		m.mu.Unlock()
+		return
+	}
+	m.rtv[addr] = true
+	needed := int(math.Ceil(float64(m.opts.Players()) * m.opts.RTVRatio))
+	if needed < 1 {
+		needed = 1
+	}
+	have := len(m.rtv)
+	if have < needed {
+		m.mu.Unlock()
+		m.say("%d of %d players want to rock the vote", have, needed)
+		return
+	}
+	options := m.startVote()
+	m.mu.Unlock()
+	m.announce(options)
+}
+
+// startVote opens a vote on the nominations and the pool. Caller holds mu.
+func (m *Manager) startVote() []string {
+	options := make([]string, 0, m.opts.VoteOptions)
+	seen := map[string]bool{m.current: true}
+	add := func(name string) {
+		if len(options) < m.opts.VoteOptions && !seen[name] {
+			seen[name] = true
+			options = append(options, name)
+		}
+	}
+	for _, name := range m.nominations {
+		add(name)
+	}
+	if m.next != "" {
+		add(m.next)
+	}
+	playing := m.opts.Players()
+	for _, mp := range m.rotation {
+		if mp.Fits(playing) {
+			add(mp.Name)
+		}
+	}
+	m.vote = &Vote{
+		Options:  options,
+		Tally:    make([]int, len(options)),
+		Deadline: time.Now().Add(m.opts.VoteDuration),
+		ballots:  make(map[goxash3d_fwgs.Addr]int),
+	}
+	return options
+}
+
+func (m *Manager) announce(options []string) {
+	parts := make([]string, len(options))
+	for i, name := range options {
+		parts[i] = fmt.Sprintf("%d) %s", i+1, name)
+	}
+	m.say("Vote for the next map, say the number: %s", strings.Join(parts, " "))
+}
+
+func (m *Manager) nominate(addr goxash3d_fwgs.Addr, name string) {
+	name = strings.TrimSuffix(name, ".bsp")
+	if !ValidName(name) {
+		return
+	}
+	m.mu.Lock()
+	known := false
+	for _, mp := range m.rotation {
+		if mp.Name == name {
+			known = true
+			break
+		}
+	}
+	switch {
+	case !known:
+		m.mu.Unlock()
+		m.say("%s is not in the rotation", name)
+		return
+	case m.vote != nil || name == m.current:
+		m.mu.Unlock()
+		return
+	}
+	// one nomination per player, a new one replaces the old
+	if old, ok := m.nominators[addr]; ok {
+		for i, n := range m.nominations {
+			if n == old {
+				m.nominations = append(m.nominations[:i], m.nominations[i+1:]...)
+				break
+			}
+		}
+	}
+	m.nominators[addr] = name
+	duplicate := false
+	for _, n := range m.nominations {
+		duplicate = duplicate || n == name
+	}
+	if !duplicate {
+		m.nominations = append(m.nominations, name)
+	}
+	m.mu.Unlock()
+	m.say("%s was nominated", name)
+}
+
+func (m *Manager) ballot(addr goxash3d_fwgs.Addr, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v := m.vote
+	if v == nil || n < 1 || n > len(v.Options) {
+		return
+	}
+	if old, ok := v.ballots[addr]; ok {
+		v.Tally[old]--
+	}
+	v.ballots[addr] = n - 1
+	v.Tally[n-1]++
+}
+
+// Run ends votes and maps on time until ctx is done.
+func (m *Manager) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			m.tick(now)
+		}
+	}
+}
+
+func (m *Manager) tick(now time.Time) {
+	m.mu.Lock()
+	if v := m.vote; v != nil && now.After(v.Deadline) {
+		winner := 0
+		for i, count := range v.Tally {
+			if count > v.Tally[winner] {
+				winner = i
+			}
+		}
+		m.vote = nil
+		if len(v.Options) == 0 {
+			m.mu.Unlock()
+			return
+		}
+		name := v.Options[winner]
+		m.mu.Unlock()
+		m.say("%s won the vote with %d votes", name, v.Tally[winner])
+		_ = m.ChangeLevel(name)
+		return
+	}
+	expired := m.opts.TimeLimit > 0 && !m.ending && now.Sub(m.started) >= m.opts.TimeLimit
+	m.mu.Unlock()
+	if expired {
+		m.endMap()
+	}
+}
+
+// endMap changes to the next map after ChatTime.
+func (m *Manager) endMap() {
+	m.mu.Lock()
+	if m.ending {
+		m.mu.Unlock()
+		return
+	}
+	m.ending = true
+	current := m.current
+	m.mu.Unlock()
+
+	time.AfterFunc(m.opts.ChatTime, func() {
+		m.mu.Lock()
+		next := m.next
+		changed := m.current != current || !m.ending
+		m.mu.Unlock()
+		if !changed && next != "" {
+			_ = m.ChangeLevel(next)
+		}
+	})
+}
+
+// SendTo watches for the intermission and sends the packet through the wrapped network.
+func (m *Manager) SendTo(fd int, pkt goxash3d_fwgs.Packet, flags int) int {
+	m.observeOutbound(pkt)
+	return m.Xash3DNetwork.SendTo(fd, pkt, flags)
+}
+
+// SendToBatch watches for the intermission and sends the packets through the wrapped network.
+func (m *Manager) SendToBatch(fd int, packets []goxash3d_fwgs.Packet, flags int) int {
+	for _, pkt := range packets {
+		m.observeOutbound(pkt)
+	}
+	return m.Xash3DNetwork.SendToBatch(fd, packets, flags)
+}
+
+func (m *Manager) observeOutbound(pkt goxash3d_fwgs.Packet) {
+	// intermission and server data travel in the reliable stream
+	if header, ok := netchan.DecodeHeader(pkt.Data, netchan.ServerToClient); !ok || !header.Reliable {
+		return
+	}
+	decoded, err := netchan.Decode(pkt.Data, netchan.ServerToClient)
+	if err != nil {
+		return
+	}
+	for _, msg := range decoded.Messages {
+		switch {
+		case msg.ServerData != nil:
+			m.mu.Lock()
+			following := m.following
+			m.mu.Unlock()
+			if !following {
+				name := strings.TrimSuffix(strings.TrimPrefix(msg.ServerData.MapName, "maps/"), ".bsp")
+				m.MapStarted(name)
+			}
+		case msg.Op == netchan.SvcIntermission && m.opts.TimeLimit == 0:
+			m.endMap()
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package mapcycle
+
+import (
+	"bufio"
+	"github.com/yohimik/goxash3d-fwgs/pkg/netchan"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Map is an entry of the rotation.
+type Map struct {
+	Name string `json:"name"`
+	// Weight is the relative chance of the map in Random order, 0 counts as 1.
+	Weight float64 `json:"weight,omitempty"`
+	// MinPlayers and MaxPlayers limit the pool the map belongs to,
+	// MaxPlayers 0 means no upper limit.
+	MinPlayers int `json:"min_players,omitempty"`
+	MaxPlayers int `json:"max_players,omitempty"`
+}
+
+// Fits reports whether the map is in the pool for the player count.
+func (m Map) Fits(players int) bool {
+	return players >= m.MinPlayers && (m.MaxPlayers == 0 || players <= m.MaxPlayers)
+}
+
+// ValidName reports whether name can be passed to changelevel: a single
+// word of letters, digits, '_', '-' and '.'.
+func ValidName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validMaps returns the maps of the rotation with a valid name.
+func validMaps(rotation []Map) []Map {
+	valid := make([]Map, 0, len(rotation))
+	for _, mp := range rotation {
+		if ValidName(mp.Name) {
+			valid = append(valid, mp)
+		}
+	}
+	return valid
+}
+
+// Order is how the next map is picked from the rotation.
+type Order uint8
+
+const (
+	// Sequential walks the rotation in file order.
+	Sequential Order = iota
+	// Random picks a weighted random map other than the current one.
+	Random
+)
+
+// ParseRotation reads a mapcycle.txt or an AMX Mod X maps.ini. Comments
+// start with ; or //, a .bsp suffix is dropped and optional settings follow
+// the name:
+//
+//	crossfire weight=2
+//	stalkyard min=8 max=32
+func ParseRotation(r io.Reader) ([]Map, error) {
+	var rotation []Map
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		args := netchan.Tokenize(line)
+		if len(args) == 0 || args[0] == "{" || args[0] == "}" {
+			continue
+		}
+		m := Map{Name: strings.TrimSuffix(args[0], ".bsp")}
+		for _, arg := range args[1:] {
+			key, value, _ := strings.Cut(arg, "=")
+			switch key {
+			case "weight":
+				m.Weight, _ = strconv.ParseFloat(value, 64)
+			case "min":
+				m.MinPlayers, _ = strconv.Atoi(value)
+			case "max":
+				m.MaxPlayers, _ = strconv.Atoi(value)
+			}
+		}
+		rotation = append(rotation, m)
+	}
+	return rotation, scanner.Err()
+}
+
+// pick returns the map following current for the player count. Maps
+// outside the pool are skipped; if none fits, the pools are ignored.
+func pick(rotation []Map, order Order, current string, players int) (Map, bool) {
+	if len(rotation) == 0 {
+		return Map{}, false
+	}
+	var pool []int
+	for i, m := range rotation {
+		if m.Fits(players) {
+			pool = append(pool, i)
+		}
+	}
+	if len(pool) == 0 {
+		for i := range rotation {
+			pool = append(pool, i)
+		}
+	}
+
+	if order == Random {
+		candidates := pool[:0:0]
+		total := 0.0
+		for _, i := range pool {
+			if rotation[i].Name != current || len(pool) == 1 {
+				candidates = append(candidates, i)
+				total += weight(rotation[i])
+			}
+		}
+		x := rand.Float64() * total
+		for _, i := range candidates {
+			if x -= weight(rotation[i]); x < 0 {
+				return rotation[i], true
+			}
+		}
+		return rotation[candidates[len(candidates)-1]], true
+	}
+
+	// the entry after the current map, wrapping around
+	start := 0
+	for i, m := range rotation {
+		if m.Name == current {
+			start = i + 1
+			break
+		}
+	}
+	for n := 0; n < len(rotation); n++ {
+		i := (start + n) % len(rotation)
+		for _, p := range pool {
+			if p == i {
+				return rotation[i], true
+			}
+		}
+	}
+	return rotation[pool[0]], true
+}
+
+func weight(m Map) float64 {
+	if m.Weight <= 0 {
+		return 1
+	}
+	return m.Weight
+}
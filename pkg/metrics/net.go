@@ -0,0 +1,178 @@
+package metrics
+
+import (
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"sync/atomic"
+	"time"
+)
+
+// QueueStats is implemented by networks that queue inbound packets
+// (e.g. goxash3d_fwgs.BaseNet and everything embedding it).
+type QueueStats interface {
+	QueueLen() int
+	Dropped() uint64
+}
+
+// NetworkOptions configures Instrument.
+type NetworkOptions struct {
+	// Transport labels every metric of this network (e.g. "webrtc").
+	Transport string
+	// PerPeer additionally breaks packet and byte counters down by peer address.
+	PerPeer bool
+	// Registry receives the metrics, DefaultRegistry when nil.
+	Registry *Registry
+	// Queue reports the inbound queue of the transport. When nil the
+	// wrapped network itself is used if it implements QueueStats.
+	Queue QueueStats
+}
+
+// Network wraps a Xash3DNetwork and records its traffic.
+type Network struct {
+	goxash3d_fwgs.Xash3DNetwork
+	opts NetworkOptions
+
+	packets    *CounterVec
+	bytes      *CounterVec
+	peerPkts   *CounterVec
+	peerBytes  *CounterVec
+	sendErrors *Counter
+	polls      *Histogram
+
+	// lastIdle is the time (unix nanos) RecvFrom last drained the queue.
+	lastIdle atomic.Int64
+}
+
+// FrameBuckets are the histogram bounds used for engine frame and poll timing, in seconds.
+var FrameBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.016, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// Instrument wraps the network so its traffic is exported as metrics.
+func Instrument(net goxash3d_fwgs.Xash3DNetwork, opts NetworkOptions) *Network {
+	if opts.Registry == nil {
+		opts.Registry = DefaultRegistry
+	}
+	r := opts.Registry
+	n := &Network{
+		Xash3DNetwork: net,
+		opts:          opts,
+		packets:       r.CounterVec("xash_net_packets_total", "Packets passed through the network bridge.", "transport", "direction"),
+		bytes:         r.CounterVec("xash_net_bytes_total", "Bytes passed through the network bridge.", "transport", "direction"),
+		sendErrors:    r.CounterVec("xash_net_sendto_errors_total", "SendTo calls that failed.", "transport").With(opts.Transport),
+		polls:         r.Histogram("xash_net_poll_interval_seconds", "Interval between two drains of the inbound queue by the engine.", FrameBuckets),
+	}
+	if opts.PerPeer {
+		n.peerPkts = r.CounterVec("xash_net_peer_packets_total", "Packets passed through the network bridge per peer.", "transport", "direction", "peer")
+		n.peerBytes = r.CounterVec("xash_net_peer_bytes_total", "Bytes passed through the network bridge per peer.", "transport", "direction", "peer")
+	}
+	if opts.Queue == nil {
+		opts.Queue, _ = net.(QueueStats)
+	}
+	if qs := opts.Queue; qs != nil {
+		r.GaugeFunc("xash_packet_queue_depth", "Inbound packets waiting for the engine.", func() float64 {
+			return float64(qs.QueueLen())
+		})
+		r.CounterFunc("xash_packet_queue_dropped_total", "Inbound packets dropped because the queue was full (ErrPacketQueueFull).", func() float64 {
+			return float64(qs.Dropped())
+		})
+	}
+	return n
+}
+
+// ForgetPeer removes the per-peer series of a disconnected peer.
+func (n *Network) ForgetPeer(addr goxash3d_fwgs.Addr) {
+	if !n.opts.PerPeer {
+		return
+	}
+	peer := addr.String()
+	for _, dir := range []string{"in", "out"} {
+		n.peerPkts.Delete(n.opts.Transport, dir, peer)
+		n.peerBytes.Delete(n.opts.Transport, dir, peer)
+	}
+}
+
+func (n *Network) observe(direction string, pkt goxash3d_fwgs.Packet) {
+	n.packets.With(n.opts.Transport, direction).Inc()
+	n.bytes.With(n.opts.Transport, direction).Add(float64(len(pkt.Data)))
+	if n.opts.PerPeer {
+		peer := pkt.Addr.String()
+		n.peerPkts.With(n.opts.Transport, direction, peer).Inc()
+		n.peerBytes.With(n.opts.Transport, direction, peer).Add(float64(len(pkt.Data)))
+	}
+}
+
+// RecvFrom receives a packet from the wrapped network and records it.
+//
+// The interval between two drains of the queue is recorded as the poll
+// interval, see RegisterFrames for the frame time itself.
+func (n *Network) RecvFrom() *goxash3d_fwgs.Packet {
+	pkt := n.Xash3DNetwork.RecvFrom()
+	if pkt != nil {
+		n.observe("in", *pkt)
+		return pkt
+	}
+	now := time.Now().UnixNano()
+	if last := n.lastIdle.Swap(now); last != 0 {
+		n.polls.Observe(time.Duration(now - last).Seconds())
+	}
+	return nil
+}
+
+// SendTo sends the packet through the wrapped network and records it.
+func (n *Network) SendTo(fd int, pkt goxash3d_fwgs.Packet, flags int) int {
+	ret := n.Xash3DNetwork.SendTo(fd, pkt, flags)
+	if ret < 0 {
+		n.sendErrors.Inc()
+		return ret
+	}
+	n.observe("out", pkt)
+	return ret
+}
+
+// SendToBatch sends the packets through the wrapped network and records them.
+func (n *Network) SendToBatch(fd int, packets []goxash3d_fwgs.Packet, flags int) int {
+	ret := n.Xash3DNetwork.SendToBatch(fd, packets, flags)
+	if ret < 0 {
+		n.sendErrors.Inc()
+		return ret
+	}
+	for _, pkt := range packets {
+		n.observe("out", pkt)
+	}
+	return ret
+}
+
+// RegisterFrames records how long engine frames take, from the start and
+// end of frame events, and returns a function that stops recording.
+func RegisterFrames(r *Registry, x *goxash3d_fwgs.Xash3D) (stop func()) {
+	if r == nil {
+		r = DefaultRegistry
+	}
+	frames := r.Histogram("xash_engine_frame_seconds", "Time the engine spent in a frame.", FrameBuckets)
+	// handlers run one at a time in event order
+	var start time.Time
+	removeStart := x.On(goxash3d_fwgs.EventFrameStart, func(e goxash3d_fwgs.Event) {
+		start = e.Time
+	})
+	removeEnd := x.On(goxash3d_fwgs.EventFrameEnd, func(e goxash3d_fwgs.Event) {
+		if !start.IsZero() {
+			frames.Observe(e.Time.Sub(start).Seconds())
+			start = time.Time{}
+		}
+	})
+	return func() {
+		removeStart()
+		removeEnd()
+	}
+}
+
+// RegisterBytesPool exports the utilisation of the peer address index pool.
+func RegisterBytesPool(r *Registry, pool *goxash3d_fwgs.BytesPool) {
+	if r == nil {
+		r = DefaultRegistry
+	}
+	r.GaugeFunc("xash_bytes_pool_capacity", "Capacity of the peer address index pool.", func() float64 {
+		return float64(pool.Capacity())
+	})
+	r.GaugeFunc("xash_bytes_pool_in_use", "Indexes currently taken from the peer address index pool.", func() float64 {
+		return float64(pool.Capacity() - pool.Len())
+	})
+}
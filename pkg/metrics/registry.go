@@ -0,0 +1,357 @@
+// Package metrics exports runtime metrics of the Go network bridge in the
+// Prometheus text exposition format.
+//
+// It is dependency free: counters, gauges and histograms are kept in a
+// Registry and served by Handler. Network instruments any Xash3DNetwork.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// metric is a single metric family value set.
+type metric interface {
+	write(w *bufio.Writer, name string)
+}
+
+type family struct {
+	name   string
+	help   string
+	typ    string
+	metric metric
+}
+
+// Registry holds metric families and renders them for scraping.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// DefaultRegistry is the registry served by Handler.
+var DefaultRegistry = NewRegistry()
+
+// register adds a family or returns the metric already registered under name,
+// so instrumenting several networks shares the same families.
+func (r *Registry) register(name, help, typ string, m metric) metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.typ != typ {
+			panic(fmt.Sprintf("metrics: %s already registered as %s", name, f.typ))
+		}
+		return f.metric
+	}
+	r.families[name] = &family{name: name, help: help, typ: typ, metric: m}
+	return m
+}
+
+// Counter registers a monotonically increasing counter.
+func (r *Registry) Counter(name, help string) *Counter {
+	return r.register(name, help, "counter", &Counter{}).(*Counter)
+}
+
+// CounterVec registers a counter partitioned by labels.
+func (r *Registry) CounterVec(name, help string, labels ...string) *CounterVec {
+	return r.register(name, help, "counter", &CounterVec{newVec[Counter](labels)}).(*CounterVec)
+}
+
+// CounterFunc registers a counter whose value is read from fn at scrape time.
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.register(name, help, "counter", valueFunc(fn))
+}
+
+// Gauge registers a value that can go up and down.
+func (r *Registry) Gauge(name, help string) *Gauge {
+	return r.register(name, help, "gauge", &Gauge{}).(*Gauge)
+}
+
+// GaugeVec registers a gauge partitioned by labels.
+func (r *Registry) GaugeVec(name, help string, labels ...string) *GaugeVec {
+	return r.register(name, help, "gauge", &GaugeVec{newVec[Gauge](labels)}).(*GaugeVec)
+}
+
+// GaugeFunc registers a gauge whose value is read from fn at scrape time.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(name, help, "gauge", valueFunc(fn))
+}
+
+// Histogram registers a histogram with the given upper bounds.
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	return r.register(name, help, "histogram", newHistogram(buckets)).(*Histogram)
+}
+
+// WriteTo renders every family in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.typ)
+		f.metric.write(bw, f.name)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler serves the registry for Prometheus scraping.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = r.WriteTo(w)
+	})
+}
+
+// Handler serves DefaultRegistry.
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
+
+// Counter is a monotonically increasing float64.
+type Counter struct {
+	bits atomic.Uint64
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add increments the counter by v.
+func (c *Counter) Add(v float64) { addFloat(&c.bits, v) }
+
+// Value returns the current value.
+func (c *Counter) Value() float64 { return math.Float64frombits(c.bits.Load()) }
+
+func (c *Counter) write(w *bufio.Writer, name string) { writeSample(w, name, "", c.Value()) }
+
+// Gauge is a float64 that can go up and down.
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) { g.bits.Store(math.Float64bits(v)) }
+
+// Add adds v (which may be negative) to the gauge.
+func (g *Gauge) Add(v float64) { addFloat(&g.bits, v) }
+
+// Inc increments the gauge by one.
+func (g *Gauge) Inc() { g.Add(1) }
+
+// Dec decrements the gauge by one.
+func (g *Gauge) Dec() { g.Add(-1) }
+
+// Value returns the current value.
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+
+func (g *Gauge) write(w *bufio.Writer, name string) { writeSample(w, name, "", g.Value()) }
+
+type valueFunc func() float64
+
+func (f valueFunc) write(w *bufio.Writer, name string) { writeSample(w, name, "", f()) }
+
+// Histogram samples observations into cumulative buckets.
+type Histogram struct {
+	upper  []float64
+	counts []atomic.Uint64
+	count  atomic.Uint64
+	sum    atomic.Uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	upper := append([]float64(nil), buckets...)
+	sort.Float64s(upper)
+	return &Histogram{
+		upper:  upper,
+		counts: make([]atomic.Uint64, len(upper)),
+	}
+}
+
+// Observe adds a single observation.
+func (h *Histogram) Observe(v float64) {
+	for i, upper := range h.upper {
+		if v <= upper {
+			h.counts[i].Add(1)
+			break
+		}
+	}
+	h.count.Add(1)
+	addFloat(&h.sum, v)
+}
+
+func (h *Histogram) write(w *bufio.Writer, name string) {
+	var cumulative uint64
+	for i, upper := range h.upper {
+		cumulative += h.counts[i].Load()
+		writeSample(w, name+"_bucket", `le="`+formatFloat(upper)+`"`, float64(cumulative))
+	}
+	count := h.count.Load()
+	writeSample(w, name+"_bucket", `le="+Inf"`, float64(count))
+	writeSample(w, name+"_sum", "", math.Float64frombits(h.sum.Load()))
+	writeSample(w, name+"_count", "", float64(count))
+}
+
+// vec keeps one metric per distinct label value combination.
+type vec[T any] struct {
+	labels []string
+	mu     sync.RWMutex
+	items  map[string]*T
+}
+
+func newVec[T any](labels []string) vec[T] {
+	return vec[T]{labels: labels, items: make(map[string]*T)}
+}
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: expected %d label values, got %d", len(v.labels), len(values)))
+	}
+	key := formatLabels(v.labels, values)
+	v.mu.RLock()
+	item, ok := v.items[key]
+	v.mu.RUnlock()
+	if ok {
+		return item
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if item, ok = v.items[key]; !ok {
+		item = new(T)
+		v.items[key] = item
+	}
+	return item
+}
+
+func (v *vec[T]) delete(values []string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.items, formatLabels(v.labels, values))
+}
+
+func (v *vec[T]) each(fn func(labels string, item *T)) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.items))
+	for k := range v.items {
+		keys = append(keys, k)
+	}
+	v.mu.RUnlock()
+	sort.Strings(keys)
+	for _, k := range keys {
+		v.mu.RLock()
+		item, ok := v.items[k]
+		v.mu.RUnlock()
+		if ok {
+			fn(k, item)
+		}
+	}
+}
+
+// CounterVec is a set of counters partitioned by labels.
+type CounterVec struct{ vec[Counter] }
+
+// With returns the counter for the label values (in registration order).
+func (c *CounterVec) With(values ...string) *Counter { return c.with(values) }
+
+// Delete removes the counter for the label values.
+func (c *CounterVec) Delete(values ...string) { c.delete(values) }
+
+func (c *CounterVec) write(w *bufio.Writer, name string) {
+	c.each(func(labels string, item *Counter) { writeSample(w, name, labels, item.Value()) })
+}
+
+// GaugeVec is a set of gauges partitioned by labels.
+type GaugeVec struct{ vec[Gauge] }
+
+// With returns the gauge for the label values (in registration order).
+func (g *GaugeVec) With(values ...string) *Gauge { return g.with(values) }
+
+// Delete removes the gauge for the label values.
+func (g *GaugeVec) Delete(values ...string) { g.delete(values) }
+
+func (g *GaugeVec) write(w *bufio.Writer, name string) {
+	g.each(func(labels string, item *Gauge) { writeSample(w, name, labels, item.Value()) })
+}
+
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func writeSample(w *bufio.Writer, name, labels string, v float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteByte('{')
+		w.WriteString(labels)
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatLabels(names, values []string) string {
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
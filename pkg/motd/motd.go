@@ -0,0 +1,232 @@
+// Package motd renders the message of the day and a server info page as
+// HTML with live data: the current and next map, the players and the rules.
+//
+// The template is read from disk and parsed again whenever the file
+// changes, so the page can be edited on a running server. The engine
+// motdfile is pointed at the page with SetMOTDURL.
+package motd
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"github.com/yohimik/goxash3d-fwgs/pkg/mapcycle"
+	"github.com/yohimik/goxash3d-fwgs/pkg/players"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrBadURL = errors.New("motd: url must be an absolute http(s) url on one line")
+
+// DefaultTemplate is the page rendered without a template file.
+//
+//go:embed default.html
+var DefaultTemplate string
+
+// Options configures a Page.
+type Options struct {
+	// Template is the path of an html/template file, DefaultTemplate when empty.
+	Template string
+	// Registry lists the players, players.Default by default.
+	Registry *players.Registry
+	// Maps provides the current and next map, it may be nil.
+	Maps *mapcycle.Manager
+	// Map returns the current map when Maps is nil. It is called for every
+	// render, so it should report the live state of the engine.
+	Map func() string
+	// Rules returns the server rules shown on the page, it may be nil.
+	// Its result is reused for RulesTTL, so it may be slow.
+	Rules func() map[string]string
+	// RulesTTL is how long the rules are cached, 5s by default.
+	RulesTTL time.Duration
+}
+
+// Player is a player listed on the page.
+type Player struct {
+	Name  string    `json:"name"`
+	Since time.Time `json:"since"`
+	// Time is how long the player has been connected.
+	Time time.Duration `json:"-"`
+}
+
+// Rule is a server setting listed on the page.
+type Rule struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Info is the data the template is executed with.
+type Info struct {
+	Hostname string    `json:"hostname"`
+	Map      string    `json:"map"`
+	NextMap  string    `json:"nextMap,omitempty"`
+	Players  []Player  `json:"players"`
+	Rules    []Rule    `json:"rules"`
+	Time     time.Time `json:"time"`
+}
+
+// Page renders the template.
+type Page struct {
+	opts Options
+
+	mu       sync.Mutex
+	tmpl     *template.Template
+	modTime  time.Time
+	size     int64
+	parseErr error
+
+	// rulesMu is held while Rules runs, so concurrent renders share one call
+	rulesMu   sync.Mutex
+	rules     map[string]string
+	rulesTime time.Time
+}
+
+// New creates the page. The template file is parsed on the first render.
+func New(opts Options) (*Page, error) {
+	if opts.Registry == nil {
+		opts.Registry = players.Default
+	}
+	if opts.RulesTTL == 0 {
+		opts.RulesTTL = 5 * time.Second
+	}
+	p := &Page{opts: opts}
+	if opts.Template == "" {
+		tmpl, err := template.New("motd").Parse(DefaultTemplate)
+		if err != nil {
+			return nil, err
+		}
+		p.tmpl = tmpl
+	}
+	return p, nil
+}
+
+// template returns the parsed template, parsing the file again when it
+// changed. A broken edit keeps the last good template in use.
+func (p *Page) template() (*template.Template, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.opts.Template == "" {
+		return p.tmpl, nil
+	}
+
+	stat, err := os.Stat(p.opts.Template)
+	if err != nil {
+		if p.tmpl != nil {
+			return p.tmpl, nil
+		}
+		return nil, err
+	}
+	if stat.ModTime().Equal(p.modTime) && stat.Size() == p.size {
+		if p.tmpl != nil {
+			return p.tmpl, nil
+		}
+		return nil, p.parseErr
+	}
+	p.modTime, p.size = stat.ModTime(), stat.Size()
+
+	tmpl, err := template.New(filepath.Base(p.opts.Template)).ParseFiles(p.opts.Template)
+	if err != nil {
+		p.parseErr = err
+		if p.tmpl != nil {
+			return p.tmpl, nil
+		}
+		return nil, err
+	}
+	p.tmpl, p.parseErr = tmpl, nil
+	return tmpl, nil
+}
+
+// Info collects the live data of the page.
+func (p *Page) Info() Info {
+	now := time.Now()
+	info := Info{Time: now, Players: []Player{}, Rules: []Rule{}}
+	if p.opts.Maps != nil {
+		info.Map = p.opts.Maps.Current()
+		info.NextMap = p.opts.Maps.Next()
+	} else if p.opts.Map != nil {
+		info.Map = p.opts.Map()
+	}
+	for _, pl := range p.opts.Registry.Players() {
+		if !pl.Connected {
+			continue
+		}
+		info.Players = append(info.Players, Player{Name: pl.Name, Since: pl.Since, Time: now.Sub(pl.Since).Round(time.Second)})
+	}
+	if p.opts.Rules != nil {
+		for name, value := range p.cachedRules(now) {
+			if name == "hostname" {
+				info.Hostname = value
+				continue
+			}
+			info.Rules = append(info.Rules, Rule{Name: name, Value: value})
+		}
+		slices.SortFunc(info.Rules, func(a, b Rule) int { return strings.Compare(a.Name, b.Name) })
+	}
+	return info
+}
+
+// cachedRules returns the rules, calling Rules again once they are older than RulesTTL.
+func (p *Page) cachedRules(now time.Time) map[string]string {
+	p.rulesMu.Lock()
+	defer p.rulesMu.Unlock()
+	if p.rules == nil || now.Sub(p.rulesTime) >= p.opts.RulesTTL {
+		p.rules = p.opts.Rules()
+		p.rulesTime = time.Now()
+	}
+	return p.rules
+}
+
+// Handler serves the rendered page on GET / and the data as JSON on GET /info.json.
+func (p *Page) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		switch r.URL.Path {
+		case "/", "":
+		case "/info.json":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(p.Info())
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		tmpl, err := p.template()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, p.Info()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(b.String()))
+	})
+}
+
+// SetMOTDURL writes url into the file name of the game directory dir and
+// points the engine motdfile at it. Clients that support web MOTDs open the
+// page, others show the url as text.
+func SetMOTDURL(exec func(cmd string), dir, name, url string) error {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") ||
+		strings.ContainsAny(url, "\"\r\n;") || strings.ContainsAny(name, "\"\r\n;") {
+		return ErrBadURL
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(url+"\n"), 0o644); err != nil {
+		return err
+	}
+	exec(`motdfile "` + name + `"`)
+	return nil
+}
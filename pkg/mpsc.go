@@ -34,7 +34,7 @@ type PacketQueue struct {
 
 	// pad before head to separate cache lines
 	_headPad [cacheLine]byte
-	head     uint32 // consumer only
+	head     uint32 // written by the consumer only
 
 	// pad between head and tail
 	_midPad [cacheLine - 4]byte
@@ -111,7 +111,7 @@ func (q *PacketQueue) Enqueue(p Packet) error {
 
 // TryDequeue pops a Packet in consumer (single) context. Returns (pkt,true) or (zero,false).
 func (q *PacketQueue) TryDequeue() (Packet, bool) {
-	pos := q.head
+	pos := atomic.LoadUint32(&q.head)
 	cell := &q.buf[pos&q.mask]
 	seq := atomic.LoadUint32(&cell.seq)
 	if seq == pos+1 {
@@ -125,7 +125,7 @@ func (q *PacketQueue) TryDequeue() (Packet, bool) {
 
 		// mark slot free for producers: seq = pos + mask + 1
 		atomic.StoreUint32(&cell.seq, pos+q.mask+1)
-		q.head = pos + 1
+		atomic.StoreUint32(&q.head, pos+1)
 		return val, true
 	}
 	var zero Packet
@@ -149,7 +149,7 @@ func (q *PacketQueue) DrainPackets(handler func(Packet)) int {
 // Len returns an approximate count of items in the queue. Only approximate during concurrent Enqueue.
 func (q *PacketQueue) Len() int {
 	t := atomic.LoadUint32(&q.tail)
-	h := q.head // consumer-only read
+	h := atomic.LoadUint32(&q.head) // may be read outside the consumer, e.g. by metrics
 	if t < h {
 		return 0
 	}
@@ -17,6 +17,8 @@ static void set_errno(int err) {
 import "C"
 
 import (
+	"strconv"
+	"strings"
 	"unsafe"
 )
 
@@ -33,6 +35,46 @@ type Addr struct {
 	Port uint16
 }
 
+// String formats the address as "a.b.c.d:port".
+func (a Addr) String() string {
+	b := make([]byte, 0, 21)
+	for i, octet := range a.IP {
+		if i > 0 {
+			b = append(b, '.')
+		}
+		b = strconv.AppendUint(b, uint64(octet), 10)
+	}
+	b = append(b, ':')
+	b = strconv.AppendUint(b, uint64(a.Port), 10)
+	return string(b)
+}
+
+// ParseAddr parses an "a.b.c.d:port" address as formatted by Addr.String.
+func ParseAddr(s string) (Addr, bool) {
+	var addr Addr
+	host, port, ok := strings.Cut(s, ":")
+	if !ok {
+		return addr, false
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return addr, false
+	}
+	octets := strings.Split(host, ".")
+	if len(octets) != 4 {
+		return addr, false
+	}
+	for i, octet := range octets {
+		v, err := strconv.ParseUint(octet, 10, 8)
+		if err != nil {
+			return addr, false
+		}
+		addr.IP[i] = byte(v)
+	}
+	addr.Port = uint16(p)
+	return addr, true
+}
+
 // Xash3DNetwork defines an interface for emulating or overriding
 // low-level networking functionality, used by the engine to
 // optionally route through Go-based logic.
@@ -49,6 +91,12 @@ type Xash3DNetwork interface {
 	GetAddrInfo(host string) uint8
 }
 
+// PacketPusher is implemented by networks that accept inbound packets
+// from transports, e.g. BaseNet and the decorators wrapping it.
+type PacketPusher interface {
+	PushPacket(packet Packet)
+}
+
 // sendBatch is a reusable buffer used for batching packet sends
 // to avoid repeated allocations in performance-critical paths.
 var (
@@ -112,6 +160,9 @@ func writeIPv4Sockaddr(sockaddr unsafe.Pointer, socklen *C.socklen_t, addr Addr)
 //
 //export lib_net_socket
 func lib_net_socket(domain, typ, proto C.int) C.int {
+	// sockets open before the first map runs server.cfg, create Go cvars and commands now
+	DefaultXash3D.cvars.service(&DefaultXash3D.events)
+	DefaultXash3D.commands.drain()
 	if DefaultXash3D.Net == nil {
 		return C.socket(domain, typ, proto)
 	}
@@ -224,6 +275,10 @@ func lib_net_sendto_batch(
 //
 //export lib_net_recvfrom
 func lib_net_recvfrom(fd C.int, buf unsafe.Pointer, length C.size_t, flags C.int, sockaddr unsafe.Pointer, socklen *C.socklen_t) C.int {
+	// the engine polls the network every frame, run queued commands on its thread
+	DefaultXash3D.cvars.service(&DefaultXash3D.events)
+	DefaultXash3D.commands.drain()
+
 	if DefaultXash3D.Net == nil {
 		ret := C.recvfrom(fd, buf, length, flags, (*C.struct_sockaddr)(unsafe.Pointer(sockaddr)), socklen)
 		return C.int(ret)
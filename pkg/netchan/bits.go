@@ -0,0 +1,173 @@
+package netchan
+
+import (
+	"errors"
+	"math"
+)
+
+var ErrOverflow = errors.New("netchan: read past end of message")
+
+// BitReader reads engine messages. Like the engine's sizebuf_t it is
+// bit addressed: values are stored least significant bit first and
+// need not start on a byte boundary.
+type BitReader struct {
+	data []byte
+	pos  int // in bits
+	err  error
+}
+
+// NewBitReader creates a reader over data.
+func NewBitReader(data []byte) *BitReader {
+	return &BitReader{data: data}
+}
+
+// Err returns the first overflow error, if any.
+func (r *BitReader) Err() error { return r.err }
+
+// BitsLeft returns the number of unread bits.
+func (r *BitReader) BitsLeft() int { return len(r.data)*8 - r.pos }
+
+// BitPos returns the current read position in bits.
+func (r *BitReader) BitPos() int { return r.pos }
+
+// ReadBits reads an unsigned value of n (<= 32) bits.
+func (r *BitReader) ReadBits(n int) uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if n > r.BitsLeft() {
+		r.err = ErrOverflow
+		r.pos = len(r.data) * 8
+		return 0
+	}
+	var v uint32
+	for i := 0; i < n; i++ {
+		if r.data[r.pos>>3]&(1<<(r.pos&7)) != 0 {
+			v |= 1 << i
+		}
+		r.pos++
+	}
+	return v
+}
+
+// ReadBit reads a single bit.
+func (r *BitReader) ReadBit() bool { return r.ReadBits(1) != 0 }
+
+// ReadUint8 reads an unsigned 8 bit value.
+func (r *BitReader) ReadUint8() byte { return byte(r.ReadBits(8)) }
+
+// ReadInt16 reads a signed 16 bit value.
+func (r *BitReader) ReadInt16() int16 { return int16(r.ReadBits(16)) }
+
+// ReadUint16 reads an unsigned 16 bit value.
+func (r *BitReader) ReadUint16() uint16 { return uint16(r.ReadBits(16)) }
+
+// ReadUint32 reads a 32 bit value.
+func (r *BitReader) ReadUint32() uint32 { return r.ReadBits(32) }
+
+// ReadFloat32 reads a 32 bit IEEE float.
+func (r *BitReader) ReadFloat32() float32 { return math.Float32frombits(r.ReadBits(32)) }
+
+// ReadString reads a null terminated string.
+func (r *BitReader) ReadString() string {
+	var b []byte
+	for r.err == nil {
+		c := r.ReadUint8()
+		if c == 0 {
+			break
+		}
+		b = append(b, c)
+	}
+	return string(b)
+}
+
+// ReadBytes reads n whole bytes. A negative n is an overflow like a too large one.
+func (r *BitReader) ReadBytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > r.BitsLeft()/8 {
+		r.err = ErrOverflow
+		r.pos = len(r.data) * 8
+		return nil
+	}
+	if r.pos&7 == 0 {
+		b := r.data[r.pos>>3 : r.pos>>3+n]
+		r.pos += n * 8
+		return b
+	}
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = r.ReadUint8()
+	}
+	return b
+}
+
+// BitWriter writes engine messages in the same bit order as BitReader.
+type BitWriter struct {
+	data []byte
+	pos  int // in bits
+}
+
+// Bytes returns the written data, the last byte padded with zero bits.
+func (w *BitWriter) Bytes() []byte { return w.data }
+
+// BitPos returns the number of bits written.
+func (w *BitWriter) BitPos() int { return w.pos }
+
+// WriteBits writes the n (<= 32) low bits of v.
+func (w *BitWriter) WriteBits(v uint32, n int) {
+	for i := 0; i < n; i++ {
+		if w.pos&7 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if v&(1<<i) != 0 {
+			w.data[w.pos>>3] |= 1 << (w.pos & 7)
+		}
+		w.pos++
+	}
+}
+
+// WriteBit writes a single bit.
+func (w *BitWriter) WriteBit(b bool) {
+	if b {
+		w.WriteBits(1, 1)
+	} else {
+		w.WriteBits(0, 1)
+	}
+}
+
+// WriteUint8 writes an 8 bit value.
+func (w *BitWriter) WriteUint8(v byte) { w.WriteBits(uint32(v), 8) }
+
+// WriteInt16 writes a signed 16 bit value.
+func (w *BitWriter) WriteInt16(v int16) { w.WriteBits(uint32(uint16(v)), 16) }
+
+// WriteUint16 writes an unsigned 16 bit value.
+func (w *BitWriter) WriteUint16(v uint16) { w.WriteBits(uint32(v), 16) }
+
+// WriteUint32 writes a 32 bit value.
+func (w *BitWriter) WriteUint32(v uint32) { w.WriteBits(v, 32) }
+
+// WriteFloat32 writes a 32 bit IEEE float.
+func (w *BitWriter) WriteFloat32(v float32) { w.WriteBits(math.Float32bits(v), 32) }
+
+// WriteString writes a null terminated string.
+func (w *BitWriter) WriteString(s string) {
+	for i := 0; i < len(s); i++ {
+		w.WriteUint8(s[i])
+	}
+	w.WriteUint8(0)
+}
+
+// WriteBytes writes raw bytes.
+func (w *BitWriter) WriteBytes(b []byte) {
+	if w.pos&7 == 0 {
+		w.data = append(w.data, b...)
+		w.pos += len(b) * 8
+		return
+	}
+	for _, c := range b {
+		w.WriteUint8(c)
+	}
+}
@@ -0,0 +1,195 @@
+package netchan
+
+import (
+	"bytes"
+	"time"
+)
+
+// lzssMagic prefixes fragment buffers compressed by the engine.
+var lzssMagic = []byte("LZSS")
+
+// rttWindow is the number of outgoing sequences remembered for RTT sampling.
+const rttWindow = 64
+
+// Chan keeps the state of one end of a netchan session: sequence numbers,
+// reliable retransmission and fragment reassembly.
+type Chan struct {
+	// Direction is the direction of the packets this end sends.
+	Direction Direction
+	// QPort is sent in every client packet.
+	QPort uint16
+
+	outgoingSequence     uint32
+	incomingSequence     uint32
+	incomingAcknowledged uint32
+
+	incomingReliableAcknowledged bool
+	incomingReliableSequence     bool
+	reliableSequence             bool
+	lastReliableSequence         uint32
+
+	// reliable is the reliable message in flight, message is queued behind it.
+	reliable []byte
+	message  []byte
+
+	fragments [MaxStreams]fragmentBuffer
+
+	sent [rttWindow]time.Time
+	rtt  time.Duration
+}
+
+type fragmentBuffer struct {
+	id    uint32
+	parts [][]byte
+	count int
+}
+
+// NewChan creates the sending end of a session in the given direction.
+func NewChan(dir Direction, qport uint16) *Chan {
+	return &Chan{Direction: dir, QPort: qport, outgoingSequence: 1}
+}
+
+// OutgoingSequence returns the sequence number of the next transmitted packet.
+func (c *Chan) OutgoingSequence() uint32 { return c.outgoingSequence }
+
+// IncomingSequence returns the last sequence number received.
+func (c *Chan) IncomingSequence() uint32 { return c.incomingSequence }
+
+// RTT returns the smoothed round trip time measured from acknowledgements.
+func (c *Chan) RTT() time.Duration { return c.rtt }
+
+// QueueReliable appends messages to the reliable stream.
+func (c *Chan) QueueReliable(data []byte) {
+	c.message = append(c.message, data...)
+}
+
+// HasPendingReliable reports whether reliable data waits for delivery or acknowledgement.
+func (c *Chan) HasPendingReliable() bool {
+	return len(c.reliable) > 0 || len(c.message) > 0
+}
+
+// Transmit builds the next datagram: the header, the reliable message when
+// it is new or must be resent, and the unreliable message bits.
+func (c *Chan) Transmit(unreliable []byte, unreliableBits int) []byte {
+	// the peer dropped the last reliable message, resend it
+	sendReliable := c.incomingAcknowledged > c.lastReliableSequence &&
+		c.incomingReliableAcknowledged != c.reliableSequence
+
+	if len(c.reliable) == 0 && len(c.message) > 0 {
+		c.reliable = c.message
+		c.message = nil
+		c.reliableSequence = !c.reliableSequence
+		sendReliable = true
+	}
+
+	w1 := c.outgoingSequence & sequenceMask
+	if sendReliable {
+		w1 |= ReliableBit
+	}
+	w2 := c.incomingSequence &^ ReliableBit
+	if c.incomingReliableSequence {
+		w2 |= ReliableBit
+	}
+
+	w := &BitWriter{}
+	w.WriteUint32(w1)
+	w.WriteUint32(w2)
+	if c.Direction == ClientToServer {
+		w.WriteUint16(c.QPort)
+	}
+
+	c.sent[c.outgoingSequence%rttWindow] = time.Now()
+	c.outgoingSequence++
+
+	if sendReliable {
+		w.WriteBytes(c.reliable)
+		c.lastReliableSequence = c.outgoingSequence
+	}
+	for i := 0; i < unreliableBits; i++ {
+		w.WriteBit(unreliable[i>>3]&(1<<(i&7)) != 0)
+	}
+	return w.Bytes()
+}
+
+// Process updates the session with a received datagram. It returns the
+// decoded packet, or false for stale, duplicated or undecodable packets.
+// Completed fragment buffers of the normal stream are decoded into Messages.
+func (c *Chan) Process(data []byte) (*Packet, bool) {
+	peer := ServerToClient
+	if c.Direction == ServerToClient {
+		peer = ClientToServer
+	}
+	pkt, err := Decode(data, peer)
+	if err != nil || pkt.Kind != KindSequenced {
+		return nil, false
+	}
+
+	h := pkt.Header
+	if h.Sequence <= c.incomingSequence {
+		return nil, false
+	}
+
+	// the current reliable message has been acknowledged
+	if h.AckReliable == c.reliableSequence {
+		c.reliable = nil
+	}
+	if sent := c.sent[h.Ack%rttWindow]; !sent.IsZero() && h.Ack >= c.incomingAcknowledged {
+		sample := time.Since(sent)
+		if c.rtt == 0 {
+			c.rtt = sample
+		} else {
+			c.rtt += (sample - c.rtt) / 8
+		}
+		c.sent[h.Ack%rttWindow] = time.Time{}
+	}
+
+	c.incomingSequence = h.Sequence
+	c.incomingAcknowledged = h.Ack
+	c.incomingReliableAcknowledged = h.AckReliable
+	if h.Reliable {
+		c.incomingReliableSequence = !c.incomingReliableSequence
+	}
+
+	for i, f := range pkt.Fragments {
+		if !f.Present {
+			continue
+		}
+		full, ok := c.fragments[i].add(f)
+		if !ok || i != 0 {
+			continue
+		}
+		pkt.Assembled = full
+		if bytes.HasPrefix(full, lzssMagic) {
+			// compressed signon data is not decoded
+			pkt.Partial = true
+			continue
+		}
+		messages, partial := ParseMessages(full, peer)
+		pkt.Messages = append(messages, pkt.Messages...)
+		pkt.Partial = pkt.Partial || partial
+	}
+	return pkt, true
+}
+
+// add stores a fragment and returns the whole buffer once complete.
+func (b *fragmentBuffer) add(f Fragment) ([]byte, bool) {
+	count := f.Count()
+	index := f.Index() - 1
+	if count <= 0 || index < 0 || index >= count {
+		return nil, false
+	}
+	if b.parts == nil || len(b.parts) != count {
+		b.parts = make([][]byte, count)
+		b.count = 0
+	}
+	if b.parts[index] == nil {
+		b.parts[index] = append([]byte(nil), f.Data...)
+		b.count++
+	}
+	if b.count < count {
+		return nil, false
+	}
+	full := bytes.Join(b.parts, nil)
+	b.parts = nil
+	return full, true
+}
@@ -0,0 +1,141 @@
+package netchan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+)
+
+// Connectionless is an out-of-band command packet.
+type Connectionless struct {
+	// Command is the first token, e.g. "getchallenge", "connect" or "rcon".
+	Command string
+	// Args are all tokens including the command.
+	Args []string
+	// Data is the raw command text after the 0xFFFFFFFF header.
+	Data []byte
+}
+
+// ParseConnectionless tokenizes the text of an out-of-band packet.
+func ParseConnectionless(data []byte) *Connectionless {
+	line := data
+	if i := bytes.IndexByte(line, 0); i >= 0 {
+		line = line[:i]
+	}
+	args := Tokenize(string(line))
+	c := &Connectionless{Args: args, Data: data}
+	if len(args) > 0 {
+		c.Command = args[0]
+	}
+	return c
+}
+
+// Protocol info keys sent by Xash3D FWGS clients in the connect request.
+const (
+	ProtInfoUUID       = "uuid"
+	ProtInfoQPort      = "qport"
+	ProtInfoExtensions = "ext"
+)
+
+// Connect extension flags announced by protocol 49 clients in ProtInfoExtensions.
+const (
+	// ExtSplitSize allows the server to split packets to the client's cl_dlmax.
+	ExtSplitSize = 1 << 0
+)
+
+// ConnectRequest is a decoded "connect" command.
+type ConnectRequest struct {
+	Protocol  int
+	Challenge int
+	ProtInfo  Info
+	UserInfo  Info
+}
+
+// QPort returns the qport announced in the protocol info.
+func (c *ConnectRequest) QPort() uint16 {
+	v, _ := strconv.Atoi(c.ProtInfo[ProtInfoQPort])
+	return uint16(v)
+}
+
+// Extensions returns the protocol 49 extension flags of the client.
+func (c *ConnectRequest) Extensions() int {
+	v, _ := strconv.Atoi(c.ProtInfo[ProtInfoExtensions])
+	return v
+}
+
+// Connect decodes the command as a connect request:
+//
+//	connect <protocol> <challenge> "<protinfo>" "<userinfo>"
+func (c *Connectionless) Connect() (*ConnectRequest, bool) {
+	if c.Command != "connect" || len(c.Args) < 5 {
+		return nil, false
+	}
+	protocol, err := strconv.Atoi(c.Args[1])
+	if err != nil {
+		return nil, false
+	}
+	challenge, err := strconv.Atoi(c.Args[2])
+	if err != nil {
+		return nil, false
+	}
+	return &ConnectRequest{
+		Protocol:  protocol,
+		Challenge: challenge,
+		ProtInfo:  ParseInfo(c.Args[3]),
+		UserInfo:  ParseInfo(c.Args[4]),
+	}, true
+}
+
+// Split is one part of a Xash split packet. Large datagrams are sent as
+// several parts sharing a sequence number and reassembled by the receiver.
+type Split struct {
+	Sequence uint32
+	Index    int
+	Count    int
+	Data     []byte
+}
+
+// ParseSplit decodes a split packet part (including its 0xFFFFFFFE header).
+func ParseSplit(data []byte) (*Split, error) {
+	if len(data) < 10 || binary.LittleEndian.Uint32(data) != SplitHeader {
+		return nil, ErrBadSplit
+	}
+	id := binary.LittleEndian.Uint16(data[8:])
+	s := &Split{
+		Sequence: binary.LittleEndian.Uint32(data[4:]),
+		Index:    int(id >> 8),
+		Count:    int(id & 0xff),
+		Data:     data[10:],
+	}
+	if s.Count == 0 || s.Index >= s.Count {
+		return nil, ErrBadSplit
+	}
+	return s, nil
+}
+
+// Reassembler joins split packet parts back into datagrams.
+// It keeps only the most recent sequence, like the engine does.
+type Reassembler struct {
+	sequence uint32
+	parts    [][]byte
+	received int
+}
+
+// Add stores a part and returns the full datagram once all parts arrived.
+func (r *Reassembler) Add(s *Split) ([]byte, bool) {
+	if r.parts == nil || s.Sequence != r.sequence || len(r.parts) != s.Count {
+		r.sequence = s.Sequence
+		r.parts = make([][]byte, s.Count)
+		r.received = 0
+	}
+	if r.parts[s.Index] == nil {
+		r.parts[s.Index] = append([]byte(nil), s.Data...)
+		r.received++
+	}
+	if r.received < len(r.parts) {
+		return nil, false
+	}
+	full := bytes.Join(r.parts, nil)
+	r.parts = nil
+	return full, true
+}
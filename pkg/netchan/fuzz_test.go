@@ -0,0 +1,137 @@
+package netchan
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//go:generate go run ./testdata/gencap testdata/session.pcapng
+
+// capturedPayloads returns the UDP payloads of the pcapng files in testdata,
+// as written by capture.Writer. session.pcapng is synthetic: testdata/gencap
+// builds it from Chan traffic covering the handshake, signon, chat,
+// fragments and split packets. Captures of real sessions, e.g. written by
+// capture.Tap, dropped next to it widen the corpus.
+func capturedPayloads(tb testing.TB) [][]byte {
+	tb.Helper()
+	files, err := filepath.Glob(filepath.Join("testdata", "*.pcapng"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var payloads [][]byte
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		for len(data) >= 12 {
+			typ := binary.LittleEndian.Uint32(data)
+			size := int(binary.LittleEndian.Uint32(data[4:]))
+			if size < 12 || size > len(data) {
+				tb.Fatalf("%s: bad block length %d", file, size)
+			}
+			// enhanced packet blocks with an IPv4/UDP frame
+			if body := data[8 : size-4]; typ == 6 && len(body) >= 20 {
+				captured := int(binary.LittleEndian.Uint32(body[12:]))
+				frame := body[20:]
+				if captured <= len(frame) && captured >= 28 {
+					frame = frame[:captured]
+					ihl := int(frame[0]&0x0f) * 4
+					if len(frame) >= ihl+8 {
+						payloads = append(payloads, frame[ihl+8:])
+					}
+				}
+			}
+			data = data[size:]
+		}
+	}
+	if len(payloads) == 0 {
+		tb.Fatal("no captured packets in testdata")
+	}
+	return payloads
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, payload := range capturedPayloads(f) {
+		f.Add(payload, false)
+		f.Add(payload, true)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, fromServer bool) {
+		dir := ClientToServer
+		if fromServer {
+			dir = ServerToClient
+		}
+		pkt, err := Decode(data, dir)
+		if err != nil {
+			return
+		}
+		if pkt.Kind != KindSequenced {
+			return
+		}
+		header, ok := DecodeHeader(data, dir)
+		if !ok || header != pkt.Header {
+			t.Fatalf("header %+v, DecodeHeader %+v %v", pkt.Header, header, ok)
+		}
+		size := len(pkt.Payload)
+		for _, f := range pkt.Fragments {
+			size += len(f.Data)
+			if f.Present && len(f.Data) != int(f.Length) {
+				t.Fatalf("fragment of %d bytes, header says %d", len(f.Data), f.Length)
+			}
+		}
+		if size > len(data) {
+			t.Fatalf("decoded %d bytes out of %d", size, len(data))
+		}
+		_ = pkt.StringCommands()
+
+		// the receiving end must survive whatever arrives
+		receiver := NewChan(ServerToClient, 0)
+		if fromServer {
+			receiver = NewChan(ClientToServer, 27005)
+		}
+		receiver.Process(data)
+	})
+}
+
+func FuzzDecodeHeader(f *testing.F) {
+	for _, payload := range capturedPayloads(f) {
+		f.Add(payload, false)
+		f.Add(payload, true)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, fromServer bool) {
+		dir := ClientToServer
+		if fromServer {
+			dir = ServerToClient
+		}
+		header, ok := DecodeHeader(data, dir)
+		if !ok {
+			return
+		}
+		if len(data) < 8 {
+			t.Fatalf("header decoded from %d bytes", len(data))
+		}
+		w1 := binary.LittleEndian.Uint32(data)
+		if w1 == ConnectionlessHeader || w1 == SplitHeader {
+			t.Fatal("out-of-band packet decoded as sequenced")
+		}
+		if header.Sequence&^sequenceMask != 0 || header.Ack&ReliableBit != 0 {
+			t.Fatalf("flag bits left in %+v", header)
+		}
+	})
+}
+
+func TestReadBytesBounds(t *testing.T) {
+	for _, n := range []int{-1, -8, 5, 1 << 60} {
+		r := NewBitReader([]byte{1, 2, 3, 4})
+		if b := r.ReadBytes(n); b != nil || r.Err() != ErrOverflow {
+			t.Errorf("ReadBytes(%d) = %v, %v", n, b, r.Err())
+		}
+	}
+	r := NewBitReader([]byte{1, 2, 3, 4})
+	r.ReadBit()
+	if b := r.ReadBytes(3); len(b) != 3 || r.Err() != nil {
+		t.Errorf("unaligned ReadBytes(3) = %v, %v", b, r.Err())
+	}
+}
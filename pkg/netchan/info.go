@@ -0,0 +1,57 @@
+package netchan
+
+import (
+	"strings"
+)
+
+// Info is a parsed info string ("\key\value\key\value").
+type Info map[string]string
+
+// ParseInfo parses an info string. Malformed trailing keys are ignored.
+func ParseInfo(s string) Info {
+	info := Info{}
+	items := strings.Split(strings.TrimPrefix(s, "\\"), "\\")
+	for i := 0; i+1 < len(items); i += 2 {
+		info[items[i]] = items[i+1]
+	}
+	return info
+}
+
+// String encodes the info back into the "\key\value" form.
+func (i Info) String() string {
+	var b strings.Builder
+	for k, v := range i {
+		b.WriteByte('\\')
+		b.WriteString(k)
+		b.WriteByte('\\')
+		b.WriteString(v)
+	}
+	return b.String()
+}
+
+// Tokenize splits a console command line the way the engine does:
+// by whitespace, keeping double quoted strings together.
+func Tokenize(line string) []string {
+	var tokens []string
+	for {
+		line = strings.TrimLeft(line, " \t\r\n")
+		if line == "" {
+			return tokens
+		}
+		if line[0] == '"' {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				return append(tokens, line[1:])
+			}
+			tokens = append(tokens, line[1:end+1])
+			line = line[end+2:]
+			continue
+		}
+		end := strings.IndexAny(line, " \t\r\n")
+		if end < 0 {
+			return append(tokens, line)
+		}
+		tokens = append(tokens, line[:end])
+		line = line[end:]
+	}
+}
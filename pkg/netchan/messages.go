@@ -0,0 +1,230 @@
+package netchan
+
+// Server to client message opcodes (svc_*) of protocol 49.
+const (
+	SvcBad             = 0
+	SvcNop             = 1
+	SvcDisconnect      = 2
+	SvcEvent           = 3
+	SvcChanging        = 4
+	SvcSetView         = 5
+	SvcSound           = 6
+	SvcTime            = 7
+	SvcPrint           = 8
+	SvcStuffText       = 9
+	SvcSetAngle        = 10
+	SvcServerData      = 11
+	SvcLightStyle      = 12
+	SvcUpdateUserInfo  = 13
+	SvcDeltaTable      = 14
+	SvcClientData      = 15
+	SvcResource        = 16
+	SvcPings           = 17
+	SvcParticle        = 18
+	SvcRestoreSound    = 19
+	SvcSpawnStatic     = 20
+	SvcEventReliable   = 21
+	SvcSpawnBaseline   = 22
+	SvcTempEntity      = 23
+	SvcSetPause        = 24
+	SvcSignonNum       = 25
+	SvcCenterPrint     = 26
+	SvcIntermission    = 30
+	SvcCDTrack         = 32
+	SvcWeaponAnim      = 35
+	SvcRoomType        = 37
+	SvcUserMessage     = 39
+	SvcPacketEntities  = 40
+	SvcDeltaPacketEnts = 41
+	SvcChoke           = 42
+	SvcResourceList    = 43
+	SvcCrosshairAngle  = 47
+	SvcFileTxferFailed = 49
+	SvcHLTV            = 50
+	SvcDirector        = 51
+	SvcVoiceInit       = 52
+	SvcVoiceData       = 53
+	SvcResourceLoc     = 56
+	SvcQueryCvarValue  = 57
+	SvcQueryCvarValue2 = 58
+	SvcExec            = 59
+)
+
+// Client to server message opcodes (clc_*) of protocol 49.
+const (
+	ClcBad             = 0
+	ClcNop             = 1
+	ClcMove            = 2
+	ClcStringCmd       = 3
+	ClcDelta           = 4
+	ClcResourceList    = 5
+	ClcUserInfo        = 6
+	ClcFileConsistency = 7
+	ClcVoiceData       = 8
+	ClcCvarValue       = 9
+	ClcCvarValue2      = 10
+)
+
+var svcNames = map[byte]string{
+	SvcBad: "svc_bad", SvcNop: "svc_nop", SvcDisconnect: "svc_disconnect", SvcEvent: "svc_event",
+	SvcChanging: "svc_changing", SvcSetView: "svc_setview", SvcSound: "svc_sound", SvcTime: "svc_time",
+	SvcPrint: "svc_print", SvcStuffText: "svc_stufftext", SvcSetAngle: "svc_setangle",
+	SvcServerData: "svc_serverdata", SvcLightStyle: "svc_lightstyle", SvcUpdateUserInfo: "svc_updateuserinfo",
+	SvcDeltaTable: "svc_deltatable", SvcClientData: "svc_clientdata", SvcResource: "svc_resource",
+	SvcPings: "svc_pings", SvcParticle: "svc_particle", SvcRestoreSound: "svc_restoresound",
+	SvcSpawnStatic: "svc_spawnstatic", SvcEventReliable: "svc_event_reliable",
+	SvcSpawnBaseline: "svc_spawnbaseline", SvcTempEntity: "svc_temp_entity", SvcSetPause: "svc_setpause",
+	SvcSignonNum: "svc_signonnum", SvcCenterPrint: "svc_centerprint", SvcIntermission: "svc_intermission",
+	SvcCDTrack: "svc_cdtrack", SvcWeaponAnim: "svc_weaponanim", SvcRoomType: "svc_roomtype",
+	SvcUserMessage: "svc_usermessage", SvcPacketEntities: "svc_packetentities",
+	SvcDeltaPacketEnts: "svc_deltapacketentities", SvcChoke: "svc_choke", SvcResourceList: "svc_resourcelist",
+	SvcCrosshairAngle: "svc_crosshairangle", SvcFileTxferFailed: "svc_filetxferfailed", SvcHLTV: "svc_hltv",
+	SvcDirector: "svc_director", SvcVoiceInit: "svc_voiceinit", SvcVoiceData: "svc_voicedata",
+	SvcResourceLoc: "svc_resourcelocation", SvcQueryCvarValue: "svc_querycvarvalue",
+	SvcQueryCvarValue2: "svc_querycvarvalue2", SvcExec: "svc_exec",
+}
+
+var clcNames = map[byte]string{
+	ClcBad: "clc_bad", ClcNop: "clc_nop", ClcMove: "clc_move", ClcStringCmd: "clc_stringcmd",
+	ClcDelta: "clc_delta", ClcResourceList: "clc_resourcelist", ClcUserInfo: "clc_userinfo",
+	ClcFileConsistency: "clc_fileconsistency", ClcVoiceData: "clc_voicedata",
+	ClcCvarValue: "clc_cvarvalue", ClcCvarValue2: "clc_cvarvalue2",
+}
+
+// OpName returns the symbolic name of a message opcode.
+func OpName(op byte, dir Direction) string {
+	names := clcNames
+	if dir == ServerToClient {
+		names = svcNames
+	}
+	if name, ok := names[op]; ok {
+		return name
+	}
+	if dir == ServerToClient && op > SvcExec {
+		return "svc_usermsg"
+	}
+	return "unknown"
+}
+
+// Message is a single decoded svc_* or clc_* message.
+type Message struct {
+	Op   byte
+	Name string
+	// String is the text argument of print, stufftext, stringcmd and similar messages.
+	String string
+	// Values holds the numeric arguments in wire order.
+	Values []float64
+	// ServerData is set for svc_serverdata.
+	ServerData *ServerData
+}
+
+// ServerData is the leading part of svc_serverdata, sent on every map load.
+type ServerData struct {
+	Protocol   int
+	SpawnCount int
+	MapCRC     uint32
+	PlayerNum  int
+	MaxClients int
+	MaxEdicts  int
+	MaxModels  int
+	// MapName is the map path, e.g. "maps/crossfire.bsp".
+	MapName string
+	// MapTitle is the map message (worldspawn "message").
+	MapTitle string
+}
+
+// ParseMessages decodes a message stream. It returns the messages decoded so
+// far and partial=true when it stopped at a message it cannot skip.
+func ParseMessages(payload []byte, dir Direction) (messages []Message, partial bool) {
+	r := NewBitReader(payload)
+	for r.BitsLeft() >= 8 {
+		op := r.ReadUint8()
+		msg := Message{Op: op, Name: OpName(op, dir)}
+		var ok bool
+		if dir == ServerToClient {
+			ok = parseServerMessage(r, &msg)
+		} else {
+			ok = parseClientMessage(r, &msg)
+		}
+		if r.Err() != nil {
+			return messages, true
+		}
+		messages = append(messages, msg)
+		if !ok {
+			return messages, true
+		}
+	}
+	return messages, false
+}
+
+// parseServerMessage reads the body of a svc_* message. It returns false
+// when the rest of the stream cannot be decoded after this message.
+func parseServerMessage(r *BitReader, msg *Message) bool {
+	switch msg.Op {
+	case SvcNop, SvcChoke, SvcIntermission:
+	case SvcDisconnect, SvcStuffText, SvcCenterPrint, SvcFileTxferFailed, SvcResourceLoc, SvcQueryCvarValue:
+		msg.String = r.ReadString()
+	case SvcPrint:
+		msg.Values = []float64{float64(r.ReadUint8())}
+		msg.String = r.ReadString()
+	case SvcTime:
+		msg.Values = []float64{float64(r.ReadFloat32())}
+	case SvcSetView, SvcRoomType:
+		msg.Values = []float64{float64(r.ReadInt16())}
+	case SvcSetPause, SvcSignonNum:
+		msg.Values = []float64{float64(r.ReadUint8())}
+	case SvcCDTrack, SvcWeaponAnim, SvcCrosshairAngle:
+		msg.Values = []float64{float64(r.ReadUint8()), float64(r.ReadUint8())}
+	case SvcQueryCvarValue2:
+		msg.Values = []float64{float64(r.ReadUint32())}
+		msg.String = r.ReadString()
+	case SvcServerData:
+		msg.ServerData = &ServerData{
+			Protocol:   int(r.ReadUint32()),
+			SpawnCount: int(r.ReadUint32()),
+			MapCRC:     r.ReadUint32(),
+			PlayerNum:  int(r.ReadUint8()),
+			MaxClients: int(r.ReadUint8()),
+			MaxEdicts:  int(r.ReadUint16()),
+			MaxModels:  int(r.ReadUint16()),
+			MapName:    r.ReadString(),
+			MapTitle:   r.ReadString(),
+		}
+		return false
+	default:
+		return false
+	}
+	return true
+}
+
+// parseClientMessage reads the body of a clc_* message. It returns false
+// when the rest of the stream cannot be decoded after this message.
+func parseClientMessage(r *BitReader, msg *Message) bool {
+	switch msg.Op {
+	case ClcNop:
+	case ClcStringCmd, ClcUserInfo:
+		msg.String = r.ReadString()
+	case ClcDelta:
+		msg.Values = []float64{float64(r.ReadUint8())}
+	case ClcCvarValue:
+		msg.String = r.ReadString()
+	case ClcCvarValue2:
+		msg.Values = []float64{float64(r.ReadUint32())}
+		msg.String = r.ReadString() + " " + r.ReadString()
+	default:
+		return false
+	}
+	return true
+}
+
+// StringCommands returns the clc_stringcmd texts of a client packet,
+// e.g. "say hello" or "kill".
+func (pkt *Packet) StringCommands() []string {
+	var out []string
+	for _, msg := range pkt.Messages {
+		if msg.Op == ClcStringCmd && pkt.Direction == ClientToServer {
+			out = append(out, msg.String)
+		}
+	}
+	return out
+}
@@ -0,0 +1,222 @@
+// Package netchan decodes the GoldSrc/Xash3D netchan wire format carried by
+// the packets flowing through Xash3DNetwork.
+//
+// A datagram is either connectionless (out-of-band text commands such as
+// "getchallenge" or "connect"), a Xash split packet part, or a sequenced
+// netchan packet with sequence/ack numbers, reliable bits, optional
+// fragment headers and a stream of svc_*/clc_* messages.
+//
+// The package is pure Go and does not depend on the engine, so it can be
+// used by tools running outside of the server process. Packets received by
+// Xash3DNetwork.RecvFrom are decoded with ClientToServer, packets passed to
+// SendTo with ServerToClient:
+//
+//	pkt, err := netchan.Decode(packet.Data, netchan.ClientToServer)
+package netchan
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrShortPacket = errors.New("netchan: packet too short")
+	ErrBadSplit    = errors.New("netchan: malformed split packet")
+)
+
+// Direction tells who sent a packet; the header layout depends on it.
+type Direction uint8
+
+const (
+	ClientToServer Direction = iota
+	ServerToClient
+)
+
+const (
+	// ConnectionlessHeader prefixes out-of-band packets.
+	ConnectionlessHeader = 0xFFFFFFFF
+	// SplitHeader prefixes the parts of a Xash split packet.
+	SplitHeader = 0xFFFFFFFE
+
+	// ReliableBit marks a sequence carrying reliable data
+	// or an ack of the peer's reliable data.
+	ReliableBit = 1 << 31
+	// FragmentBit marks a sequence carrying fragment headers.
+	FragmentBit = 1 << 30
+	// sequenceMask strips the flag bits from a sequence number.
+	sequenceMask = FragmentBit - 1
+
+	// MaxStreams is the number of reliable streams (normal and file).
+	MaxStreams = 2
+
+	// ProtocolVersion is the Xash3D FWGS network protocol.
+	ProtocolVersion = 49
+	// LegacyProtocolVersion is the GoldSrc compatible protocol.
+	LegacyProtocolVersion = 48
+)
+
+// Kind classifies a datagram.
+type Kind uint8
+
+const (
+	KindSequenced Kind = iota
+	KindConnectionless
+	KindSplit
+)
+
+// Header is the sequenced netchan packet header.
+type Header struct {
+	// Sequence is the outgoing sequence number of the sender.
+	Sequence uint32
+	// Reliable is set when the packet carries reliable data.
+	Reliable bool
+	// Fragmented is set when fragment headers follow.
+	Fragmented bool
+	// Ack is the last sequence the sender received from its peer.
+	Ack uint32
+	// AckReliable is the reliable sequence bit the sender acknowledges.
+	AckReliable bool
+	// QPort is the client port id, sent by clients only.
+	QPort uint16
+}
+
+// Fragment describes the part of a fragmented reliable buffer a packet carries.
+type Fragment struct {
+	Present bool
+	// ID is the fragment id: buffer index in the high word, buffer count in the low word.
+	ID     uint32
+	Offset uint16
+	Length uint16
+	// Data is the fragment payload.
+	Data []byte
+}
+
+// Index returns the number of the fragment buffer (1 based).
+func (f Fragment) Index() int { return int(f.ID >> 16) }
+
+// Count returns the total number of fragment buffers.
+func (f Fragment) Count() int { return int(f.ID & 0xffff) }
+
+// Packet is a decoded datagram.
+type Packet struct {
+	Kind      Kind
+	Direction Direction
+
+	// Connectionless is set for KindConnectionless.
+	Connectionless *Connectionless
+	// Split is set for KindSplit.
+	Split *Split
+
+	// Header and Fragments are set for KindSequenced.
+	Header    Header
+	Fragments [MaxStreams]Fragment
+	// Payload holds the message stream following the headers and fragment data.
+	Payload []byte
+	// Assembled is the completed fragment buffer of the normal stream, set by
+	// Chan.Process on the packet carrying the last fragment.
+	Assembled []byte
+	// Messages are the decoded messages of Payload. Decoding stops at the
+	// first message whose layout is not known; Partial is set in that case.
+	Messages []Message
+	Partial  bool
+}
+
+// Decode decodes a datagram sent in the given direction.
+func Decode(data []byte, dir Direction) (*Packet, error) {
+	if len(data) < 4 {
+		return nil, ErrShortPacket
+	}
+	pkt := &Packet{Direction: dir}
+	switch binary.LittleEndian.Uint32(data) {
+	case ConnectionlessHeader:
+		pkt.Kind = KindConnectionless
+		pkt.Connectionless = ParseConnectionless(data[4:])
+		return pkt, nil
+	case SplitHeader:
+		split, err := ParseSplit(data)
+		if err != nil {
+			return nil, err
+		}
+		pkt.Kind = KindSplit
+		pkt.Split = split
+		return pkt, nil
+	}
+
+	if err := pkt.decodeSequenced(data); err != nil {
+		return nil, err
+	}
+	pkt.Messages, pkt.Partial = ParseMessages(pkt.Payload, dir)
+	return pkt, nil
+}
+
+// DecodeHeader decodes only the sequenced header of a datagram. It is cheap
+// enough to be used on every packet, e.g. to classify reliable traffic.
+func DecodeHeader(data []byte, dir Direction) (Header, bool) {
+	if len(data) < 8 {
+		return Header{}, false
+	}
+	w1 := binary.LittleEndian.Uint32(data[0:])
+	if w1 == ConnectionlessHeader || w1 == SplitHeader {
+		return Header{}, false
+	}
+	w2 := binary.LittleEndian.Uint32(data[4:])
+	h := Header{
+		Sequence:    w1 & sequenceMask,
+		Reliable:    w1&ReliableBit != 0,
+		Fragmented:  w1&FragmentBit != 0,
+		Ack:         w2 &^ ReliableBit,
+		AckReliable: w2&ReliableBit != 0,
+	}
+	if dir == ClientToServer && len(data) >= 10 {
+		h.QPort = binary.LittleEndian.Uint16(data[8:])
+	}
+	return h, true
+}
+
+func (pkt *Packet) decodeSequenced(data []byte) error {
+	header, ok := DecodeHeader(data, pkt.Direction)
+	if !ok {
+		return ErrShortPacket
+	}
+	pkt.Kind = KindSequenced
+	pkt.Header = header
+
+	r := NewBitReader(data)
+	r.ReadUint32()
+	r.ReadUint32()
+	if pkt.Direction == ClientToServer {
+		r.ReadUint16()
+	}
+
+	if header.Reliable && header.Fragmented {
+		for i := range pkt.Fragments {
+			f := &pkt.Fragments[i]
+			if r.ReadUint8() == 0 {
+				continue
+			}
+			f.Present = true
+			f.ID = r.ReadUint32()
+			f.Offset = r.ReadUint16()
+			f.Length = r.ReadUint16()
+		}
+	}
+	if err := r.Err(); err != nil {
+		return err
+	}
+
+	rest := data[r.BitPos()/8:]
+	for i := range pkt.Fragments {
+		f := &pkt.Fragments[i]
+		if !f.Present {
+			continue
+		}
+		n := int(f.Length)
+		if n > len(rest) {
+			return ErrShortPacket
+		}
+		f.Data = rest[:n]
+		rest = rest[n:]
+	}
+	pkt.Payload = rest
+	return nil
+}
@@ -0,0 +1,182 @@
+// Command gencap writes the synthetic session capture seeding the netchan
+// fuzz tests. It runs the handshake, signon, chat, fragmented and split
+// traffic of one client through a pair of netchan.Chan and records it with
+// capture.Writer, so the file follows the wire format without coming from
+// a real server:
+//
+//	go run ./testdata/gencap testdata/session.pcapng
+//
+// It links the engine libraries like every user of the root package.
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"github.com/yohimik/goxash3d-fwgs/pkg/capture"
+	"github.com/yohimik/goxash3d-fwgs/pkg/netchan"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: gencap <file.pcapng>")
+		os.Exit(2)
+	}
+	f, err := os.Create(os.Args[1])
+	if err != nil {
+		panic(err)
+	}
+	w, err := capture.NewWriter(f)
+	if err != nil {
+		panic(err)
+	}
+	peer, _ := goxash3d_fwgs.ParseAddr("10.0.0.2:27005")
+	server, _ := goxash3d_fwgs.ParseAddr("10.0.0.1:27015")
+	t := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+	rec := func(dir capture.Direction, data []byte) {
+		t = t.Add(15 * time.Millisecond)
+		if err := w.Write(capture.Record{Time: t, Direction: dir, Packet: goxash3d_fwgs.Packet{Addr: peer, Data: append([]byte(nil), data...)}, Server: server}); err != nil {
+			panic(err)
+		}
+	}
+	oob := func(s string) []byte { return append([]byte{0xff, 0xff, 0xff, 0xff}, s...) }
+
+	rec(capture.Inbound, oob("getchallenge\n"))
+	rec(capture.Outbound, oob("challenge 1786323411\n"))
+	rec(capture.Inbound, oob(`connect 49 1786323411 "\prot\3\unique\-1\raw\steam\cdkey\2b4a8f1c3d9e7f60a1b2c3d4e5f60718\qport\27005\ext\1" "\name\Player\model\gordon\topcolor\30\bottomcolor\6\rate\25000\cl_updaterate\60\cl_lw\1\cl_lc\1"`+"\n"))
+	rec(capture.Outbound, oob("client_connect\n"))
+	rec(capture.Inbound, oob("info 49\n"))
+	rec(capture.Outbound, oob("print\nServer is full.\n"))
+
+	cl := netchan.NewChan(netchan.ClientToServer, 27005)
+	sv := netchan.NewChan(netchan.ServerToClient, 0)
+
+	str := func(s string) []byte {
+		b := &netchan.BitWriter{}
+		b.WriteUint8(netchan.ClcStringCmd)
+		b.WriteString(s)
+		return b.Bytes()
+	}
+	exchange := func(c *netchan.Chan, other *netchan.Chan, dir capture.Direction, unreliable []byte) {
+		pkt := c.Transmit(unreliable, len(unreliable)*8)
+		rec(dir, pkt)
+		other.Process(pkt)
+	}
+
+	cl.QueueReliable(str("new"))
+	exchange(cl, sv, capture.Inbound, nil)
+
+	b := &netchan.BitWriter{}
+	b.WriteUint8(netchan.SvcPrint)
+	b.WriteUint8(2)
+	b.WriteString("\nXash3D FWGS server\n")
+	b.WriteUint8(netchan.SvcServerData)
+	b.WriteUint32(netchan.ProtocolVersion)
+	b.WriteUint32(1)
+	b.WriteUint32(0x5a3c91e2)
+	b.WriteUint8(0)
+	b.WriteUint8(12)
+	b.WriteUint16(1200)
+	b.WriteUint16(512)
+	b.WriteString("maps/crossfire.bsp")
+	b.WriteString("Crossfire")
+	sv.QueueReliable(b.Bytes())
+	exchange(sv, cl, capture.Outbound, nil)
+
+	cl.QueueReliable(str("sendres"))
+	exchange(cl, sv, capture.Inbound, nil)
+
+	b = &netchan.BitWriter{}
+	b.WriteUint8(netchan.SvcStuffText)
+	b.WriteString("fullserverinfo \"\\hostname\\XashDS\\maxplayers\\12\"\n")
+	b.WriteUint8(netchan.SvcSignonNum)
+	b.WriteUint8(1)
+	b.WriteUint8(netchan.SvcSetView)
+	b.WriteInt16(1)
+	sv.QueueReliable(b.Bytes())
+	exchange(sv, cl, capture.Outbound, nil)
+
+	cl.QueueReliable(str("spawn 1 0"))
+	exchange(cl, sv, capture.Inbound, nil)
+	cl.QueueReliable(str("begin 1"))
+	exchange(cl, sv, capture.Inbound, nil)
+
+	// unreliable traffic: a usercmd the decoder cannot skip, server time and a choke
+	move := &netchan.BitWriter{}
+	move.WriteUint8(netchan.ClcMove)
+	move.WriteUint8(17)
+	move.WriteUint8(0x8e)
+	move.WriteUint8(0)
+	move.WriteBytes([]byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc})
+	for i := 0; i < 3; i++ {
+		exchange(cl, sv, capture.Inbound, move.Bytes())
+		u := &netchan.BitWriter{}
+		u.WriteUint8(netchan.SvcTime)
+		u.WriteFloat32(12.5 + float32(i)*0.016)
+		u.WriteUint8(netchan.SvcNop)
+		exchange(sv, cl, capture.Outbound, u.Bytes())
+	}
+
+	cl.QueueReliable(str("say \"hello world\""))
+	exchange(cl, sv, capture.Inbound, nil)
+	cl.QueueReliable(str("say_team rtv"))
+	exchange(cl, sv, capture.Inbound, nil)
+	ui := &netchan.BitWriter{}
+	ui.WriteUint8(netchan.ClcUserInfo)
+	ui.WriteString("\\name\\Player2\\rate\\30000")
+	cl.QueueReliable(ui.Bytes())
+	exchange(cl, sv, capture.Inbound, nil)
+
+	// a fragmented reliable buffer in two packets
+	payload := make([]byte, 0, 64)
+	for i := 0; i < 48; i++ {
+		payload = append(payload, byte(i*7))
+	}
+	for idx := 1; idx <= 2; idx++ {
+		h := &netchan.BitWriter{}
+		h.WriteUint32(uint32(40+idx) | netchan.ReliableBit | netchan.FragmentBit)
+		h.WriteUint32(20)
+		h.WriteUint8(1)
+		h.WriteUint32(uint32(idx)<<16 | 2)
+		h.WriteUint16(uint16((idx - 1) * 24))
+		h.WriteUint16(24)
+		h.WriteUint8(0)
+		h.WriteBytes(payload[(idx-1)*24 : idx*24])
+		h.WriteUint8(netchan.SvcIntermission)
+		rec(capture.Outbound, h.Bytes())
+	}
+
+	// a datagram split in two parts
+	full := sv.Transmit([]byte{netchan.SvcNop}, 8)
+	for idx := 0; idx < 2; idx++ {
+		part := make([]byte, 10)
+		binary.LittleEndian.PutUint32(part, netchan.SplitHeader)
+		binary.LittleEndian.PutUint32(part[4:], 77)
+		binary.LittleEndian.PutUint16(part[8:], uint16(idx<<8|2))
+		half := len(full) / 2
+		if idx == 0 {
+			part = append(part, full[:half]...)
+		} else {
+			part = append(part, full[half:]...)
+		}
+		rec(capture.Outbound, part)
+	}
+
+	b = &netchan.BitWriter{}
+	b.WriteUint8(netchan.SvcDisconnect)
+	b.WriteString("Server shutting down\n")
+	sv.QueueReliable(b.Bytes())
+	exchange(sv, cl, capture.Outbound, nil)
+	cl.QueueReliable(str("disconnect"))
+	exchange(cl, sv, capture.Inbound, nil)
+
+	if err := w.Flush(); err != nil {
+		panic(err)
+	}
+	if err := f.Close(); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,89 @@
+// Package netsim simulates adverse network conditions in front of any
+// Xash3DNetwork, for testing netcode and lag compensation.
+//
+// Network is a decorator: transports push inbound packets into it with
+// PushPacket and the engine sends through it with SendTo, and both
+// directions are delayed, dropped, duplicated, reordered or throttled
+// according to the Conditions of the peer address.
+package netsim
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Conditions describes the impairments applied to a peer, in each direction.
+type Conditions struct {
+	// Latency is the base one-way delay.
+	Latency time.Duration
+	// Jitter is the maximum random deviation added to or removed from Latency.
+	Jitter time.Duration
+	// Loss is the probability (0..1) of dropping a packet.
+	Loss float64
+	// Duplicate is the probability (0..1) of delivering a packet twice.
+	Duplicate float64
+	// Reorder is the probability (0..1) of holding a packet back
+	// so that packets sent after it overtake it.
+	Reorder float64
+	// Bandwidth caps the throughput in bytes per second, 0 means unlimited.
+	Bandwidth int
+}
+
+// IsZero reports whether the conditions leave traffic untouched.
+func (c Conditions) IsZero() bool {
+	return c == Conditions{}
+}
+
+// conditionsJSON is the wire form of Conditions with human readable durations.
+type conditionsJSON struct {
+	Latency   string  `json:"latency,omitempty"`
+	Jitter    string  `json:"jitter,omitempty"`
+	Loss      float64 `json:"loss,omitempty"`
+	Duplicate float64 `json:"duplicate,omitempty"`
+	Reorder   float64 `json:"reorder,omitempty"`
+	Bandwidth int     `json:"bandwidth,omitempty"`
+}
+
+// MarshalJSON encodes durations as strings such as "80ms".
+func (c Conditions) MarshalJSON() ([]byte, error) {
+	v := conditionsJSON{
+		Loss:      c.Loss,
+		Duplicate: c.Duplicate,
+		Reorder:   c.Reorder,
+		Bandwidth: c.Bandwidth,
+	}
+	if c.Latency != 0 {
+		v.Latency = c.Latency.String()
+	}
+	if c.Jitter != 0 {
+		v.Jitter = c.Jitter.String()
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes durations written as strings such as "80ms".
+func (c *Conditions) UnmarshalJSON(data []byte) error {
+	var v conditionsJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	out := Conditions{
+		Loss:      v.Loss,
+		Duplicate: v.Duplicate,
+		Reorder:   v.Reorder,
+		Bandwidth: v.Bandwidth,
+	}
+	var err error
+	if v.Latency != "" {
+		if out.Latency, err = time.ParseDuration(v.Latency); err != nil {
+			return err
+		}
+	}
+	if v.Jitter != "" {
+		if out.Jitter, err = time.ParseDuration(v.Jitter); err != nil {
+			return err
+		}
+	}
+	*c = out
+	return nil
+}
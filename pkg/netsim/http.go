@@ -0,0 +1,79 @@
+package netsim
+
+import (
+	"encoding/json"
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"net/http"
+)
+
+// HandlerOptions configures Network.Handler.
+type HandlerOptions struct {
+	// Authorize returns false to reject the request. Without it every
+	// request is rejected, the conditions affect every player.
+	Authorize func(r *http.Request) bool
+}
+
+// state is the JSON document served by Handler.
+type state struct {
+	Default Conditions            `json:"default"`
+	Peers   map[string]Conditions `json:"peers"`
+}
+
+// Handler exposes the conditions over HTTP/JSON for runtime adjustment.
+//
+//	GET    /            current default and per-peer conditions
+//	PUT    /?addr=a.b.c.d:port  set the peer conditions (body: Conditions)
+//	PUT    /            set the default conditions
+//	DELETE /?addr=a.b.c.d:port  clear the peer override
+func (n *Network) Handler(opts HandlerOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if opts.Authorize == nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		if !opts.Authorize(r) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		addrParam := r.URL.Query().Get("addr")
+		var addr goxash3d_fwgs.Addr
+		if addrParam != "" {
+			var ok bool
+			if addr, ok = goxash3d_fwgs.ParseAddr(addrParam); !ok {
+				http.Error(w, "invalid addr", http.StatusBadRequest)
+				return
+			}
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var c Conditions
+			if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if addrParam == "" {
+				n.SetDefault(c)
+			} else {
+				n.Set(addr, c)
+			}
+		case http.MethodDelete:
+			if addrParam == "" {
+				n.SetDefault(Conditions{})
+			} else {
+				n.Clear(addr)
+			}
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		out := state{Default: n.Default(), Peers: map[string]Conditions{}}
+		for a, c := range n.Peers() {
+			out.Peers[a.String()] = c
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	})
+}
@@ -0,0 +1,293 @@
+package netsim
+
+import (
+	"container/heap"
+	"errors"
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// ErrNotPusher is returned by New for a network that cannot take inbound packets.
+var ErrNotPusher = errors.New("netsim: wrapped network does not implement PacketPusher")
+
+// maxBacklog bounds how far a bandwidth capped link may queue ahead
+// before further packets are dropped (tail drop).
+const maxBacklog = time.Second
+
+// minReorderDelay is the minimal hold back of a reordered packet.
+const minReorderDelay = 10 * time.Millisecond
+
+type direction int
+
+const (
+	inbound direction = iota
+	outbound
+)
+
+type linkKey struct {
+	addr goxash3d_fwgs.Addr
+	dir  direction
+}
+
+// scheduled is a delayed packet waiting in the scheduler heap.
+type scheduled struct {
+	at    time.Time
+	seq   uint64
+	dir   direction
+	fd    int
+	flags int
+	pkt   goxash3d_fwgs.Packet
+}
+
+type schedule []*scheduled
+
+func (s schedule) Len() int { return len(s) }
+func (s schedule) Less(i, j int) bool {
+	if s[i].at.Equal(s[j].at) {
+		return s[i].seq < s[j].seq
+	}
+	return s[i].at.Before(s[j].at)
+}
+func (s schedule) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s *schedule) Push(x any)   { *s = append(*s, x.(*scheduled)) }
+func (s *schedule) Pop() any {
+	old := *s
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*s = old[:len(old)-1]
+	return item
+}
+
+// Network wraps a Xash3DNetwork and impairs the traffic passing through it.
+//
+// Inbound packets enter through PushPacket and are forwarded to the wrapped
+// network's PushPacket; outbound packets enter through SendTo/SendToBatch.
+type Network struct {
+	goxash3d_fwgs.Xash3DNetwork
+
+	mu       sync.Mutex
+	defaults Conditions
+	peers    map[goxash3d_fwgs.Addr]Conditions
+	busy     map[linkKey]time.Time
+	queue    schedule
+	seq      uint64
+
+	wake   chan struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+// New wraps the network and starts the delivery scheduler. The network
+// must implement goxash3d_fwgs.PacketPusher, inbound packets are lost
+// otherwise. Traffic is untouched until conditions are configured.
+func New(net goxash3d_fwgs.Xash3DNetwork) (*Network, error) {
+	if _, ok := net.(goxash3d_fwgs.PacketPusher); !ok {
+		return nil, ErrNotPusher
+	}
+	n := &Network{
+		Xash3DNetwork: net,
+		peers:         make(map[goxash3d_fwgs.Addr]Conditions),
+		busy:          make(map[linkKey]time.Time),
+		wake:          make(chan struct{}, 1),
+		closed:        make(chan struct{}),
+	}
+	go n.run()
+	return n, nil
+}
+
+// Close stops the scheduler. Packets still waiting are discarded.
+func (n *Network) Close() {
+	n.once.Do(func() { close(n.closed) })
+}
+
+// SetDefault sets the conditions of peers without their own settings.
+func (n *Network) SetDefault(c Conditions) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.defaults = c
+}
+
+// Default returns the conditions of peers without their own settings.
+func (n *Network) Default() Conditions {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.defaults
+}
+
+// Set overrides the conditions of a single peer.
+func (n *Network) Set(addr goxash3d_fwgs.Addr, c Conditions) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.peers[addr] = c
+}
+
+// Clear removes the override of a peer, so it uses the defaults again.
+func (n *Network) Clear(addr goxash3d_fwgs.Addr) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.peers, addr)
+	delete(n.busy, linkKey{addr, inbound})
+	delete(n.busy, linkKey{addr, outbound})
+}
+
+// Conditions returns the conditions in effect for a peer.
+func (n *Network) Conditions(addr goxash3d_fwgs.Addr) Conditions {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.conditions(addr)
+}
+
+// Peers returns every per-peer override.
+func (n *Network) Peers() map[goxash3d_fwgs.Addr]Conditions {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	out := make(map[goxash3d_fwgs.Addr]Conditions, len(n.peers))
+	for addr, c := range n.peers {
+		out[addr] = c
+	}
+	return out
+}
+
+func (n *Network) conditions(addr goxash3d_fwgs.Addr) Conditions {
+	if c, ok := n.peers[addr]; ok {
+		return c
+	}
+	return n.defaults
+}
+
+// PushPacket impairs an inbound packet and forwards it to the wrapped network.
+func (n *Network) PushPacket(pkt goxash3d_fwgs.Packet) {
+	pusher := n.Xash3DNetwork.(goxash3d_fwgs.PacketPusher)
+	if n.Conditions(pkt.Addr).IsZero() {
+		pusher.PushPacket(pkt)
+		return
+	}
+	n.enqueue(&scheduled{dir: inbound, pkt: pkt})
+}
+
+// SendTo impairs an outbound packet and sends it through the wrapped network.
+// Delayed packets report success immediately, like a real send buffer.
+func (n *Network) SendTo(fd int, pkt goxash3d_fwgs.Packet, flags int) int {
+	if n.Conditions(pkt.Addr).IsZero() {
+		return n.Xash3DNetwork.SendTo(fd, pkt, flags)
+	}
+	// the engine reuses its buffer once SendTo returns
+	pkt.Data = append([]byte(nil), pkt.Data...)
+	n.enqueue(&scheduled{dir: outbound, fd: fd, flags: flags, pkt: pkt})
+	return len(pkt.Data)
+}
+
+// SendToBatch impairs every packet of the batch individually.
+func (n *Network) SendToBatch(fd int, packets []goxash3d_fwgs.Packet, flags int) int {
+	sum := 0
+	for _, pkt := range packets {
+		nn := n.SendTo(fd, pkt, flags)
+		if nn == -1 {
+			return -1
+		}
+		sum += nn
+	}
+	return sum
+}
+
+// enqueue applies the peer conditions and schedules the packet (and its duplicate).
+func (n *Network) enqueue(item *scheduled) {
+	n.mu.Lock()
+	c := n.conditions(item.pkt.Addr)
+	if c.Loss > 0 && rand.Float64() < c.Loss {
+		n.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	at := now
+	if c.Bandwidth > 0 {
+		key := linkKey{item.pkt.Addr, item.dir}
+		start := n.busy[key]
+		if start.Before(now) {
+			start = now
+		}
+		if start.Sub(now) > maxBacklog {
+			n.mu.Unlock()
+			return
+		}
+		at = start.Add(time.Duration(len(item.pkt.Data)) * time.Second / time.Duration(c.Bandwidth))
+		n.busy[key] = at
+	}
+
+	item.at = at.Add(delay(c))
+	n.push(item)
+	if c.Duplicate > 0 && rand.Float64() < c.Duplicate {
+		dup := *item
+		dup.at = at.Add(delay(c))
+		n.push(&dup)
+	}
+	n.mu.Unlock()
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// push adds the item to the heap. Caller holds mu.
+func (n *Network) push(item *scheduled) {
+	n.seq++
+	item.seq = n.seq
+	heap.Push(&n.queue, item)
+}
+
+// delay draws the one-way delay of a single packet.
+func delay(c Conditions) time.Duration {
+	d := c.Latency
+	if c.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * float64(c.Jitter))
+	}
+	if c.Reorder > 0 && rand.Float64() < c.Reorder {
+		d += max(c.Latency+c.Jitter, minReorderDelay)
+	}
+	return max(d, 0)
+}
+
+// run delivers scheduled packets when they are due.
+func (n *Network) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		n.mu.Lock()
+		now := time.Now()
+		var due []*scheduled
+		for len(n.queue) > 0 && !n.queue[0].at.After(now) {
+			due = append(due, heap.Pop(&n.queue).(*scheduled))
+		}
+		wait := time.Hour
+		if len(n.queue) > 0 {
+			wait = n.queue[0].at.Sub(now)
+		}
+		n.mu.Unlock()
+
+		for _, item := range due {
+			n.deliver(item)
+		}
+
+		timer.Reset(wait)
+		select {
+		case <-n.closed:
+			return
+		case <-n.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+func (n *Network) deliver(item *scheduled) {
+	switch item.dir {
+	case inbound:
+		n.Xash3DNetwork.(goxash3d_fwgs.PacketPusher).PushPacket(item.pkt)
+	case outbound:
+		n.Xash3DNetwork.SendTo(item.fd, item.pkt, item.flags)
+	}
+}
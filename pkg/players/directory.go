@@ -0,0 +1,58 @@
+package players
+
+import (
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"github.com/yohimik/goxash3d-fwgs/pkg/auth"
+	"sync"
+)
+
+// Directory attaches identities to the virtual addresses of transport peers,
+// so Go-side game logic can resolve the account behind an engine client.
+type Directory struct {
+	mu         sync.RWMutex
+	identities map[goxash3d_fwgs.Addr]*auth.Identity
+}
+
+// NewDirectory creates an empty Directory.
+func NewDirectory() *Directory {
+	return &Directory{
+		identities: make(map[goxash3d_fwgs.Addr]*auth.Identity),
+	}
+}
+
+// DefaultDirectory is the directory used by the bundled transports and Default.
+var DefaultDirectory = NewDirectory()
+
+// Bind attaches the identity to the virtual address.
+func (d *Directory) Bind(addr goxash3d_fwgs.Addr, identity *auth.Identity) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.identities[addr] = identity
+}
+
+// Unbind removes the identity attached to the virtual address.
+func (d *Directory) Unbind(addr goxash3d_fwgs.Addr) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.identities, addr)
+}
+
+// Lookup returns the identity attached to the virtual address.
+func (d *Directory) Lookup(addr goxash3d_fwgs.Addr) (*auth.Identity, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	identity, ok := d.identities[addr]
+	return identity, ok
+}
+
+// Find returns the address of the first peer authenticated as subject.
+func (d *Directory) Find(subject string) (goxash3d_fwgs.Addr, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for addr, identity := range d.identities {
+		if identity != nil && identity.Subject == subject {
+			return addr, true
+		}
+	}
+	return goxash3d_fwgs.Addr{}, false
+}
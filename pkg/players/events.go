@@ -0,0 +1,29 @@
+package players
+
+import (
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+)
+
+// Follow keeps the registry in step with the client events of the engine:
+// connecting clients get their slot and userid, dropped clients lose them.
+// It returns a function that stops following.
+func (r *Registry) Follow(x *goxash3d_fwgs.Xash3D) (stop func()) {
+	joined := func(e goxash3d_fwgs.Event) {
+		r.SetClient(e.Client.Addr, e.Client.Slot, e.Client.UserID)
+		if e.Client.Name != "" {
+			r.SetName(e.Client.Addr, e.Client.Name)
+		}
+	}
+	removes := []func(){
+		x.On(goxash3d_fwgs.EventClientConnect, joined),
+		x.On(goxash3d_fwgs.EventClientPutInServer, joined),
+		x.On(goxash3d_fwgs.EventClientDisconnect, func(e goxash3d_fwgs.Event) {
+			r.ClearClient(e.Client.Addr)
+		}),
+	}
+	return func() {
+		for _, remove := range removes {
+			remove()
+		}
+	}
+}
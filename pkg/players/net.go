@@ -0,0 +1,42 @@
+package players
+
+import (
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+)
+
+// Network wraps a Xash3DNetwork and feeds the traffic it carries into a Registry.
+type Network struct {
+	goxash3d_fwgs.Xash3DNetwork
+	Registry *Registry
+}
+
+// Tap wraps the network so the registry observes its connect handshakes.
+func Tap(net goxash3d_fwgs.Xash3DNetwork, registry *Registry) *Network {
+	return &Network{
+		Xash3DNetwork: net,
+		Registry:      registry,
+	}
+}
+
+// RecvFrom receives a packet from the wrapped network and observes it.
+func (n *Network) RecvFrom() *goxash3d_fwgs.Packet {
+	pkt := n.Xash3DNetwork.RecvFrom()
+	if pkt != nil {
+		n.Registry.ObserveInbound(*pkt)
+	}
+	return pkt
+}
+
+// SendTo observes the packet and sends it through the wrapped network.
+func (n *Network) SendTo(fd int, pkt goxash3d_fwgs.Packet, flags int) int {
+	n.Registry.ObserveOutbound(pkt)
+	return n.Xash3DNetwork.SendTo(fd, pkt, flags)
+}
+
+// SendToBatch observes the packets and sends them through the wrapped network.
+func (n *Network) SendToBatch(fd int, packets []goxash3d_fwgs.Packet, flags int) int {
+	for _, pkt := range packets {
+		n.Registry.ObserveOutbound(pkt)
+	}
+	return n.Xash3DNetwork.SendToBatch(fd, packets, flags)
+}
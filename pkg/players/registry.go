@@ -0,0 +1,279 @@
+// Package players correlates engine players (slot, userid, name) with the
+// transport peers that carry their traffic.
+//
+// The Registry learns about players from the connect handshake seen in
+// transport traffic (see Network) and from engine callbacks, and exposes
+// lookups in both directions: peer→player and player→peer.
+package players
+
+import (
+	"encoding/binary"
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"github.com/yohimik/goxash3d-fwgs/pkg/auth"
+	"github.com/yohimik/goxash3d-fwgs/pkg/netchan"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pendingTimeout is how long a player without a transport peer or an engine
+// slot is kept after its last connect request.
+const pendingTimeout = 30 * time.Second
+
+// Info is a parsed info string such as userinfo.
+type Info = netchan.Info
+
+// Player describes an engine client and the transport peer behind it.
+type Player struct {
+	// Addr is the (virtual) address the engine sees for this client.
+	Addr goxash3d_fwgs.Addr
+	// Slot is the zero based client slot, -1 until known.
+	Slot int
+	// UserID is the engine userid, -1 until known.
+	UserID int
+	// Name is the player name from userinfo.
+	Name string
+	// UserInfo is the userinfo sent with the connect request.
+	UserInfo Info
+	// ProtInfo is the protocol info sent with the connect request.
+	ProtInfo Info
+	// Protocol is the network protocol version of the client.
+	Protocol int
+	// Connected is true once the server accepted the connection.
+	Connected bool
+	// Since is when the peer was attached.
+	Since time.Time
+	// Peer is the transport session handle (e.g. *webrtc.PeerConnection).
+	Peer any
+	// Identity is the authenticated account of the peer, if any.
+	Identity *auth.Identity
+
+	// seen is the time of the last connect request
+	seen time.Time
+}
+
+// Registry holds the mapping between transport peers and engine players.
+type Registry struct {
+	mu      sync.RWMutex
+	players map[goxash3d_fwgs.Addr]*Player
+	pruned  time.Time
+
+	// Identities resolves authenticated accounts for peer addresses.
+	Identities *Directory
+}
+
+// NewRegistry creates an empty Registry backed by DefaultDirectory.
+func NewRegistry() *Registry {
+	return &Registry{
+		players:    make(map[goxash3d_fwgs.Addr]*Player),
+		Identities: DefaultDirectory,
+	}
+}
+
+// Default is the registry used by the bundled transports.
+var Default = NewRegistry()
+
+// entry returns the player for addr, creating it if needed. Caller holds mu.
+func (r *Registry) entry(addr goxash3d_fwgs.Addr) *Player {
+	p, ok := r.players[addr]
+	if !ok {
+		now := time.Now()
+		p = &Player{Addr: addr, Slot: -1, UserID: -1, Since: now, seen: now}
+		r.players[addr] = p
+	}
+	return p
+}
+
+// snapshot copies the player and resolves its identity. Caller holds mu.
+func (r *Registry) snapshot(p *Player) Player {
+	out := *p
+	if r.Identities != nil {
+		out.Identity, _ = r.Identities.Lookup(p.Addr)
+	}
+	return out
+}
+
+// AttachPeer registers the transport peer that owns the virtual address.
+func (r *Registry) AttachPeer(addr goxash3d_fwgs.Addr, peer any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entry(addr).Peer = peer
+}
+
+// DetachPeer forgets the peer and the player behind the virtual address.
+func (r *Registry) DetachPeer(addr goxash3d_fwgs.Addr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.players, addr)
+}
+
+// SetClient records the engine slot and userid of the player at addr,
+// typically from an engine callback (see Follow).
+func (r *Registry) SetClient(addr goxash3d_fwgs.Addr, slot, userID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := r.entry(addr)
+	p.Slot = slot
+	p.UserID = userID
+	p.Connected = true
+}
+
+// ClearClient forgets the engine slot and userid of the player at addr
+// after the engine dropped it. Players without a transport peer are removed.
+func (r *Registry) ClearClient(addr goxash3d_fwgs.Addr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.players[addr]
+	if !ok {
+		return
+	}
+	if p.Peer == nil {
+		delete(r.players, addr)
+		return
+	}
+	p.Slot = -1
+	p.UserID = -1
+	p.Connected = false
+}
+
+// SetName updates the player name, e.g. after a userinfo change.
+func (r *Registry) SetName(addr goxash3d_fwgs.Addr, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entry(addr).Name = name
+}
+
+// connectionless returns the out-of-band command carried by the packet, if any.
+func connectionless(pkt goxash3d_fwgs.Packet) (*netchan.Connectionless, bool) {
+	if len(pkt.Data) < 4 || binary.LittleEndian.Uint32(pkt.Data) != netchan.ConnectionlessHeader {
+		return nil, false
+	}
+	return netchan.ParseConnectionless(pkt.Data[4:]), true
+}
+
+// ObserveInbound inspects a client→server packet for the connect request.
+// Requests that never lead to an engine client nor a peer expire.
+func (r *Registry) ObserveInbound(pkt goxash3d_fwgs.Packet) {
+	c, ok := connectionless(pkt)
+	if !ok {
+		return
+	}
+	req, ok := c.Connect()
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now.Sub(r.pruned) > pendingTimeout {
+		r.prune(now)
+	}
+	p := r.entry(pkt.Addr)
+	p.seen = now
+	p.Protocol = req.Protocol
+	p.ProtInfo = req.ProtInfo
+	p.UserInfo = req.UserInfo
+	p.Name = req.UserInfo["name"]
+	p.Connected = false
+}
+
+// prune removes the players that neither have a transport peer nor hold an
+// engine slot since pendingTimeout, e.g. rejected or spoofed connects on
+// plain UDP. Caller holds mu.
+func (r *Registry) prune(now time.Time) {
+	for addr, p := range r.players {
+		if p.Peer == nil && p.Slot < 0 && now.Sub(p.seen) > pendingTimeout {
+			delete(r.players, addr)
+		}
+	}
+	r.pruned = now
+}
+
+// ObserveOutbound inspects a server→client packet for the connect acceptance.
+func (r *Registry) ObserveOutbound(pkt goxash3d_fwgs.Packet) {
+	c, ok := connectionless(pkt)
+	if !ok || !strings.HasPrefix(c.Command, "client_connect") {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p, ok := r.players[pkt.Addr]; ok {
+		p.Connected = true
+	}
+}
+
+// ByAddr returns the player behind the virtual address.
+func (r *Registry) ByAddr(addr goxash3d_fwgs.Addr) (Player, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.players[addr]
+	if !ok {
+		return Player{}, false
+	}
+	return r.snapshot(p), true
+}
+
+// ByPeer returns the player carried by the transport peer.
+func (r *Registry) ByPeer(peer any) (Player, bool) {
+	return r.find(func(p *Player) bool { return p.Peer == peer })
+}
+
+// BySlot returns the player in the engine client slot.
+func (r *Registry) BySlot(slot int) (Player, bool) {
+	return r.find(func(p *Player) bool { return p.Slot == slot && slot >= 0 })
+}
+
+// ByUserID returns the player with the engine userid.
+func (r *Registry) ByUserID(userID int) (Player, bool) {
+	return r.find(func(p *Player) bool { return p.UserID == userID && userID >= 0 })
+}
+
+// ByName returns the first player with the given name.
+func (r *Registry) ByName(name string) (Player, bool) {
+	return r.find(func(p *Player) bool { return p.Name == name })
+}
+
+// BySubject returns the player authenticated as the account subject.
+func (r *Registry) BySubject(subject string) (Player, bool) {
+	if r.Identities == nil {
+		return Player{}, false
+	}
+	addr, ok := r.Identities.Find(subject)
+	if !ok {
+		return Player{}, false
+	}
+	return r.ByAddr(addr)
+}
+
+// PeerOf returns the transport peer of the player at addr.
+func (r *Registry) PeerOf(addr goxash3d_fwgs.Addr) (any, bool) {
+	p, ok := r.ByAddr(addr)
+	if !ok || p.Peer == nil {
+		return nil, false
+	}
+	return p.Peer, true
+}
+
+// Players returns a snapshot of all known players.
+func (r *Registry) Players() []Player {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Player, 0, len(r.players))
+	for _, p := range r.players {
+		out = append(out, r.snapshot(p))
+	}
+	return out
+}
+
+func (r *Registry) find(match func(p *Player) bool) (Player, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, p := range r.players {
+		if match(p) {
+			return r.snapshot(p), true
+		}
+	}
+	return Player{}, false
+}
@@ -141,3 +141,15 @@ func (p *BytesPool) TryGet() (uint8, error) {
 
 // Capacity returns the configured capacity of the pool.
 func (p *BytesPool) Capacity() int { return len(p.slots) }
+
+// Len returns the approximate number of indexes available in the pool.
+func (p *BytesPool) Len() int {
+	n := int(atomic.LoadUint32(&p.head) - atomic.LoadUint32(&p.tail))
+	if n < 0 {
+		return 0
+	}
+	if n > len(p.slots) {
+		return len(p.slots)
+	}
+	return n
+}
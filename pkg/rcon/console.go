@@ -0,0 +1,218 @@
+package rcon
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"github.com/yohimik/goxash3d-fwgs/pkg/netchan"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrBadCommand = errors.New("rcon: command is empty or spans several lines")
+	ErrClosed     = errors.New("rcon: console closed")
+)
+
+// Output is console output printed in response to a command.
+type Output struct {
+	Time    time.Time `json:"time"`
+	By      string    `json:"by,omitempty"`
+	Command string    `json:"command"`
+	Text    string    `json:"text"`
+}
+
+// ConsoleOptions configures a Console.
+type ConsoleOptions struct {
+	// Password is the engine rcon_password, generated when empty.
+	// The console sets it with Exec; Follow sets it again whenever a
+	// configuration executed by a map change may have replaced it.
+	Password string
+	// Exec runs console commands, DefaultXash3D.ExecCommand by default.
+	Exec func(cmd string)
+	// Quiet ends the output of a command after a pause, 250ms by default.
+	Quiet time.Duration
+	// Timeout bounds the output of a command, 3s by default.
+	Timeout time.Duration
+}
+
+// Console runs commands through the engine rcon of an in-process peer.
+type Console struct {
+	peer *goxash3d_fwgs.LocalPeer
+	opts ConsoleOptions
+
+	// run serializes commands so output is attributed to the right one
+	run sync.Mutex
+
+	mu          sync.Mutex
+	current     *Output
+	printed     chan struct{}
+	subscribers map[chan Output]struct{}
+}
+
+// NewConsole creates a console on the peer and sets the engine rcon_password.
+func NewConsole(peer *goxash3d_fwgs.LocalPeer, opts ConsoleOptions) *Console {
+	if opts.Password == "" {
+		raw := make([]byte, 16)
+		_, _ = rand.Read(raw)
+		opts.Password = hex.EncodeToString(raw)
+	}
+	if opts.Exec == nil {
+		opts.Exec = goxash3d_fwgs.DefaultXash3D.ExecCommand
+	}
+	if opts.Quiet == 0 {
+		opts.Quiet = 250 * time.Millisecond
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 3 * time.Second
+	}
+	c := &Console{
+		peer:        peer,
+		opts:        opts,
+		printed:     make(chan struct{}, 1),
+		subscribers: make(map[chan Output]struct{}),
+	}
+	c.setPassword()
+	return c
+}
+
+// Follow sets rcon_password again every time a server activates, after
+// server.cfg and the map configs ran. It returns a function that stops following.
+func (c *Console) Follow(x *goxash3d_fwgs.Xash3D) (stop func()) {
+	return x.On(goxash3d_fwgs.EventServerActivated, func(goxash3d_fwgs.Event) {
+		c.setPassword()
+	})
+}
+
+func (c *Console) setPassword() {
+	c.opts.Exec(`rcon_password "` + c.opts.Password + `"`)
+}
+
+// Addr returns the address of the console peer, to be allowed by a Filter.
+func (c *Console) Addr() goxash3d_fwgs.Addr {
+	return c.peer.Addr()
+}
+
+// Run reads the engine output until the peer is closed or ctx is done.
+func (c *Console) Run(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		c.peer.Close()
+	}()
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := c.peer.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if text, ok := printText(buf[:n]); ok {
+			c.print(text)
+		}
+	}
+}
+
+// printText returns the text of a connectionless "print" packet.
+func printText(data []byte) (string, bool) {
+	if len(data) < 4 || binary.LittleEndian.Uint32(data) != netchan.ConnectionlessHeader {
+		return "", false
+	}
+	data = bytes.TrimRight(data[4:], "\x00")
+	if !bytes.HasPrefix(data, []byte("print")) {
+		return "", false
+	}
+	data = bytes.TrimPrefix(data[len("print"):], []byte("\n"))
+	return string(data), true
+}
+
+func (c *Console) print(text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current == nil {
+		return
+	}
+	c.current.Text += text
+	out := Output{Time: time.Now(), By: c.current.By, Command: c.current.Command, Text: text}
+	for ch := range c.subscribers {
+		select {
+		case ch <- out:
+		default:
+		}
+	}
+	select {
+	case c.printed <- struct{}{}:
+	default:
+	}
+}
+
+// Execute runs the command and returns its output. by names the caller
+// in the output stream.
+func (c *Console) Execute(ctx context.Context, by, command string) (Output, error) {
+	command = strings.TrimSpace(command)
+	if command == "" || strings.ContainsAny(command, "\r\n") {
+		return Output{}, ErrBadCommand
+	}
+	c.run.Lock()
+	defer c.run.Unlock()
+
+	c.mu.Lock()
+	c.current = &Output{Time: time.Now(), By: by, Command: command}
+	select {
+	case <-c.printed:
+	default:
+	}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.current = nil
+		c.mu.Unlock()
+	}()
+
+	packet := append([]byte{0xff, 0xff, 0xff, 0xff}, "rcon "+c.opts.Password+" "+command+"\n"...)
+	if _, err := c.peer.Write(packet); err != nil {
+		return Output{}, ErrClosed
+	}
+
+	deadline := time.NewTimer(c.opts.Timeout)
+	defer deadline.Stop()
+	// the engine may take a few frames to pick the command up
+	quiet := time.NewTimer(4 * c.opts.Quiet)
+	defer quiet.Stop()
+	for done := false; !done; {
+		select {
+		case <-ctx.Done():
+			return Output{}, ctx.Err()
+		case <-c.printed:
+			quiet.Reset(c.opts.Quiet)
+		case <-quiet.C:
+			done = true
+		case <-deadline.C:
+			done = true
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return *c.current, nil
+}
+
+// Subscribe streams the output of every command until cancel is called.
+// Output is dropped when the channel is not drained.
+func (c *Console) Subscribe() (<-chan Output, func()) {
+	ch := make(chan Output, 64)
+	c.mu.Lock()
+	c.subscribers[ch] = struct{}{}
+	c.mu.Unlock()
+	return ch, func() {
+		c.mu.Lock()
+		delete(c.subscribers, ch)
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,83 @@
+// Package rcon replaces the classic GoldSrc rcon with an authenticated
+// console over HTTP.
+//
+// Classic rcon sends the password in plaintext connectionless packets, and
+// behind a Go transport it arrives from a virtual address. A Filter drops
+// those packets before they reach the engine. A Console runs commands
+// through the engine's own rcon from an in-process LocalPeer with a
+// generated password and captures the printed output, which Handler serves
+// as JSON responses and as a server-sent event stream.
+package rcon
+
+import (
+	"encoding/binary"
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"github.com/yohimik/goxash3d-fwgs/pkg/netchan"
+	"sync"
+	"sync/atomic"
+)
+
+// Filter wraps a Xash3DNetwork and drops classic rcon packets.
+type Filter struct {
+	goxash3d_fwgs.Xash3DNetwork
+
+	disabled atomic.Bool
+	dropped  atomic.Uint64
+
+	mu      sync.RWMutex
+	allowed map[goxash3d_fwgs.Addr]bool
+}
+
+// NewFilter wraps the network. Classic rcon stays enabled until Disable.
+func NewFilter(net goxash3d_fwgs.Xash3DNetwork) *Filter {
+	return &Filter{
+		Xash3DNetwork: net,
+		allowed:       make(map[goxash3d_fwgs.Addr]bool),
+	}
+}
+
+// Disable drops classic rcon packets from every address but the allowed ones.
+func (f *Filter) Disable(disabled bool) {
+	f.disabled.Store(disabled)
+}
+
+// Disabled reports whether classic rcon is dropped.
+func (f *Filter) Disabled() bool {
+	return f.disabled.Load()
+}
+
+// Allow lets rcon packets from addr through, e.g. those of a Console.
+func (f *Filter) Allow(addr goxash3d_fwgs.Addr) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.allowed[addr] = true
+}
+
+// Dropped returns the number of rcon packets dropped.
+func (f *Filter) Dropped() uint64 {
+	return f.dropped.Load()
+}
+
+// RecvFrom receives packets from the wrapped network, skipping rejected rcon packets.
+func (f *Filter) RecvFrom() *goxash3d_fwgs.Packet {
+	for {
+		pkt := f.Xash3DNetwork.RecvFrom()
+		if pkt == nil || !f.reject(*pkt) {
+			return pkt
+		}
+		f.dropped.Add(1)
+	}
+}
+
+func (f *Filter) reject(pkt goxash3d_fwgs.Packet) bool {
+	if !f.disabled.Load() || len(pkt.Data) < 4 || binary.LittleEndian.Uint32(pkt.Data) != netchan.ConnectionlessHeader {
+		return false
+	}
+	c := netchan.ParseConnectionless(pkt.Data[4:])
+	if c.Command != "rcon" && !(c.Command == "challenge" && len(c.Args) > 1 && c.Args[1] == "rcon") {
+		return false
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return !f.allowed[pkt.Addr]
+}
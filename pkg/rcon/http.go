@@ -0,0 +1,100 @@
+package rcon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HandlerOptions configures Console.Handler.
+type HandlerOptions struct {
+	// Authorize returns the name of the caller, or false to reject the
+	// request. Without it every request is rejected.
+	Authorize func(r *http.Request) (string, bool)
+	// Audit is called for every command before it runs, it may be nil.
+	Audit func(r *http.Request, by, command string)
+}
+
+type commandRequest struct {
+	Command string `json:"command"`
+}
+
+// Handler serves the console. Mount it with http.StripPrefix:
+//
+//	POST /         {"command":"status"} runs a command and returns its Output
+//	GET  /stream   streams the Output of every command as server-sent events
+func (c *Console) Handler(opts HandlerOptions) http.Handler {
+	mux := http.NewServeMux()
+	authorize := func(w http.ResponseWriter, r *http.Request) (string, bool) {
+		if opts.Authorize == nil {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return "", false
+		}
+		by, ok := opts.Authorize(r)
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		}
+		return by, ok
+	}
+
+	mux.HandleFunc("POST /{$}", func(w http.ResponseWriter, r *http.Request) {
+		by, ok := authorize(w, r)
+		if !ok {
+			return
+		}
+		var req commandRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if opts.Audit != nil {
+			opts.Audit(r, by, req.Command)
+		}
+		out, err := c.Execute(r.Context(), by, req.Command)
+		switch {
+		case errors.Is(err, ErrBadCommand):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	})
+
+	mux.HandleFunc("GET /stream", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authorize(w, r); !ok {
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		outputs, cancel := c.Subscribe()
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(15 * time.Second)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case out := <-outputs:
+				data, _ := json.Marshal(out)
+				fmt.Fprintf(w, "event: output\ndata: %s\n\n", data)
+			}
+			flusher.Flush()
+		}
+	})
+	return mux
+}
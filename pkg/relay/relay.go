@@ -0,0 +1,221 @@
+package relay
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/yohimik/goxash3d-fwgs/pkg/client"
+	"github.com/yohimik/goxash3d-fwgs/pkg/netchan"
+	"sync"
+	"time"
+)
+
+// Frame flags.
+const (
+	// FlagReliable marks frames carrying reliable data. They are replayed
+	// to viewers joining after the frame was released.
+	FlagReliable = 1 << 0
+	// FlagServerData marks frames starting a new map.
+	FlagServerData = 1 << 1
+)
+
+// FrameHeaderLen is the size of the frame header: the stream time in
+// milliseconds (uint32), the flags (uint8) and the length of the assembled
+// fragment buffer (uint32), all little-endian. The assembled buffer and
+// the packet payload follow the header.
+const FrameHeaderLen = 9
+
+// Options configures a Relay.
+type Options struct {
+	// Delay holds frames back before they reach viewers.
+	Delay time.Duration
+	// Client configures the single engine connection. The name defaults
+	// to "relay" and the userinfo marks the client as HLTV proxy.
+	Client client.Options
+	// ViewerQueue is the number of frames buffered per viewer, defaults to 256.
+	ViewerQueue int
+	// MaxSignon bounds the bytes of reliable frames kept for joining viewers,
+	// defaults to 4 MiB.
+	MaxSignon int
+	// ReconnectDelay is the pause between engine connection attempts, defaults to 5s.
+	ReconnectDelay time.Duration
+}
+
+// Dialer opens the transport of the engine connection, e.g. a
+// goxash3d_fwgs.LocalNet peer or client.DialUDP.
+type Dialer func(ctx context.Context) (client.Transport, error)
+
+type frame struct {
+	at    time.Time
+	flags byte
+	data  []byte
+}
+
+// Relay connects to the engine once and fans the game stream out to any
+// number of viewers after a delay, keeping viewer traffic off the engine.
+type Relay struct {
+	opts  Options
+	start time.Time
+	wake  chan struct{}
+
+	mu          sync.Mutex
+	pending     []frame
+	signon      [][]byte
+	signonBytes int
+	viewers     map[*Viewer]struct{}
+}
+
+// New creates a relay.
+func New(opts Options) *Relay {
+	if opts.Client.Name == "" {
+		opts.Client.Name = "relay"
+	}
+	if opts.Client.UserInfo == nil {
+		opts.Client.UserInfo = netchan.Info{"*hltv": "1"}
+	}
+	if opts.ViewerQueue <= 0 {
+		opts.ViewerQueue = 256
+	}
+	if opts.MaxSignon <= 0 {
+		opts.MaxSignon = 4 << 20
+	}
+	if opts.ReconnectDelay <= 0 {
+		opts.ReconnectDelay = 5 * time.Second
+	}
+	return &Relay{
+		opts:    opts,
+		start:   time.Now(),
+		wake:    make(chan struct{}, 1),
+		viewers: make(map[*Viewer]struct{}),
+	}
+}
+
+// Run keeps the engine connection up and dispatches frames until ctx ends.
+func (r *Relay) Run(ctx context.Context, dial Dialer) error {
+	go r.dispatch(ctx)
+
+	opts := r.opts.Client
+	opts.OnPacket = r.observe
+	for {
+		if err := r.session(ctx, dial, opts); ctx.Err() != nil {
+			return ctx.Err()
+		} else if err != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(r.opts.ReconnectDelay):
+			}
+		}
+	}
+}
+
+func (r *Relay) session(ctx context.Context, dial Dialer, opts client.Options) error {
+	t, err := dial(ctx)
+	if err != nil {
+		return err
+	}
+	c, err := client.Connect(ctx, t, opts)
+	if err != nil {
+		t.Close()
+		return err
+	}
+	defer c.Close()
+	return c.Run(ctx)
+}
+
+// observe queues a received packet as frame.
+func (r *Relay) observe(pkt *netchan.Packet) {
+	var flags byte
+	if pkt.Header.Reliable || pkt.Assembled != nil {
+		flags |= FlagReliable
+	}
+	for _, msg := range pkt.Messages {
+		if msg.Op == netchan.SvcServerData {
+			flags |= FlagServerData
+		}
+	}
+	now := time.Now()
+	data := make([]byte, FrameHeaderLen, FrameHeaderLen+len(pkt.Assembled)+len(pkt.Payload))
+	binary.LittleEndian.PutUint32(data, uint32(now.Sub(r.start).Milliseconds()))
+	data[4] = flags
+	binary.LittleEndian.PutUint32(data[5:], uint32(len(pkt.Assembled)))
+	data = append(data, pkt.Assembled...)
+	data = append(data, pkt.Payload...)
+
+	r.mu.Lock()
+	r.pending = append(r.pending, frame{at: now, flags: flags, data: data})
+	r.mu.Unlock()
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch releases delayed frames to the viewers.
+func (r *Relay) dispatch(ctx context.Context) {
+	timer := time.NewTimer(r.opts.Delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			r.mu.Lock()
+			for v := range r.viewers {
+				v.close()
+			}
+			r.mu.Unlock()
+			return
+		case <-r.wake:
+		case <-timer.C:
+		}
+
+		r.mu.Lock()
+		now := time.Now()
+		var released []frame
+		for _, f := range r.pending {
+			if now.Sub(f.at) < r.opts.Delay {
+				break
+			}
+			r.retain(f)
+			released = append(released, f)
+		}
+		r.pending = append(r.pending[:0], r.pending[len(released):]...)
+		next := r.opts.Delay
+		if len(r.pending) > 0 {
+			next = r.opts.Delay - now.Sub(r.pending[0].at)
+		}
+		// viewers joining from here on get the released frames with the signon
+		var viewers []*Viewer
+		if len(released) > 0 {
+			viewers = make([]*Viewer, 0, len(r.viewers))
+			for v := range r.viewers {
+				viewers = append(viewers, v)
+			}
+		}
+		r.mu.Unlock()
+
+		for _, f := range released {
+			for _, v := range viewers {
+				v.send(f.data, f.flags&FlagReliable != 0)
+			}
+		}
+		timer.Reset(next)
+	}
+}
+
+// retain keeps reliable frames for joining viewers, the relay lock must be held.
+func (r *Relay) retain(f frame) {
+	if f.flags&FlagServerData != 0 {
+		r.signon = nil
+		r.signonBytes = 0
+	}
+	if f.flags&FlagReliable != 0 && r.signonBytes+len(f.data) <= r.opts.MaxSignon {
+		r.signon = append(r.signon, f.data)
+		r.signonBytes += len(f.data)
+	}
+}
+
+// Viewers returns the number of attached viewers.
+func (r *Relay) Viewers() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.viewers)
+}
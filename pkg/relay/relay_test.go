@@ -0,0 +1,144 @@
+package relay
+
+import (
+	"context"
+	"github.com/yohimik/goxash3d-fwgs/pkg/netchan"
+	"testing"
+	"time"
+)
+
+// frames is a viewer writer collecting the written frames.
+type frames chan []byte
+
+func (f frames) Write(b []byte) (int, error) {
+	f <- append([]byte(nil), b...)
+	return len(b), nil
+}
+
+// stuck is a viewer writer that blocks until the test ends.
+type stuck chan struct{}
+
+func (s stuck) Write(b []byte) (int, error) {
+	<-s
+	return len(b), nil
+}
+
+// packet returns a received packet whose payload is the single byte id.
+func packet(id byte, reliable, serverData bool) *netchan.Packet {
+	pkt := &netchan.Packet{Header: netchan.Header{Reliable: reliable}, Payload: []byte{id}}
+	if serverData {
+		pkt.Messages = []netchan.Message{{Op: netchan.SvcServerData}}
+	}
+	return pkt
+}
+
+func start(t *testing.T, opts Options) *Relay {
+	t.Helper()
+	r := New(opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go r.dispatch(ctx)
+	return r
+}
+
+// expect reads the next frames of a viewer and checks their payload ids.
+func expect(t *testing.T, f frames, ids ...byte) {
+	t.Helper()
+	for _, id := range ids {
+		select {
+		case data := <-f:
+			if len(data) != FrameHeaderLen+1 || data[FrameHeaderLen] != id {
+				t.Fatalf("got frame %v, want payload %d", data, id)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("frame %d not received", id)
+		}
+	}
+}
+
+// expectNone checks that a viewer receives no frame for d.
+func expectNone(t *testing.T, f frames, d time.Duration) {
+	t.Helper()
+	select {
+	case data := <-f:
+		t.Fatalf("unexpected frame %v", data)
+	case <-time.After(d):
+	}
+}
+
+func TestRelayDelay(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	r := start(t, Options{Delay: delay})
+	f := make(frames, 16)
+	r.AddViewer(f)
+
+	begin := time.Now()
+	r.observe(packet(1, true, true))
+	expectNone(t, f, delay/2)
+	select {
+	case data := <-f:
+		if elapsed := time.Since(begin); elapsed < delay {
+			t.Errorf("frame released after %v, want at least %v", elapsed, delay)
+		}
+		if data[4] != FlagReliable|FlagServerData {
+			t.Errorf("flags %#x, want %#x", data[4], FlagReliable|FlagServerData)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("frame not received")
+	}
+}
+
+func TestRelayLateViewer(t *testing.T) {
+	// room for two reliable frames
+	r := start(t, Options{Delay: 10 * time.Millisecond, MaxSignon: 2 * (FrameHeaderLen + 1)})
+	early := make(frames, 16)
+	r.AddViewer(early)
+
+	r.observe(packet(1, true, true))
+	r.observe(packet(2, false, false))
+	r.observe(packet(3, true, false))
+	r.observe(packet(4, true, false))
+	expect(t, early, 1, 2, 3, 4)
+
+	// a late viewer gets the reliable frames of the map up to MaxSignon,
+	// then the live frames
+	late := make(frames, 16)
+	r.AddViewer(late)
+	expect(t, late, 1, 3)
+	expectNone(t, late, 50*time.Millisecond)
+	r.observe(packet(5, false, false))
+	expect(t, late, 5)
+	expect(t, early, 5)
+
+	// a new map starts the signon over
+	r.observe(packet(6, true, true))
+	expect(t, late, 6)
+	next := make(frames, 16)
+	r.AddViewer(next)
+	expect(t, next, 6)
+	expectNone(t, next, 50*time.Millisecond)
+}
+
+func TestRelayLaggingViewer(t *testing.T) {
+	r := start(t, Options{Delay: time.Millisecond, ViewerQueue: 1})
+	blocked := make(stuck)
+	t.Cleanup(func() { close(blocked) })
+	lagging := r.AddViewer(blocked)
+	f := make(frames, 16)
+	r.AddViewer(f)
+
+	// the lagging viewer overflows on a reliable frame and is detached,
+	// the other one keeps receiving
+	for id := byte(1); id <= 4; id++ {
+		r.observe(packet(id, true, false))
+		expect(t, f, id)
+	}
+	select {
+	case <-lagging.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("lagging viewer not detached")
+	}
+	if r.Viewers() != 1 {
+		t.Errorf("%d viewers, want 1", r.Viewers())
+	}
+}
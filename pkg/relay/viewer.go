@@ -0,0 +1,101 @@
+package relay
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Viewer is a spectator receiving frames through a writer, e.g. a detached
+// WebRTC data channel. Each frame is written with a single Write call.
+type Viewer struct {
+	relay   *Relay
+	w       io.Writer
+	signon  [][]byte
+	queue   chan []byte
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Uint64
+}
+
+// AddViewer attaches a viewer. It first receives the reliable frames of the
+// current map, then the live frames as they are released.
+func (r *Relay) AddViewer(w io.Writer) *Viewer {
+	r.mu.Lock()
+	v := &Viewer{
+		relay:  r,
+		w:      w,
+		signon: append([][]byte(nil), r.signon...),
+		queue:  make(chan []byte, r.opts.ViewerQueue),
+		done:   make(chan struct{}),
+	}
+	r.viewers[v] = struct{}{}
+	r.mu.Unlock()
+	go v.writeLoop()
+	return v
+}
+
+// Done is closed once the viewer is detached.
+func (v *Viewer) Done() <-chan struct{} {
+	return v.done
+}
+
+// Dropped returns the number of frames dropped because the viewer lagged.
+func (v *Viewer) Dropped() uint64 {
+	return v.dropped.Load()
+}
+
+// Close detaches the viewer from the relay.
+func (v *Viewer) Close() {
+	v.relay.mu.Lock()
+	v.close()
+	v.relay.mu.Unlock()
+}
+
+// close detaches the viewer; the relay lock must be held.
+func (v *Viewer) close() {
+	v.once.Do(func() {
+		delete(v.relay.viewers, v)
+		close(v.done)
+	})
+}
+
+// send queues a frame without blocking. Unreliable frames are dropped when
+// the viewer lags behind; a lost reliable frame would corrupt its state, so
+// it is detached. Frames sent after the detach are dropped.
+func (v *Viewer) send(data []byte, reliable bool) {
+	select {
+	case <-v.done:
+		return
+	default:
+	}
+	select {
+	case v.queue <- data:
+	default:
+		v.dropped.Add(1)
+		if reliable {
+			v.Close()
+		}
+	}
+}
+
+func (v *Viewer) writeLoop() {
+	for _, data := range v.signon {
+		if _, err := v.w.Write(data); err != nil {
+			v.Close()
+			return
+		}
+	}
+	v.signon = nil
+	for {
+		select {
+		case <-v.done:
+			return
+		case data := <-v.queue:
+			if _, err := v.w.Write(data); err != nil {
+				v.Close()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Package voice decides which players hear each other, per pair of
+// speaker and listener, from the player registry and the game state.
+package voice
+
+import (
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"github.com/yohimik/goxash3d-fwgs/pkg/players"
+	"math"
+	"sync"
+)
+
+// Rules configures voice routing. The zero value forwards everything.
+type Rules struct {
+	// TeamOnly restricts voice to players of the same team.
+	TeamOnly bool
+	// DeadTalk keeps dead players from reaching the living; the dead
+	// still hear everyone.
+	DeadTalk bool
+	// Proximity is the distance beyond which players cannot hear each
+	// other, zero disables proximity voice.
+	Proximity float32
+	// Falloff is the distance at which the gain starts fading linearly
+	// towards zero at Proximity.
+	Falloff float32
+}
+
+// Decision is the routing result for a pair of players.
+type Decision struct {
+	// Forward reports whether the speaker's audio reaches the listener.
+	Forward bool
+	// Gain is the suggested playback volume in [0, 1]. Media is forwarded
+	// unchanged, so the listener applies it.
+	Gain float32
+}
+
+var (
+	forward = Decision{Forward: true, Gain: 1}
+	drop    = Decision{}
+)
+
+type pair struct {
+	listener, speaker goxash3d_fwgs.Addr
+}
+
+// Router decides voice forwarding between players.
+type Router struct {
+	Registry *players.Registry
+	States   StateSource
+
+	mu    sync.RWMutex
+	rules Rules
+	mutes map[pair]struct{}
+}
+
+// NewRouter creates a router over the registry and state source.
+func NewRouter(registry *players.Registry, states StateSource, rules Rules) *Router {
+	return &Router{
+		Registry: registry,
+		States:   states,
+		rules:    rules,
+		mutes:    make(map[pair]struct{}),
+	}
+}
+
+// DefaultRouter routes voice for the players of players.Default.
+var DefaultRouter = NewRouter(players.Default, DefaultTable, Rules{})
+
+// Rules returns the current rules.
+func (r *Router) Rules() Rules {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.rules
+}
+
+// SetRules replaces the rules.
+func (r *Router) SetRules(rules Rules) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules = rules
+}
+
+// Mute stops the speaker from reaching the listener.
+func (r *Router) Mute(listener, speaker goxash3d_fwgs.Addr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mutes[pair{listener, speaker}] = struct{}{}
+}
+
+// Unmute reverts Mute.
+func (r *Router) Unmute(listener, speaker goxash3d_fwgs.Addr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.mutes, pair{listener, speaker})
+}
+
+// Muted reports whether the listener muted the speaker.
+func (r *Router) Muted(listener, speaker goxash3d_fwgs.Addr) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.mutes[pair{listener, speaker}]
+	return ok
+}
+
+// Forget drops the mutes of and against a disconnected player.
+func (r *Router) Forget(addr goxash3d_fwgs.Addr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for p := range r.mutes {
+		if p.listener == addr || p.speaker == addr {
+			delete(r.mutes, p)
+		}
+	}
+}
+
+// Route decides whether the speaker is heard by the listener. Rules that
+// need state nobody provided are not applied.
+func (r *Router) Route(speaker, listener goxash3d_fwgs.Addr) Decision {
+	if speaker == listener || r.Muted(listener, speaker) {
+		return drop
+	}
+	rules := r.Rules()
+	if rules == (Rules{}) {
+		return forward
+	}
+
+	sp, ok := r.Registry.ByAddr(speaker)
+	if !ok {
+		return forward
+	}
+	lp, ok := r.Registry.ByAddr(listener)
+	if !ok {
+		return forward
+	}
+	ss, ok := r.States.State(sp)
+	if !ok {
+		return forward
+	}
+	ls, ok := r.States.State(lp)
+	if !ok {
+		return forward
+	}
+
+	if rules.DeadTalk && !ss.Alive && ls.Alive {
+		return drop
+	}
+	if rules.TeamOnly && ss.Team != "" && ls.Team != "" && ss.Team != ls.Team {
+		return drop
+	}
+	if rules.Proximity > 0 && ss.HasOrigin && ls.HasOrigin && ss.Alive && ls.Alive {
+		return proximity(rules, distance(ss.Origin, ls.Origin))
+	}
+	return forward
+}
+
+func proximity(rules Rules, d float32) Decision {
+	if d >= rules.Proximity {
+		return drop
+	}
+	if d <= rules.Falloff || rules.Falloff >= rules.Proximity {
+		return forward
+	}
+	return Decision{Forward: true, Gain: 1 - (d-rules.Falloff)/(rules.Proximity-rules.Falloff)}
+}
+
+func distance(a, b [3]float32) float32 {
+	dx, dy, dz := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return float32(math.Sqrt(float64(dx*dx + dy*dy + dz*dz)))
+}
@@ -0,0 +1,120 @@
+package voice
+
+import (
+	"github.com/yohimik/goxash3d-fwgs/pkg"
+	"github.com/yohimik/goxash3d-fwgs/pkg/players"
+	"strings"
+	"sync"
+)
+
+// State is the game state of a player that voice routing depends on.
+type State struct {
+	// Team is the team name, empty when teams are not played.
+	Team string
+	// Alive is false for dead players and spectators.
+	Alive bool
+	// Origin is the position in world units, used by proximity rules.
+	Origin [3]float32
+	// HasOrigin is set once Origin is known.
+	HasOrigin bool
+}
+
+// StateSource provides the game state of players, e.g. from engine callbacks.
+type StateSource interface {
+	State(p players.Player) (State, bool)
+}
+
+// Table is a StateSource updated by Go code observing the game, usually
+// from the engine through Follow. Players without an entry take their team
+// from the "team" userinfo key and count as alive.
+type Table struct {
+	mu     sync.RWMutex
+	states map[goxash3d_fwgs.Addr]State
+}
+
+// NewTable creates an empty state table.
+func NewTable() *Table {
+	return &Table{states: make(map[goxash3d_fwgs.Addr]State)}
+}
+
+// DefaultTable is the state table used by DefaultRouter.
+var DefaultTable = NewTable()
+
+// Set replaces the state of the player at addr.
+func (t *Table) Set(addr goxash3d_fwgs.Addr, s State) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.states[addr] = s
+}
+
+// Update changes the state of the player at addr in place.
+func (t *Table) Update(addr goxash3d_fwgs.Addr, update func(s *State)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.states[addr]
+	if !ok {
+		s = State{Alive: true}
+	}
+	update(&s)
+	t.states[addr] = s
+}
+
+// Delete forgets the player at addr.
+func (t *Table) Delete(addr goxash3d_fwgs.Addr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.states, addr)
+}
+
+// Follow fills the table from the client states the engine reports and
+// forgets dropped clients. It returns a function that stops following.
+func (t *Table) Follow(x *goxash3d_fwgs.Xash3D) (stop func()) {
+	removes := []func(){
+		x.On(goxash3d_fwgs.EventClientState, func(e goxash3d_fwgs.Event) {
+			t.Set(e.Client.Addr, State{
+				Team:      team(e.State),
+				Alive:     e.State.Alive,
+				Origin:    e.State.Origin,
+				HasOrigin: true,
+			})
+		}),
+		x.On(goxash3d_fwgs.EventClientDisconnect, func(e goxash3d_fwgs.Event) {
+			t.Delete(e.Client.Addr)
+		}),
+	}
+	return func() {
+		for _, remove := range removes {
+			remove()
+		}
+	}
+}
+
+// csTeams maps the player models of Counter-Strike to their team, the
+// game keeps the team itself out of the userinfo.
+var csTeams = map[string]string{
+	"terror": "TERRORIST", "leet": "TERRORIST", "arctic": "TERRORIST", "guerilla": "TERRORIST", "militia": "TERRORIST",
+	"urban": "CT", "gsg9": "CT", "sas": "CT", "gign": "CT", "spetsnaz": "CT", "vip": "CT",
+}
+
+// team returns the team of a client: the "team" key Half-Life teamplay
+// sets, else the team of a Counter-Strike model.
+func team(s goxash3d_fwgs.ClientState) string {
+	if s.Team != "" {
+		return s.Team
+	}
+	return csTeams[strings.ToLower(s.Model)]
+}
+
+// State returns the state of the player.
+func (t *Table) State(p players.Player) (State, bool) {
+	t.mu.RLock()
+	s, ok := t.states[p.Addr]
+	t.mu.RUnlock()
+	if !ok {
+		s = State{Alive: true}
+	}
+	if s.Team == "" {
+		s.Team = p.UserInfo["team"]
+	}
+	return s, true
+}
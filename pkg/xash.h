@@ -10,6 +10,20 @@ typedef void( *pfnChangeGame )( const char *progname );
 static void Sys_ChangeGame( const char *progname ) {}
 
 int Host_Main( int argc, char **argv, const char *progname, int bChangeGame, pfnChangeGame func );
+void Cbuf_AddText( const char *text );
+
+typedef void( *xcommand_t )( void );
+
+void Cmd_AddCommand( const char *cmd_name, xcommand_t function, const char *cmd_desc );
+int Cmd_Argc( void );
+const char *Cmd_Argv( int arg );
+void Con_Printf( const char *szFmt, ... );
+
+typedef struct convar_s convar_t;
+
+convar_t *Cvar_Get( const char *var_name, const char *value, int flags, const char *var_desc );
+convar_t *Cvar_FindVarExt( const char *var_name, int ignore_group );
+const char *Cvar_VariableString( const char *var_name );
 
 #ifdef __cplusplus
 }
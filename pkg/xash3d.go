@@ -3,6 +3,12 @@ package goxash3d_fwgs
 // Xash3D Represents an instance of Xash3D-FWGS engine.
 type Xash3D struct {
 	Net Xash3DNetwork
+
+	commands       commandQueue
+	console        consoleCommands
+	clientCommands clientCommandFilters
+	cvars          cvarRegistry
+	events         eventDispatcher
 }
 
 // newXash3D Constructs new Xash3D instance.